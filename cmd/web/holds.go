@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/redblue-blur/bookings/internal/handlers"
+	"github.com/redblue-blur/bookings/internal/repository"
+)
+
+//releaseExpiredHolds deletes the holds that ran out every interval, it runs until the program exits
+func releaseExpiredHolds(repo repository.DatabaseRepo, every time.Duration) {
+	for range time.Tick(every) {
+		n, err := repo.DeleteExpiredHolds(context.Background(), time.Now())
+		if err != nil {
+			app.ErrorLog.Println("cannot release expired holds:", err)
+			continue
+		}
+		if n > 0 {
+			app.InfoLog.Printf("released %d expired holds", n)
+		}
+	}
+}
+
+//offerExpiredClaims passes waitlist claims nobody used on to the next guest in line every interval,
+//it runs until the program exits
+func offerExpiredClaims(repo *handlers.Repository, every time.Duration) {
+	for range time.Tick(every) {
+		if err := repo.OfferExpiredClaims(context.Background()); err != nil {
+			app.ErrorLog.Println("cannot pass on expired waitlist claims:", err)
+		}
+	}
+}
+
+//syncRoomCalendars imports the calendars of other sites into the rooms every interval, it runs until the
+//program exits
+func syncRoomCalendars(repo *handlers.Repository, every time.Duration) {
+	for range time.Tick(every) {
+		if err := repo.SyncRoomCalendars(context.Background()); err != nil {
+			app.ErrorLog.Println("cannot sync room calendars:", err)
+		}
+	}
+}
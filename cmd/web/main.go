@@ -2,22 +2,26 @@ package main
 
 import (
 	"encoding/gob"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/redblue-blur/bookings/internal/config"
 	"github.com/redblue-blur/bookings/internal/driver"
 	"github.com/redblue-blur/bookings/internal/handlers"
 	"github.com/redblue-blur/bookings/internal/helpers"
+	"github.com/redblue-blur/bookings/internal/mailer"
 	"github.com/redblue-blur/bookings/internal/models"
 	"github.com/redblue-blur/bookings/internal/render"
 )
 
 const portno = ":8080"
+const defaultDSN = "host=localhost port=5432 dbname=bookings user=postgres password=qwerty"
 
 var app config.AppConfig
 var session *scs.SessionManager
@@ -25,11 +29,24 @@ var infoLog *log.Logger
 var errorLog *log.Logger
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	db, err := run()
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.SQL.Close()
+	if db != nil {
+		defer db.SQL.Close()
+	}
+	go releaseExpiredHolds(handlers.Repo.DB, time.Minute)
+	go offerExpiredClaims(handlers.Repo, time.Minute)
+	go syncRoomCalendars(handlers.Repo, app.CalendarSyncInterval)
+
 	fmt.Printf("starting application on port %s", portno)
 
 	srv := &http.Server{
@@ -43,11 +60,37 @@ func main() {
 func run() (*driver.DB, error) {
 	//what am i going to put in the session
 	gob.Register(models.Reservation{})
+	gob.Register(models.Booking{})
 	gob.Register(models.User{})
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
+	gob.Register(models.RoomRestriction{})
+	demo := flag.Bool("demo", false, "Run with an in-memory database instead of postgres")
+	dsn := flag.String("dsn", defaultDSN, "Database connection string")
+	dbTimeout := flag.Duration("dbtimeout", 3*time.Second, "Timeout for each database query")
+	holdDuration := flag.Duration("hold", 15*time.Minute, "How long a room is held while a guest fills in the reservation form")
+	depositPercent := flag.Int("deposit", 20, "Percent of the total authorized on the guest's card as a deposit")
+	claimDuration := flag.Duration("claim", 24*time.Hour, "How long a waitlisted guest has to claim a room that freed up")
+	resetDuration := flag.Duration("reset", time.Hour, "How long the link sent to reset a password works")
+	baseURL := flag.String("baseurl", "http://localhost"+portno, "Address of the site, used for links in emails")
+	smtpAddr := flag.String("smtp", "", "Mail server as host:port, emails are logged when it is not set")
+	mailFrom := flag.String("mailfrom", "bookings@localhost", "Sender address for emails")
+	defaultProperty := flag.String("property", "fort-smythe", "Slug of the property served on hosts no property claims")
+	calendarSync := flag.Duration("calendarsync", 15*time.Minute, "How often the calendars imported into rooms are synced")
+	calendarDir := flag.String("calendardir", "", "Directory rooms can import calendar files from outside production")
+	flag.Parse()
+
 	//true if in Production
 	app.InProduction = false
+	app.DBTimeout = *dbTimeout
+	app.HoldDuration = *holdDuration
+	app.DepositPercent = *depositPercent
+	app.WaitlistClaimDuration = *claimDuration
+	app.PasswordResetDuration = *resetDuration
+	app.BaseURL = *baseURL
+	app.DefaultProperty = *defaultProperty
+	app.CalendarSyncInterval = *calendarSync
+	app.CalendarImportDir = *calendarDir
 
 	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.InfoLog = infoLog
@@ -63,12 +106,18 @@ func run() (*driver.DB, error) {
 
 	app.Session = session
 	// connect to database
-	log.Println("Connecting to database...")
-	db, err := driver.ConnectSQL("host=localhost port=5432 dbname=bookings user=postgres password=qwerty")
-	if err != nil {
-		log.Fatal("cannot connect to database! Dying...")
+	var db *driver.DB
+	if *demo {
+		log.Println("Running with an in-memory database")
+	} else {
+		log.Println("Connecting to database...")
+		var err error
+		db, err = driver.ConnectSQL(*dsn)
+		if err != nil {
+			log.Fatal("cannot connect to database! Dying...")
+		}
+		log.Println("connected to database!")
 	}
-	log.Println("connected to database!")
 
 	tc, err := render.CreateTemplateCache()
 	if err != nil {
@@ -80,6 +129,9 @@ func run() (*driver.DB, error) {
 	app.UseCache = false
 
 	repo := handlers.NewRepo(&app, db)
+	if *smtpAddr != "" {
+		repo.Mailer = mailer.SMTP{Addr: *smtpAddr, From: *mailFrom}
+	}
 	handlers.NewHandlers(repo)
 	render.NewRenderer(&app)
 	helpers.NewHelpers(&app)
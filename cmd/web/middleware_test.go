@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/redblue-blur/bookings/internal/helpers"
 )
 
 func TestNoSurf(t *testing.T) {
@@ -28,3 +33,49 @@ func TestSessionLoad(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not http.handler,but is %t", v))
 	}
 }
+
+func TestAuth(t *testing.T) {
+	session = scs.New()
+	app.Session = session
+	helpers.NewHelpers(&app)
+
+	reached := false
+	h := Auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	//a visitor who is not logged in is sent to the login page
+	req := httptest.NewRequest("GET", "/admin/properties", nil)
+	req = req.WithContext(sessionCtx(t, req))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected %d for a visitor who is not logged in but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if location := rr.Header().Get("Location"); location != "/user/login" {
+		t.Errorf("expected a redirect to /user/login but got %q", location)
+	}
+	if reached {
+		t.Error("the next handler was reached without logging in")
+	}
+
+	//a logged in user reaches the page
+	req = httptest.NewRequest("GET", "/admin/properties", nil)
+	ctx := sessionCtx(t, req)
+	session.Put(ctx, "user_id", 1)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if !reached {
+		t.Errorf("the next handler was not reached for a logged in user, got %d", rr.Code)
+	}
+}
+
+//sessionCtx loads a new session into the request's context like SessionLoad does
+func sessionCtx(t *testing.T, r *http.Request) context.Context {
+	ctx, err := session.Load(r.Context(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctx
+}
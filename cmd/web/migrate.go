@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/redblue-blur/bookings/internal/driver"
+	"github.com/redblue-blur/bookings/internal/migrate"
+	"github.com/redblue-blur/bookings/migrations"
+)
+
+const migrateUsage = "usage: web migrate [-dsn dsn] up | down [n] | status"
+
+//runMigrate handles the migrate subcommand: up, down N and status
+func runMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dsn := flags.String("dsn", defaultDSN, "Database connection string")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	db, err := driver.ConnectSQL(*dsn)
+	if err != nil {
+		return err
+	}
+	defer db.SQL.Close()
+
+	m, err := migrate.New(db.SQL, migrations.FS)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	switch flags.Arg(0) {
+	case "up":
+		done, err := m.Up(ctx)
+		for _, migration := range done {
+			fmt.Printf("applied  %d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			fmt.Println("nothing to apply")
+		}
+
+	case "down":
+		n := 1
+		if flags.NArg() > 1 {
+			n, err = strconv.Atoi(flags.Arg(1))
+			if err != nil || n < 1 {
+				return errors.New(migrateUsage)
+			}
+		}
+		done, err := m.Down(ctx, n)
+		for _, migration := range done {
+			fmt.Printf("reverted %d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			if s.Applied {
+				fmt.Printf("applied %s  %d_%s\n", s.AppliedAt.Format("2006-01-02 15:04:05"), s.Version, s.Name)
+			} else {
+				fmt.Printf("pending                      %d_%s\n", s.Version, s.Name)
+			}
+		}
+
+	default:
+		return errors.New(migrateUsage)
+	}
+	return nil
+}
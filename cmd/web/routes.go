@@ -7,6 +7,7 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/redblue-blur/bookings/internal/config"
 	"github.com/redblue-blur/bookings/internal/handlers"
+	"github.com/redblue-blur/bookings/internal/models"
 )
 
 func routes(app *config.AppConfig) http.Handler {
@@ -21,22 +22,94 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Use(NoSurf)
 	mux.Use(SessionLoad)
 
+	mux.Handle("/generals-quaters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently))
+	mux.Handle("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently))
+
+	mux.Get("/user/login", handlers.Repo.ShowLogin)
+	mux.Post("/user/login", handlers.Repo.PostShowLogin)
+	mux.Post("/user/logout", handlers.Repo.Logout)
+	mux.Get("/user/forgot-password", handlers.Repo.ForgotPassword)
+	mux.Post("/user/forgot-password", handlers.Repo.PostForgotPassword)
+	mux.Get("/user/reset-password/{token}", handlers.Repo.ResetPassword)
+	mux.Post("/user/reset-password/{token}", handlers.Repo.PostResetPassword)
+
+	mux.Route("/admin", func(mux chi.Router) {
+		mux.Use(Auth)
+		mux.Use(handlers.Repo.LoadUser)
+		mux.With(handlers.Repo.Require(models.PermManageProperty)).Get("/properties", handlers.Repo.AdminProperties)
+		mux.With(handlers.Repo.Require(models.PermAddProperties)).Post("/properties", handlers.Repo.PostAdminProperties)
+		mux.Route("/properties/{property}", func(mux chi.Router) {
+			mux.Use(handlers.Repo.PropertyFromPath)
+			mux.With(handlers.Repo.Require(models.PermViewRoomStatus)).Get("/calendar", handlers.Repo.AdminReservationCalendar)
+			mux.With(handlers.Repo.Require(models.PermManageReservations)).Post("/calendar", handlers.Repo.PostAdminReservationCalendar)
+			mux.Group(func(mux chi.Router) {
+				mux.Use(handlers.Repo.Require(models.PermManageProperty))
+				mux.Get("/", handlers.Repo.AdminProperty)
+				mux.Post("/", handlers.Repo.PostAdminProperty)
+				mux.Get("/taxes", handlers.Repo.AdminTaxes)
+				mux.Post("/taxes", handlers.Repo.PostAdminTaxes)
+				mux.Get("/stay-rules", handlers.Repo.AdminStayRules)
+				mux.Post("/stay-rules", handlers.Repo.PostAdminStayRules)
+				mux.Post("/stay-rules/{id}/delete", handlers.Repo.PostAdminDeleteStayRule)
+				mux.Post("/rooms/{id}/calendar", handlers.Repo.PostAdminRoomCalendar)
+				mux.Post("/calendars", handlers.Repo.PostAdminRoomCalendars)
+				mux.Post("/calendars/{id}/sync", handlers.Repo.PostAdminSyncRoomCalendar)
+				mux.Post("/calendars/{id}/delete", handlers.Repo.PostAdminDeleteRoomCalendar)
+			})
+		})
+		//the cancellation page shows what the guest paid and gets back
+		mux.With(handlers.Repo.Require(models.PermViewPayments)).Get("/reservations/{id}/cancel", handlers.Repo.AdminCancelReservation)
+		mux.With(handlers.Repo.Require(models.PermManageReservations)).Post("/reservations/{id}/cancel", handlers.Repo.PostAdminCancelReservation)
+		mux.Group(func(mux chi.Router) {
+			mux.Use(handlers.Repo.Require(models.PermViewGuests))
+			mux.Get("/guests", handlers.Repo.AdminGuests)
+			mux.With(handlers.Repo.Require(models.PermMergeGuests)).Post("/guests/merge", handlers.Repo.PostAdminMergeGuests)
+			mux.Get("/guests/{id}", handlers.Repo.AdminGuest)
+			mux.Post("/guests/{id}", handlers.Repo.PostAdminGuest)
+		})
+	})
+
+	fileServer := http.FileServer(http.Dir("./static/"))
+	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
+
+	//every property's site is served under /p/<slug>, and at the root for the property claiming the host
+	mux.Route("/p/{property}", func(mux chi.Router) {
+		mux.Use(handlers.Repo.PropertyFromPath)
+		siteRoutes(mux)
+	})
+	mux.Group(func(mux chi.Router) {
+		mux.Use(handlers.Repo.PropertyFromHost)
+		siteRoutes(mux)
+	})
+
+	return mux
+}
+
+//siteRoutes are the public pages of a property's site
+func siteRoutes(mux chi.Router) {
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
-	mux.Get("/generals-quaters", handlers.Repo.Generals)
-	mux.Get("/majors-suite", handlers.Repo.Majors)
+	mux.Get("/rooms", handlers.Repo.Rooms)
+	mux.Get("/rooms/{slug}", handlers.Repo.Room)
+	mux.Get("/rooms/{id}/calendar.ics", handlers.Repo.RoomCalendar)
 
 	mux.Get("/search-availability", handlers.Repo.Availability)
 	mux.Post("/search-availability", handlers.Repo.PostAvailability)
 	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
+	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
+	mux.Post("/choose-rooms", handlers.Repo.PostChooseRooms)
+	mux.Get("/book-room", handlers.Repo.BookRoom)
+	mux.Get("/waitlist", handlers.Repo.Waitlist)
+	mux.Post("/waitlist", handlers.Repo.PostWaitlist)
+	mux.Get("/waitlist/claim/{token}", handlers.Repo.ClaimWaitlist)
 
 	mux.Get("/contact", handlers.Repo.Contact)
 	mux.Get("/reservation", handlers.Repo.Reservation)
 	mux.Post("/reservation", handlers.Repo.PostReservation)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
-
-	fileServer := http.FileServer(http.Dir("./static/"))
-	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
-
-	return mux
+	mux.Get("/group-reservation", handlers.Repo.GroupReservation)
+	mux.Post("/group-reservation", handlers.Repo.PostGroupReservation)
+	mux.Get("/booking-summary", handlers.Repo.BookingSummary)
+	mux.Get("/reservations/{code}/cancel", handlers.Repo.CancelReservation)
+	mux.Post("/reservations/{code}/cancel", handlers.Repo.PostCancelReservation)
 }
@@ -3,6 +3,7 @@ package config
 import (
 	"html/template"
 	"log"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
 )
@@ -15,4 +16,21 @@ type AppConfig struct {
 	ErrorLog      *log.Logger
 	InProduction  bool
 	Session       *scs.SessionManager
+	DBTimeout     time.Duration
+	HoldDuration  time.Duration
+	//DepositPercent is the part of the total authorized on the guest's card when they book
+	DepositPercent int
+	//WaitlistClaimDuration is how long a waitlisted guest has to use the link sent when a room frees up
+	WaitlistClaimDuration time.Duration
+	//PasswordResetDuration is how long the link sent to reset a password works
+	PasswordResetDuration time.Duration
+	//BaseURL is where the site is served, for links in emails
+	BaseURL string
+	//DefaultProperty is the slug of the property served on hosts that no property claims
+	DefaultProperty string
+	//CalendarSyncInterval is how often the calendars imported into rooms are synced
+	CalendarSyncInterval time.Duration
+	//CalendarImportDir is the directory calendars can be imported from as local files outside production,
+	//empty for none
+	CalendarImportDir string
 }
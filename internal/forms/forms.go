@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"unicode"
 
 	"github.com/asaskevich/govalidator"
 )
@@ -65,3 +66,55 @@ func (f *Form) IsEmail(field string) bool {
 	}
 	return true
 }
+
+//Validate runs a custom check on a field and adds the error it returns to the field
+func (f *Form) Validate(field string, check func(value string) error) bool {
+	if err := check(f.Get(field)); err != nil {
+		f.Errors.Add(field, err.Error())
+		return false
+	}
+	return true
+}
+
+//minPasswordLength is the shortest password IsStrongPassword accepts
+const minPasswordLength = 10
+
+//maxPasswordBytes is the longest password IsStrongPassword accepts, bcrypt ignores what comes after it
+const maxPasswordBytes = 72
+
+//IsStrongPassword checks a password is long enough, not longer than bcrypt can hash and mixes letters with
+//digits or symbols
+func (f *Form) IsStrongPassword(field string) bool {
+	x := f.Get(field)
+	if len([]rune(x)) < minPasswordLength {
+		f.Errors.Add(field, fmt.Sprintf("The password must be at least %d characters long", minPasswordLength))
+		return false
+	}
+	if len(x) > maxPasswordBytes {
+		f.Errors.Add(field, fmt.Sprintf("The password must be at most %d characters long, fewer with accented letters", maxPasswordBytes))
+		return false
+	}
+
+	var letter, other bool
+	for _, c := range x {
+		if unicode.IsLetter(c) {
+			letter = true
+		} else if !unicode.IsSpace(c) {
+			other = true
+		}
+	}
+	if !letter || !other {
+		f.Errors.Add(field, "The password must have letters and at least one digit or symbol")
+		return false
+	}
+	return true
+}
+
+//Matches checks a field has the same value as another, like a password typed twice
+func (f *Form) Matches(field, other string) bool {
+	if f.Get(field) != f.Get(other) {
+		f.Errors.Add(field, "This field does not match")
+		return false
+	}
+	return true
+}
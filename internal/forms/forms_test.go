@@ -1,9 +1,11 @@
 package forms
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 )
 
@@ -90,3 +92,60 @@ func TestForm_MinLength(t *testing.T) {
 		}
 	}
 }
+
+func TestForm_Validate(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("code", "bad")
+	form := New(postedData)
+
+	if !form.Validate("code", func(value string) error { return nil }) {
+		t.Error("Validate failed a check that passed")
+	}
+	if !form.Valid() {
+		t.Error("form shows invalid after a passing check")
+	}
+
+	ok := form.Validate("code", func(value string) error {
+		if value == "bad" {
+			return fmt.Errorf("bad code")
+		}
+		return nil
+	})
+	if ok || form.Errors.Get("code") != "bad code" {
+		t.Error("Validate did not add the check's error to the field")
+	}
+}
+
+func TestForm_IsStrongPassword(t *testing.T) {
+	var tests = []struct {
+		password string
+		strong   bool
+	}{
+		{"short1!", false},
+		{"longenoughbutletters", false},
+		{"1234567890123", false},
+		{"correct horse 7", true},
+		{"päßwörter-lang", true},
+		{strings.Repeat("a", 71) + "1", true},
+		{strings.Repeat("a", 72) + "1", false},
+		{strings.Repeat("ä", 36) + "1", false},
+	}
+	for _, e := range tests {
+		form := New(url.Values{"password": {e.password}})
+		if form.IsStrongPassword("password") != e.strong || form.Valid() != e.strong {
+			t.Errorf("%q: expected strong to be %v", e.password, e.strong)
+		}
+	}
+}
+
+func TestForm_Matches(t *testing.T) {
+	form := New(url.Values{"password": {"one"}, "confirm_password": {"one"}})
+	if !form.Matches("confirm_password", "password") || !form.Valid() {
+		t.Error("Matches failed fields with the same value")
+	}
+
+	form = New(url.Values{"password": {"one"}, "confirm_password": {"two"}})
+	if form.Matches("confirm_password", "password") || form.Errors.Get("confirm_password") == "" {
+		t.Error("Matches did not add an error for different values")
+	}
+}
@@ -0,0 +1,427 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redblue-blur/bookings/internal/forms"
+	"github.com/redblue-blur/bookings/internal/helpers"
+	"github.com/redblue-blur/bookings/internal/models"
+	"github.com/redblue-blur/bookings/internal/pricing"
+	"github.com/redblue-blur/bookings/internal/render"
+	"github.com/redblue-blur/bookings/internal/repository"
+)
+
+//monthLayout is how the reservation calendar's month is written in its urls
+const monthLayout = "2006-01"
+
+//taxKinds are the kinds of tax and fee rules admins can choose from
+var taxKinds = map[string]string{
+	models.TaxOccupancy: "Occupancy tax",
+	models.TaxCleaning:  "Cleaning fee",
+	models.TaxCity:      "City tax",
+}
+
+//AdminTaxes lists the property's tax and fee rules and the ones in effect today
+func (m *Repository) AdminTaxes(w http.ResponseWriter, r *http.Request) {
+	m.renderAdminTaxes(w, r, forms.New(nil))
+}
+
+//PostAdminTaxes adds a tax or fee rule to the property that takes effect from the chosen date
+func (m *Repository) PostAdminTaxes(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("kind", "name", "amount", "effective_from")
+
+	property := helpers.SiteFrom(r.Context()).Property
+	rule := models.TaxRule{
+		PropertyID: property.ID,
+		Kind:       form.Get("kind"),
+		Name:       form.Get("name"),
+	}
+	if _, ok := taxKinds[rule.Kind]; !ok {
+		form.Errors.Add("kind", "Invalid kind")
+	}
+	if form.Get("amount") != "" {
+		//the occupancy tax is a whole percent, fees are entered in dollars
+		if rule.Kind == models.TaxOccupancy {
+			rule.Amount, err = strconv.Atoi(form.Get("amount"))
+		} else {
+			var dollars float64
+			dollars, err = strconv.ParseFloat(form.Get("amount"), 64)
+			rule.Amount = int(math.Round(dollars * 100))
+		}
+		if err != nil || rule.Amount < 0 {
+			form.Errors.Add("amount", "Invalid amount")
+		}
+	}
+	if form.Get("effective_from") != "" {
+		rule.EffectiveFrom, err = time.Parse(dateLayout, form.Get("effective_from"))
+		if err != nil {
+			form.Errors.Add("effective_from", "Invalid date")
+		}
+	}
+
+	if !form.Valid() {
+		m.renderAdminTaxes(w, r, form)
+		return
+	}
+
+	_, err = m.DB.InsertTaxRule(r.Context(), rule)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Tax rule saved")
+	http.Redirect(w, r, "/admin/properties/"+property.Slug+"/taxes", http.StatusSeeOther)
+}
+
+//renderAdminTaxes shows the tax rules page with the form for a new rule
+func (m *Repository) renderAdminTaxes(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	rules, err := m.DB.AllTaxRules(r.Context(), helpers.SiteFrom(r.Context()).Property.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	inEffect := make(map[int]bool)
+	for _, rule := range pricing.TaxesInEffect(rules, today(r)) {
+		inEffect[rule.ID] = true
+	}
+
+	data := make(map[string]interface{})
+	data["rules"] = rules
+	data["in_effect"] = inEffect
+	data["kinds"] = taxKinds
+
+	render.Template(w, r, "admin-taxes.page.html", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+//weekday is a day of the week admins can tick on a stay rule
+type weekday struct {
+	Day     time.Weekday
+	Checked bool
+}
+
+//AdminStayRules lists the stay rules of the property's rooms
+func (m *Repository) AdminStayRules(w http.ResponseWriter, r *http.Request) {
+	m.renderAdminStayRules(w, r, forms.New(nil))
+}
+
+//PostAdminStayRules adds a stay rule to one of the property's rooms
+func (m *Repository) PostAdminStayRules(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_id", "start_date", "end_date")
+
+	rule := models.StayRule{
+		Name:              form.Get("name"),
+		ClosedToArrival:   form.Get("closed_to_arrival") != "",
+		ClosedToDeparture: form.Get("closed_to_departure") != "",
+	}
+	if form.Get("room_id") != "" {
+		rule.RoomID, err = strconv.Atoi(form.Get("room_id"))
+		if err != nil {
+			form.Errors.Add("room_id", "Invalid room")
+		} else if _, err := m.propertyRoom(r, rule.RoomID); errors.Is(err, repository.ErrNotFound) {
+			form.Errors.Add("room_id", "Invalid room")
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+	if form.Get("start_date") != "" {
+		rule.StartDate, err = time.Parse(dateLayout, form.Get("start_date"))
+		if err != nil {
+			form.Errors.Add("start_date", "Invalid date")
+		}
+	}
+	if form.Get("end_date") != "" {
+		rule.EndDate, err = time.Parse(dateLayout, form.Get("end_date"))
+		if err != nil {
+			form.Errors.Add("end_date", "Invalid date")
+		} else if !rule.EndDate.After(rule.StartDate) {
+			form.Errors.Add("end_date", "The last day must be after the first")
+		}
+	}
+	for _, value := range r.PostForm["weekdays"] {
+		day, err := strconv.Atoi(value)
+		if err != nil || day < int(time.Sunday) || day > int(time.Saturday) {
+			form.Errors.Add("weekdays", "Invalid day of the week")
+			break
+		}
+		rule.Weekdays |= 1 << uint(day)
+	}
+	for field, nights := range map[string]*int{"min_nights": &rule.MinNights, "max_nights": &rule.MaxNights} {
+		if form.Get(field) == "" {
+			continue
+		}
+		*nights, err = strconv.Atoi(form.Get(field))
+		if err != nil || *nights < 0 {
+			form.Errors.Add(field, "Invalid number of nights")
+		}
+	}
+	if rule.MaxNights > 0 && rule.MaxNights < rule.MinNights {
+		form.Errors.Add("max_nights", "The maximum can't be below the minimum")
+	}
+	if rule.MinNights == 0 && rule.MaxNights == 0 && !rule.ClosedToArrival && !rule.ClosedToDeparture {
+		form.Errors.Add("min_nights", "Set a length of stay or close the days to arrivals or departures")
+	}
+
+	if !form.Valid() {
+		m.renderAdminStayRules(w, r, form)
+		return
+	}
+
+	_, err = m.DB.InsertStayRule(r.Context(), rule)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Stay rule saved")
+	http.Redirect(w, r, "/admin/properties/"+helpers.SiteFrom(r.Context()).Property.Slug+"/stay-rules", http.StatusSeeOther)
+}
+
+//PostAdminDeleteStayRule removes one of the property's stay rules
+func (m *Repository) PostAdminDeleteStayRule(w http.ResponseWriter, r *http.Request) {
+	property := helpers.SiteFrom(r.Context()).Property
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	//rules of other properties are not found like rules that do not exist
+	rules, err := m.DB.StayRulesForProperty(r.Context(), property.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	found := false
+	for _, rule := range rules {
+		found = found || rule.ID == id
+	}
+	if !found {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.DeleteStayRule(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Stay rule removed")
+	http.Redirect(w, r, "/admin/properties/"+property.Slug+"/stay-rules", http.StatusSeeOther)
+}
+
+//renderAdminStayRules shows the stay rules page with the form for a new rule
+func (m *Repository) renderAdminStayRules(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	property := helpers.SiteFrom(r.Context()).Property
+	rules, err := m.DB.StayRulesForProperty(r.Context(), property.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rooms, err := m.DB.AllRooms(r.Context(), property.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	checked := make(map[string]bool)
+	for _, value := range form.Values["weekdays"] {
+		checked[value] = true
+	}
+	var weekdays []weekday
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		weekdays = append(weekdays, weekday{Day: day, Checked: checked[strconv.Itoa(int(day))]})
+	}
+
+	data := make(map[string]interface{})
+	data["rules"] = rules
+	data["rooms"] = rooms
+	data["weekdays"] = weekdays
+
+	render.Template(w, r, "admin-stay-rules.page.html", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+//calendarDay is a night of a room on the reservation calendar
+type calendarDay struct {
+	Date          time.Time
+	RestrictionID int //what takes the night, 0 when it is free
+	ReservationID int
+	Past          bool //nights before today cannot be changed
+}
+
+//calendarRoom is a room's row of nights on the reservation calendar
+type calendarRoom struct {
+	Room models.Room
+	Days []calendarDay
+}
+
+//AdminReservationCalendar shows a month of the property's rooms night by night, with the nights owners
+//block as boxes to tick
+func (m *Repository) AdminReservationCalendar(w http.ResponseWriter, r *http.Request) {
+	month := calendarMonth(r.URL.Query().Get("month"), today(r))
+	rooms, err := m.calendarRooms(r, month)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var days []time.Time
+	for day := month; day.Month() == month.Month(); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	data["days"] = days
+
+	stringMap := make(map[string]string)
+	stringMap["title"] = month.Format("January 2006")
+	stringMap["month"] = month.Format(monthLayout)
+	stringMap["previous"] = month.AddDate(0, -1, 0).Format(monthLayout)
+	stringMap["next"] = month.AddDate(0, 1, 0).Format(monthLayout)
+
+	render.Template(w, r, "admin-calendar.page.html", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+//PostAdminReservationCalendar blocks the ticked nights of the month for the owner and frees the unticked
+//ones, all changes are applied together or not at all
+func (m *Repository) PostAdminReservationCalendar(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	property := helpers.SiteFrom(r.Context()).Property
+	month := calendarMonth(r.Form.Get("month"), today(r))
+	redirect := fmt.Sprintf("/admin/properties/%s/calendar?month=%s", property.Slug, month.Format(monthLayout))
+
+	rooms, err := m.calendarRooms(r, month)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	ticked := make(map[string]bool)
+	for _, key := range r.Form["block"] {
+		ticked[key] = true
+	}
+
+	var changes []models.OwnerBlockChange
+	blocked := 0
+	names := make(map[int]string)
+	for _, row := range rooms {
+		names[row.Room.ID] = row.Room.RoomName
+		for _, day := range row.Days {
+			isBlocked := day.RestrictionID == models.RestrictionOwnerBlock
+			block := ticked[blockKey(row.Room.ID, day.Date)]
+			//past nights and nights taken by anything but a block have no box to tick
+			if day.Past || block == isBlocked || (block && day.RestrictionID != 0) {
+				continue
+			}
+			changes = append(changes, models.OwnerBlockChange{RoomID: row.Room.ID, Night: day.Date, Block: block})
+			if block {
+				blocked++
+			}
+		}
+	}
+
+	if len(changes) == 0 {
+		m.App.Session.Put(r.Context(), "flash", "Nothing to change")
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.UpdateOwnerBlocks(r.Context(), changes)
+	var conflict *repository.ConflictError
+	if errors.As(err, &conflict) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Nothing was changed, %s was booked from %s to %s in the meantime",
+			names[conflict.RoomID], conflict.StartDate.Format(dateLayout), conflict.EndDate.Format(dateLayout)))
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Calendar saved: %d nights blocked, %d freed", blocked, len(changes)-blocked))
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+//calendarMonth is the first day of the month written as 2006-01, or of the month today is in
+func calendarMonth(value string, today time.Time) time.Time {
+	month, err := time.Parse(monthLayout, value)
+	if err != nil {
+		month = time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return month
+}
+
+//calendarRooms lays out what takes each night of the month in each of the property's rooms
+func (m *Repository) calendarRooms(r *http.Request, month time.Time) ([]calendarRoom, error) {
+	rooms, err := m.DB.AllRooms(r.Context(), helpers.SiteFrom(r.Context()).Property.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := today(r)
+	var rows []calendarRoom
+	for _, room := range rooms {
+		restrictions, err := m.DB.RoomRestrictionsForRoom(r.Context(), room.ID, month)
+		if err != nil {
+			return nil, err
+		}
+
+		row := calendarRoom{Room: room}
+		for day := month; day.Month() == month.Month(); day = day.AddDate(0, 0, 1) {
+			d := calendarDay{Date: day, Past: day.Before(now)}
+			for _, rr := range restrictions {
+				if !day.Before(rr.StartDate) && day.Before(rr.EndDate) {
+					d.RestrictionID = rr.RestrictionID
+					d.ReservationID = rr.ReservationID
+				}
+			}
+			row.Days = append(row.Days, d)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+//blockKey names a room's night in the reservation calendar form
+func blockKey(roomID int, night time.Time) string {
+	return fmt.Sprintf("%d:%s", roomID, night.Format("2006-01-02"))
+}
@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/redblue-blur/bookings/internal/forms"
+	"github.com/redblue-blur/bookings/internal/helpers"
+	"github.com/redblue-blur/bookings/internal/mailer"
+	"github.com/redblue-blur/bookings/internal/models"
+	"github.com/redblue-blur/bookings/internal/payments"
+	"github.com/redblue-blur/bookings/internal/pricing"
+	"github.com/redblue-blur/bookings/internal/render"
+	"github.com/redblue-blur/bookings/internal/repository"
+)
+
+//PostChooseRooms takes the rooms ticked in the availability results and moves on to the group booking form,
+//a single room goes to the usual reservation form
+func (m *Repository) PostChooseRooms(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
+		http.Redirect(w, r, helpers.SitePath(r, "/search-availability"), http.StatusSeeOther)
+		return
+	}
+
+	var booking models.Booking
+	chosen := make(map[int]bool)
+	for _, value := range r.Form["room_id"] {
+		roomID, err := strconv.Atoi(value)
+		if err != nil {
+			helpers.ClientError(w, http.StatusBadRequest)
+			return
+		}
+		if chosen[roomID] {
+			continue
+		}
+		chosen[roomID] = true
+		booking.Reservations = append(booking.Reservations, models.Reservation{
+			RoomID:    roomID,
+			StartDate: reservation.StartDate,
+			EndDate:   reservation.EndDate,
+			Guests:    1,
+		})
+	}
+
+	switch len(booking.Reservations) {
+	case 0:
+		m.App.Session.Put(r.Context(), "error", "Choose at least one room")
+		http.Redirect(w, r, helpers.SitePath(r, "/search-availability"), http.StatusSeeOther)
+	case 1:
+		reservation.RoomID = booking.Reservations[0].RoomID
+		m.App.Session.Put(r.Context(), "reservation", reservation)
+		http.Redirect(w, r, helpers.SitePath(r, "/reservation"), http.StatusSeeOther)
+	default:
+		m.App.Session.Put(r.Context(), "booking", booking)
+		http.Redirect(w, r, helpers.SitePath(r, "/group-reservation"), http.StatusSeeOther)
+	}
+}
+
+//GroupReservation shows the form for booking the chosen rooms under one lead guest
+func (m *Repository) GroupReservation(w http.ResponseWriter, r *http.Request) {
+	booking, ok := m.App.Session.Get(r.Context(), "booking").(models.Booking)
+	if !ok || len(booking.Reservations) == 0 {
+		m.App.Session.Put(r.Context(), "error", "Can't get the chosen rooms from session")
+		http.Redirect(w, r, helpers.SitePath(r, "/search-availability"), http.StatusSeeOther)
+		return
+	}
+
+	err := m.bookingRooms(r, &booking)
+	if errors.Is(err, repository.ErrNotFound) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderGroupReservationForm(w, r, booking, forms.New(nil))
+}
+
+//PostGroupReservation books every chosen room for the lead guest, taking a deposit for each of them, either
+//all the rooms are booked or none is
+func (m *Repository) PostGroupReservation(w http.ResponseWriter, r *http.Request) {
+	booking, ok := m.App.Session.Get(r.Context(), "booking").(models.Booking)
+	if !ok || len(booking.Reservations) == 0 {
+		m.App.Session.Put(r.Context(), "error", "Can't get the chosen rooms from session")
+		http.Redirect(w, r, helpers.SitePath(r, "/search-availability"), http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email", "card_number", "card_exp", "card_cvc")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+
+	booking.FirstName = form.Get("first_name")
+	booking.LastName = form.Get("last_name")
+	booking.Email = form.Get("email")
+	booking.Phone = form.Get("phone")
+
+	card := payments.Card{
+		Number: strings.TrimSpace(form.Get("card_number")),
+		CVC:    form.Get("card_cvc"),
+	}
+	if form.Get("card_exp") != "" {
+		card.ExpMonth, card.ExpYear, err = parseCardExpiry(form.Get("card_exp"))
+		if err != nil {
+			form.Errors.Add("card_exp", "Use MM/YY")
+		}
+	}
+
+	err = m.bookingRooms(r, &booking)
+	if errors.Is(err, repository.ErrNotFound) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	for i := range booking.Reservations {
+		res := &booking.Reservations[i]
+		res.FirstName = booking.FirstName
+		res.LastName = booking.LastName
+		res.Email = booking.Email
+		res.Phone = booking.Phone
+
+		field := guestsField(res.RoomID)
+		if form.Get(field) != "" {
+			res.Guests, err = strconv.Atoi(form.Get(field))
+			if err != nil || res.Guests < 1 {
+				form.Errors.Add(field, "Invalid number of guests")
+				continue
+			}
+		}
+		if sleeps := res.Room.MaxAdults + res.Room.MaxChildren; res.Guests > sleeps {
+			form.Errors.Add(field, fmt.Sprintf("This room sleeps at most %d guests", sleeps))
+		}
+
+		err = m.checkStayRules(r, res.RoomID, res.StartDate, res.EndDate)
+		var stayErr *pricing.StayError
+		if errors.As(err, &stayErr) {
+			form.Errors.Add("rooms", fmt.Sprintf("%s: %s", res.Room.RoomName, stayErr.Error()))
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		m.renderGroupReservationForm(w, r, booking, form)
+		return
+	}
+
+	//every room must still be free before the deposit is taken, booking checks them again all at once
+	for _, res := range booking.Reservations {
+		available, err := m.DB.SearchAvailabilityByDatesByRoomID(r.Context(), res.StartDate, res.EndDate, res.RoomID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if !available {
+			m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Sorry, %s is no longer available for those dates. Please search again.", res.Room.RoomName))
+			http.Redirect(w, r, helpers.SitePath(r, "/search-availability"), http.StatusSeeOther)
+			return
+		}
+	}
+
+	deposits, err := m.quoteBooking(r, &booking)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	booking.Code, err = newReservationCode()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	for i := range booking.Reservations {
+		booking.Reservations[i].Code, err = newReservationCode()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	//the booking is only confirmed once the deposit for every room is authorized, each room gets its own
+	//so cancelling one room settles only what was taken for it
+	for i := range booking.Reservations {
+		res := &booking.Reservations[i]
+		if deposits[i] == 0 {
+			continue
+		}
+		payment, err := m.Payments.Authorize(r.Context(), payments.AuthorizeRequest{
+			Amount:    deposits[i],
+			Card:      card,
+			Reference: fmt.Sprintf("booking %s, reservation %s", booking.Code, res.Code),
+		})
+		var decline *payments.DeclineError
+		if errors.As(err, &decline) {
+			m.voidPayments(r, bookingPayments(booking))
+			form.Errors.Add("card_number", decline.Message)
+			m.renderGroupReservationForm(w, r, booking, form)
+			return
+		}
+		if err != nil {
+			m.voidPayments(r, bookingPayments(booking))
+			helpers.ServerError(w, err)
+			return
+		}
+		res.Payments = append(res.Payments, models.Payment{
+			Provider:   m.Payments.Name(),
+			ProviderID: payment.ID,
+			Status:     string(payment.Status),
+			Amount:     payment.Amount,
+			CardLast4:  payment.CardLast4,
+		})
+	}
+
+	booking.ID, err = m.DB.InsertBooking(r.Context(), booking)
+	if err != nil {
+		m.voidPayments(r, bookingPayments(booking))
+		var conflict *repository.ConflictError
+		if errors.As(err, &conflict) {
+			m.App.Session.Put(r.Context(), "error", "Sorry, one of those rooms just got taken for those dates. Nothing was booked, please search again.")
+			http.Redirect(w, r, helpers.SitePath(r, "/search-availability"), http.StatusSeeOther)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+
+	//the rooms are booked, so a confirmation that cannot be sent is only logged
+	if err := m.sendBookingConfirmation(r, booking); err != nil {
+		m.App.ErrorLog.Println("cannot send the booking confirmation:", err)
+	}
+
+	m.App.Session.Put(r.Context(), "booking", booking)
+	http.Redirect(w, r, helpers.SitePath(r, "/booking-summary"), http.StatusSeeOther)
+}
+
+//BookingSummary shows the confirmation of a group booking
+func (m *Repository) BookingSummary(w http.ResponseWriter, r *http.Request) {
+	booking, ok := m.App.Session.Get(r.Context(), "booking").(models.Booking)
+	if !ok || booking.ID == 0 {
+		m.App.Session.Put(r.Context(), "error", "Can't get booking from session")
+		http.Redirect(w, r, helpers.SitePath(r, "/"), http.StatusTemporaryRedirect)
+		return
+	}
+	m.App.Session.Remove(r.Context(), "booking")
+
+	data := make(map[string]interface{})
+	data["booking"] = booking
+	render.Template(w, r, "booking-summary.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
+//bookingRooms loads the room of each reservation of the booking, rooms of other properties are not found
+func (m *Repository) bookingRooms(r *http.Request, booking *models.Booking) error {
+	for i := range booking.Reservations {
+		room, err := m.propertyRoom(r, booking.Reservations[i].RoomID)
+		if err != nil {
+			return err
+		}
+		booking.Reservations[i].Room = room
+	}
+	return nil
+}
+
+//quoteBooking prices each reservation of the booking and the booking as a whole, it returns the deposit
+//for each room
+func (m *Repository) quoteBooking(r *http.Request, booking *models.Booking) ([]int, error) {
+	deposits := make([]int, len(booking.Reservations))
+	booking.TotalPrice = 0
+	for i := range booking.Reservations {
+		res := &booking.Reservations[i]
+		quote, err := m.quote(r, res.Room, res.StartDate, res.EndDate)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.addTaxes(r, &quote, res.Guests); err != nil {
+			return nil, err
+		}
+		res.TotalPrice = quote.Total
+		res.LineItems = quote.LineItems()
+		res.CancellationPolicyID = quote.CancellationPolicyID
+		booking.TotalPrice += quote.Total
+		deposits[i] = quote.Deposit(m.App.DepositPercent)
+	}
+	return deposits, nil
+}
+
+//bookingPayments gathers the deposits authorized for the rooms of a booking
+func bookingPayments(booking models.Booking) []models.Payment {
+	var paid []models.Payment
+	for _, res := range booking.Reservations {
+		paid = append(paid, res.Payments...)
+	}
+	return paid
+}
+
+//renderGroupReservationForm shows the group booking form, priced for the guests entered so far
+func (m *Repository) renderGroupReservationForm(w http.ResponseWriter, r *http.Request, booking models.Booking, form *forms.Form) {
+	deposits, err := m.quoteBooking(r, &booking)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["booking"] = booking
+
+	intMap := make(map[string]int)
+	for _, deposit := range deposits {
+		intMap["deposit"] += deposit
+	}
+
+	render.Template(w, r, "group-reservation.page.html", &models.TemplateData{
+		Form:   form,
+		Data:   data,
+		IntMap: intMap,
+	})
+}
+
+//guestsField is the form field for the number of guests in a room of a group booking
+func guestsField(roomID int) string {
+	return fmt.Sprintf("guests_%d", roomID)
+}
+
+//sendBookingConfirmation emails the lead guest one confirmation for every room of the booking
+func (m *Repository) sendBookingConfirmation(r *http.Request, booking models.Booking) error {
+	property := helpers.SiteFrom(r.Context()).Property
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Dear %s %s,\n\nyour booking %s at %s is confirmed:\n\n", booking.FirstName, booking.LastName, booking.Code, property.Name)
+	for _, res := range booking.Reservations {
+		fmt.Fprintf(&body, "%s, %s to %s, %d guests, %s, reservation %s\n", res.Room.RoomName,
+			res.StartDate.Format(dateLayout), res.EndDate.Format(dateLayout), res.Guests, render.FormatMoney(res.TotalPrice), res.Code)
+	}
+	fmt.Fprintf(&body, "\nTotal: %s\n", render.FormatMoney(booking.TotalPrice))
+	if property.CheckInTime != "" {
+		fmt.Fprintf(&body, "Check-in is from %s and check-out is by %s.\n", property.CheckInTime, property.CheckOutTime)
+	}
+
+	return m.Mailer.Send(r.Context(), mailer.Message{
+		To:      booking.Email,
+		Subject: "Your booking " + booking.Code + " at " + property.Name,
+		Body:    body.String(),
+	})
+}
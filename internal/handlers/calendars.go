@@ -0,0 +1,396 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redblue-blur/bookings/internal/helpers"
+	"github.com/redblue-blur/bookings/internal/ical"
+	"github.com/redblue-blur/bookings/internal/models"
+	"github.com/redblue-blur/bookings/internal/repository"
+)
+
+//calendarClient fetches the calendars imported into rooms, it only connects to public addresses so a
+//calendar url cannot be used to reach the server itself or the network it is on
+var calendarClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 10 * time.Second, Control: publicAddressOnly}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+//publicAddressOnly refuses connections to loopback, private, link-local and unspecified addresses, it runs once
+//the host name is resolved so a name pointing at one of them is refused too
+func publicAddressOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return fmt.Errorf("calendars cannot be imported from %s, it is not a public address", host)
+	}
+	return nil
+}
+
+//maxCalendarSize is the most read of an imported calendar
+const maxCalendarSize = 5 << 20
+
+//calendarPastDays is how far back room calendar feeds go, so recent stays stay visible to housekeeping
+const calendarPastDays = 30
+
+//RoomCalendar serves the room's bookings and blocks as an iCal feed for channel managers and staff to
+//subscribe to, the url has to carry the room's calendar token
+func (m *Repository) RoomCalendar(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	room, err := m.propertyRoom(r, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if room.CalendarToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(room.CalendarToken)) != 1 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	restrictions, err := m.DB.RoomRestrictionsForRoom(r.Context(), room.ID, today(r).AddDate(0, 0, -calendarPastDays))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	property := helpers.SiteFrom(r.Context()).Property
+	cal := ical.Calendar{
+		ProdID: "-//Bookings//Room calendar//EN",
+		Name:   property.Name + ", " + room.RoomName,
+	}
+	for _, rr := range restrictions {
+		//holds only last while a guest fills in the reservation form
+		if rr.RestrictionID == models.RestrictionHold {
+			continue
+		}
+		cal.Events = append(cal.Events, ical.Event{
+			UID:     fmt.Sprintf("restriction-%d@%s", rr.ID, m.calendarHost()),
+			Start:   rr.StartDate,
+			End:     rr.EndDate,
+			Summary: calendarSummary(property, rr),
+			Stamp:   rr.UpdatedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.ics"`, room.Slug))
+	if _, err := cal.WriteTo(w); err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+}
+
+//calendarSummary is what a restriction is called in the feed, guest names are left out or cut down to
+//initials as the property chose
+func calendarSummary(property models.Property, rr models.RoomRestriction) string {
+	if rr.RestrictionID != models.RestrictionReservation {
+		return "Blocked"
+	}
+	if property.CalendarNames != models.CalendarNamesInitials {
+		return "Reserved"
+	}
+
+	var initials []string
+	for _, name := range []string{rr.Reservation.FirstName, rr.Reservation.LastName} {
+		if name = strings.TrimSpace(name); name != "" {
+			initials = append(initials, strings.ToUpper(string([]rune(name)[:1]))+".")
+		}
+	}
+	if len(initials) == 0 {
+		return "Reserved"
+	}
+	return "Reserved: " + strings.Join(initials, " ")
+}
+
+//calendarHost names this site in event uids, so they stay unique in calendars that merge several feeds
+func (m *Repository) calendarHost() string {
+	u, err := url.Parse(m.App.BaseURL)
+	if err != nil || u.Host == "" {
+		return "bookings"
+	}
+	return u.Host
+}
+
+//roomCalendarURL is the address calendars subscribe to for the room's feed, empty while the feed is off
+func (m *Repository) roomCalendarURL(property models.Property, room models.Room) string {
+	if room.CalendarToken == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s%s/rooms/%d/calendar.ics?token=%s",
+		m.App.BaseURL, propertyPrefix(property.Slug), room.ID, url.QueryEscape(room.CalendarToken))
+}
+
+//PostAdminRoomCalendar turns on a room's calendar feed, or moves it to a new url so old subscribers lose access
+func (m *Repository) PostAdminRoomCalendar(w http.ResponseWriter, r *http.Request) {
+	property := helpers.SiteFrom(r.Context()).Property
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	room, err := m.propertyRoom(r, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	token, _, err := helpers.NewToken()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	err = m.DB.SetRoomCalendarToken(r.Context(), room.ID, token)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "New calendar link for "+room.RoomName)
+	http.Redirect(w, r, "/admin/properties/"+property.Slug, http.StatusSeeOther)
+}
+
+//SyncRoomCalendars imports the calendars of other sites into the rooms, a calendar that cannot be read keeps
+//the dates it blocked last time and has the error recorded for admins to see
+func (m *Repository) SyncRoomCalendars(ctx context.Context) error {
+	calendars, err := m.DB.AllRoomCalendars(ctx)
+	if err != nil {
+		return err
+	}
+	for _, c := range calendars {
+		if _, err := m.syncRoomCalendar(ctx, c); err != nil {
+			m.App.ErrorLog.Printf("cannot sync calendar %d of room %d: %v", c.ID, c.RoomID, err)
+		}
+	}
+	return nil
+}
+
+//syncRoomCalendar reads a room calendar and makes the room's external restrictions match its events
+func (m *Repository) syncRoomCalendar(ctx context.Context, c models.RoomCalendar) (models.CalendarSync, error) {
+	events, err := m.fetchCalendar(ctx, c.URL)
+	if err != nil {
+		if recordErr := m.DB.SetRoomCalendarError(ctx, c.ID, err.Error()); recordErr != nil {
+			return models.CalendarSync{}, recordErr
+		}
+		return models.CalendarSync{}, err
+	}
+
+	var external []models.ExternalEvent
+	for _, e := range events {
+		uid := e.UID
+		if uid == "" {
+			//without a uid an event can only be told apart by its dates
+			uid = "dates-" + e.Start.Format(dateLayout) + "-" + e.End.Format(dateLayout)
+		}
+		external = append(external, models.ExternalEvent{
+			UID:       uid,
+			Summary:   e.Summary,
+			StartDate: e.Start,
+			EndDate:   e.End,
+		})
+	}
+
+	sync, err := m.DB.SyncRoomCalendar(ctx, c.ID, external)
+	if err != nil {
+		return sync, err
+	}
+	for _, conflict := range sync.Conflicts {
+		m.App.InfoLog.Printf("calendar %d of room %d: %s from %s to %s overlaps a booking", c.ID, c.RoomID,
+			conflict.UID, conflict.StartDate.Format(dateLayout), conflict.EndDate.Format(dateLayout))
+	}
+	return sync, nil
+}
+
+//fetchCalendar reads the events of a calendar from an http or https url, or from a file in the calendar
+//import directory outside production
+func (m *Repository) fetchCalendar(ctx context.Context, source string) ([]ical.Event, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.ReadCloser
+	switch u.Scheme {
+	case "http", "https":
+		req, err := http.NewRequestWithContext(ctx, "GET", source, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := calendarClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("got %s", resp.Status)
+		}
+		body = resp.Body
+	case "file", "":
+		path, err := m.calendarFile(strings.TrimPrefix(source, "file://"))
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		body = f
+	default:
+		return nil, fmt.Errorf("calendars cannot be imported from %s urls", u.Scheme)
+	}
+	defer body.Close()
+
+	return ical.Parse(io.LimitReader(body, maxCalendarSize))
+}
+
+//calendarFile checks that a calendar imported from a local file is in the calendar import directory, which
+//is only read outside production, and returns the file's path with its links followed
+func (m *Repository) calendarFile(name string) (string, error) {
+	if m.App.InProduction || m.App.CalendarImportDir == "" {
+		return "", errors.New("calendars can only be imported from http or https urls")
+	}
+	dir, err := filepath.EvalSymlinks(m.App.CalendarImportDir)
+	if err != nil {
+		return "", err
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	path, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return "", err
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(dir, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not in the calendar import directory", name)
+	}
+	return path, nil
+}
+
+//PostAdminRoomCalendars starts importing another site's calendar into one of the property's rooms
+func (m *Repository) PostAdminRoomCalendars(w http.ResponseWriter, r *http.Request) {
+	property := helpers.SiteFrom(r.Context()).Property
+	redirect := "/admin/properties/" + property.Slug
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	c := models.RoomCalendar{
+		Name: strings.TrimSpace(r.Form.Get("name")),
+		URL:  strings.TrimSpace(r.Form.Get("url")),
+	}
+	c.RoomID, _ = strconv.Atoi(r.Form.Get("room_id"))
+	if _, err := m.propertyRoom(r, c.RoomID); err != nil || c.URL == "" {
+		m.App.Session.Put(r.Context(), "error", "Choose a room and give the calendar's address")
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	c.ID, err = m.DB.InsertRoomCalendar(r.Context(), c)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.reportCalendarSync(r, c)
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+//PostAdminSyncRoomCalendar syncs one of the property's imported calendars straight away
+func (m *Repository) PostAdminSyncRoomCalendar(w http.ResponseWriter, r *http.Request) {
+	c, ok := m.propertyRoomCalendar(w, r)
+	if ok {
+		m.reportCalendarSync(r, c)
+		http.Redirect(w, r, "/admin/properties/"+helpers.SiteFrom(r.Context()).Property.Slug, http.StatusSeeOther)
+	}
+}
+
+//PostAdminDeleteRoomCalendar stops importing a calendar and frees the dates it blocked
+func (m *Repository) PostAdminDeleteRoomCalendar(w http.ResponseWriter, r *http.Request) {
+	c, ok := m.propertyRoomCalendar(w, r)
+	if !ok {
+		return
+	}
+	err := m.DB.DeleteRoomCalendar(r.Context(), c.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Calendar removed from "+c.Room.RoomName)
+	http.Redirect(w, r, "/admin/properties/"+helpers.SiteFrom(r.Context()).Property.Slug, http.StatusSeeOther)
+}
+
+//propertyRoomCalendar finds the imported calendar in the url among the property's, writing the error
+//response when it cannot
+func (m *Repository) propertyRoomCalendar(w http.ResponseWriter, r *http.Request) (models.RoomCalendar, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.RoomCalendar{}, false
+	}
+	c, err := m.DB.GetRoomCalendar(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && c.Room.PropertyID != helpers.SiteFrom(r.Context()).Property.ID) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return c, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return c, false
+	}
+	return c, true
+}
+
+//reportCalendarSync syncs a calendar and tells the admin how it went
+func (m *Repository) reportCalendarSync(r *http.Request, c models.RoomCalendar) {
+	sync, err := m.syncRoomCalendar(r.Context(), c)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot sync the calendar: "+err.Error())
+		return
+	}
+	msg := fmt.Sprintf("Calendar synced: %d added, %d moved, %d removed", sync.Added, sync.Updated, sync.Removed)
+	if len(sync.Conflicts) > 0 {
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("%s, %d overlap bookings here", msg, len(sync.Conflicts)))
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", msg)
+}
@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redblue-blur/bookings/internal/helpers"
+	"github.com/redblue-blur/bookings/internal/models"
+	"github.com/redblue-blur/bookings/internal/payments"
+	"github.com/redblue-blur/bookings/internal/pricing"
+	"github.com/redblue-blur/bookings/internal/render"
+	"github.com/redblue-blur/bookings/internal/repository"
+)
+
+//codeAlphabet leaves out characters that are easy to mistake for each other
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+//newReservationCode returns a random code guests use to look up their reservation
+func newReservationCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = codeAlphabet[int(b[i])%len(codeAlphabet)]
+	}
+	return string(b), nil
+}
+
+//cancellationPolicy gets a policy, id 0 is no policy at all
+func (m *Repository) cancellationPolicy(r *http.Request, id int) (models.CancellationPolicy, error) {
+	if id == 0 {
+		return models.CancellationPolicy{}, nil
+	}
+	return m.DB.GetCancellationPolicy(r.Context(), id)
+}
+
+//CancelReservation shows guests what cancelling their reservation costs
+func (m *Repository) CancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.reservationByCode(w, r)
+	if ok {
+		m.showCancellation(w, r, res)
+	}
+}
+
+//PostCancelReservation cancels a guest's reservation once they confirm
+func (m *Repository) PostCancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.reservationByCode(w, r)
+	if ok {
+		m.cancelReservation(w, r, res, helpers.SitePath(r, fmt.Sprintf("/reservations/%s/cancel", res.Code)))
+	}
+}
+
+//AdminCancelReservation shows admins what cancelling a reservation costs the guest
+func (m *Repository) AdminCancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.reservationByID(w, r)
+	if ok {
+		m.showCancellation(w, r, res)
+	}
+}
+
+//PostAdminCancelReservation cancels a reservation for a guest
+func (m *Repository) PostAdminCancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.reservationByID(w, r)
+	if ok {
+		m.cancelReservation(w, r, res, fmt.Sprintf("/admin/reservations/%d/cancel", res.ID))
+	}
+}
+
+//reservationByCode finds the reservation for the code in the url among the property's reservations, writing
+//the error response when it cannot
+func (m *Repository) reservationByCode(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	res, err := m.DB.GetReservationByCode(r.Context(), chi.URLParam(r, "code"))
+	res, ok := m.foundReservation(w, r, res, err)
+	if ok && res.Room.PropertyID != helpers.SiteFrom(r.Context()).Property.ID {
+		helpers.ClientError(w, http.StatusNotFound)
+		return res, false
+	}
+	return res, ok
+}
+
+//reservationByID finds the reservation for the id in the url, writing the error response when it cannot
+func (m *Repository) reservationByID(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.Reservation{}, false
+	}
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	return m.foundReservation(w, r, res, err)
+}
+
+//foundReservation loads the room, line items and payments of a reservation that was looked up
+func (m *Repository) foundReservation(w http.ResponseWriter, r *http.Request, res models.Reservation, err error) (models.Reservation, bool) {
+	if errors.Is(err, repository.ErrNotFound) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return res, false
+	}
+	if err == nil {
+		res.Room, err = m.DB.GetRoomByID(r.Context(), res.RoomID)
+	}
+	if err == nil {
+		res.LineItems, err = m.DB.LineItemsForReservation(r.Context(), res.ID)
+	}
+	if err == nil {
+		res.Payments, err = m.DB.PaymentsForReservation(r.Context(), res.ID)
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return res, false
+	}
+	return res, true
+}
+
+//showCancellation renders the cancellation page with the fee and refund as of today where the property is
+func (m *Repository) showCancellation(w http.ResponseWriter, r *http.Request, res models.Reservation) {
+	policy, err := m.cancellationPolicy(r, res.CancellationPolicyID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	property, err := m.DB.GetPropertyByID(r.Context(), res.Room.PropertyID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["unsettled"] = !res.CancelledAt.IsZero() && unsettled(res.Payments)
+
+	stringMap := make(map[string]string)
+	stringMap["policy"] = pricing.DescribePolicy(policy)
+
+	intMap := make(map[string]int)
+	if res.CancelledAt.IsZero() {
+		fee := pricing.CancellationFee(policy, res, property.Today(time.Now()))
+		intMap["fee"] = fee
+		intMap["refund"] = cancellationRefund(res.Payments, fee)
+	}
+
+	render.Template(w, r, "cancel-reservation.page.html", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Property:  property,
+	})
+}
+
+//cancelReservation frees the room and then charges the fee and refunds the rest of what was paid, posting it
+//again for a cancelled reservation settles the payments a failed attempt left behind
+func (m *Repository) cancelReservation(w http.ResponseWriter, r *http.Request, res models.Reservation, redirect string) {
+	if !res.CancelledAt.IsZero() && !unsettled(res.Payments) {
+		m.App.Session.Put(r.Context(), "warning", "This reservation was already cancelled")
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	fee := res.CancellationFee
+	if res.CancelledAt.IsZero() {
+		policy, err := m.cancellationPolicy(r, res.CancellationPolicyID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		property, err := m.DB.GetPropertyByID(r.Context(), res.Room.PropertyID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		fee = pricing.CancellationFee(policy, res, property.Today(time.Now()))
+
+		//the reservation is claimed before any money moves, so of two requests cancelling it at once only
+		//one goes on to settle its payments
+		err = m.DB.CancelReservation(r.Context(), res.ID, fee)
+		if errors.Is(err, repository.ErrAlreadyCancelled) {
+			m.App.Session.Put(r.Context(), "warning", "This reservation was already cancelled")
+			http.Redirect(w, r, redirect, http.StatusSeeOther)
+			return
+		}
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		//the cancellation has gone through, so a failure to reach the waitlist is only logged
+		if err := m.offerWaitlist(r.Context(), res.RoomID, res.StartDate, res.EndDate); err != nil {
+			m.App.ErrorLog.Println("cannot offer the freed room to the waitlist:", err)
+		}
+	}
+
+	refund, err := m.settleCancellation(r.Context(), res.Payments, fee)
+	settlementError := ""
+	if err != nil {
+		m.App.ErrorLog.Printf("cannot settle the payments of cancelled reservation %d: %v", res.ID, err)
+		settlementError = err.Error()
+	}
+	err = m.DB.SettleCancellation(r.Context(), res.ID, res.RefundAmount+refund, settlementError)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if settlementError != "" {
+		m.App.Session.Put(r.Context(), "warning", "The reservation has been cancelled but its payments could not be settled yet, please try again")
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "The reservation has been cancelled")
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+//unsettled reports whether a payment is still waiting for a cancellation to take its fee and give back the rest
+func unsettled(paid []models.Payment) bool {
+	for _, payment := range paid {
+		if payment.SettledAt.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+//cancellationRefund is how much of what was already taken goes back to the guest once the fee is kept
+func cancellationRefund(paid []models.Payment, fee int) int {
+	refund := 0
+	for _, payment := range paid {
+		charge := fee
+		if charge > payment.Amount {
+			charge = payment.Amount
+		}
+		fee -= charge
+		if payments.Status(payment.Status) == payments.StatusCaptured {
+			refund += payment.Amount - charge
+		}
+	}
+	return refund
+}
+
+//settleCancellation takes the fee from the reservation's payments, capturing or voiding deposits and refunding
+//captured payments, and returns the amount it refunded. Each payment is claimed before the provider is called so
+//it is only settled once, and released again when the provider fails so a retry can settle it
+func (m *Repository) settleCancellation(ctx context.Context, paid []models.Payment, fee int) (int, error) {
+	refund := 0
+	for _, payment := range paid {
+		charge := fee
+		if charge > payment.Amount {
+			charge = payment.Amount
+		}
+		fee -= charge
+		if !payment.SettledAt.IsZero() {
+			continue
+		}
+
+		err := m.DB.ClaimPaymentSettlement(ctx, payment.ID)
+		if errors.Is(err, repository.ErrAlreadySettled) {
+			continue
+		}
+		if err != nil {
+			return refund, err
+		}
+
+		back := 0
+		switch payments.Status(payment.Status) {
+		case payments.StatusAuthorized:
+			if charge > 0 {
+				_, err = m.Payments.Capture(ctx, payment.ProviderID, charge)
+			} else {
+				_, err = m.Payments.Void(ctx, payment.ProviderID)
+			}
+		case payments.StatusCaptured:
+			if back = payment.Amount - charge; back > 0 {
+				_, err = m.Payments.Refund(ctx, payment.ProviderID, back)
+			}
+		}
+		if err != nil {
+			if err := m.DB.ReleasePaymentSettlement(ctx, payment.ID); err != nil {
+				m.App.ErrorLog.Println("cannot release the payment for another settlement:", err)
+			}
+			return refund, err
+		}
+		refund += back
+	}
+	return refund, nil
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redblue-blur/bookings/internal/forms"
+	"github.com/redblue-blur/bookings/internal/helpers"
+	"github.com/redblue-blur/bookings/internal/models"
+	"github.com/redblue-blur/bookings/internal/render"
+	"github.com/redblue-blur/bookings/internal/repository"
+)
+
+//guestRow is a guest profile on the guests page
+type guestRow struct {
+	models.Guest
+	Duplicate bool //another profile has the same name, it may be the same guest booking with another email
+}
+
+//AdminGuests lists the guest profiles, or those matching the search, with the tool to merge duplicates
+func (m *Repository) AdminGuests(w http.ResponseWriter, r *http.Request) {
+	search := r.URL.Query().Get("q")
+	guests, err := m.DB.AllGuests(r.Context(), search)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	names := make(map[string]int)
+	for _, g := range guests {
+		names[guestName(g)]++
+	}
+	var rows []guestRow
+	for _, g := range guests {
+		rows = append(rows, guestRow{Guest: g, Duplicate: guestName(g) != "" && names[guestName(g)] > 1})
+	}
+
+	data := make(map[string]interface{})
+	data["guests"] = rows
+	data["search"] = search
+
+	render.Template(w, r, "admin-guests.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: data,
+	})
+}
+
+//AdminGuest shows a guest profile with its stay history and notes
+func (m *Repository) AdminGuest(w http.ResponseWriter, r *http.Request) {
+	g, ok := m.guestByID(w, r)
+	if ok {
+		m.renderAdminGuest(w, r, g, forms.New(guestValues(g)))
+	}
+}
+
+//PostAdminGuest saves the name, phone and notes of a guest profile
+func (m *Repository) PostAdminGuest(w http.ResponseWriter, r *http.Request) {
+	g, ok := m.guestByID(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("last_name")
+	g.FirstName = strings.TrimSpace(form.Get("first_name"))
+	g.LastName = strings.TrimSpace(form.Get("last_name"))
+	g.Phone = strings.TrimSpace(form.Get("phone"))
+	g.Notes = strings.TrimSpace(form.Get("notes"))
+
+	if !form.Valid() {
+		m.renderAdminGuest(w, r, g, form)
+		return
+	}
+
+	err = m.DB.UpdateGuest(r.Context(), g)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Guest profile saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/guests/%d", g.ID), http.StatusSeeOther)
+}
+
+//PostAdminMergeGuests merges the ticked guest profiles into the one chosen to keep
+func (m *Repository) PostAdminMergeGuests(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	keepID, err := strconv.Atoi(r.Form.Get("keep"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Choose the profile to keep")
+		http.Redirect(w, r, "/admin/guests", http.StatusSeeOther)
+		return
+	}
+
+	var mergeIDs []int
+	for _, value := range r.Form["merge"] {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			helpers.ClientError(w, http.StatusBadRequest)
+			return
+		}
+		if id != keepID {
+			mergeIDs = append(mergeIDs, id)
+		}
+	}
+	if len(mergeIDs) == 0 {
+		m.App.Session.Put(r.Context(), "error", "Tick the profiles to merge into the one kept")
+		http.Redirect(w, r, "/admin/guests", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.MergeGuests(r.Context(), keepID, mergeIDs)
+	if errors.Is(err, repository.ErrNotFound) {
+		m.App.Session.Put(r.Context(), "error", "A profile to merge no longer exists")
+		http.Redirect(w, r, "/admin/guests", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Merged %d profiles into this one", len(mergeIDs)))
+	http.Redirect(w, r, fmt.Sprintf("/admin/guests/%d", keepID), http.StatusSeeOther)
+}
+
+//guestByID finds the guest profile for the id in the url, writing the error response when it cannot
+func (m *Repository) guestByID(w http.ResponseWriter, r *http.Request) (models.Guest, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.Guest{}, false
+	}
+	g, err := m.DB.GetGuest(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return g, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return g, false
+	}
+	return g, true
+}
+
+//renderAdminGuest shows a guest profile with the form for its details
+func (m *Repository) renderAdminGuest(w http.ResponseWriter, r *http.Request, g models.Guest, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["guest"] = g
+
+	render.Template(w, r, "admin-guest.page.html", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+//guestValues fills the profile form with a guest's current details
+func guestValues(g models.Guest) url.Values {
+	return url.Values{
+		"first_name": {g.FirstName},
+		"last_name":  {g.LastName},
+		"phone":      {g.Phone},
+		"notes":      {g.Notes},
+	}
+}
+
+//guestName is the name profiles are compared by to find possible duplicates
+func guestName(g models.Guest) string {
+	return strings.ToLower(strings.Join(strings.Fields(g.FirstName+" "+g.LastName), " "))
+}
@@ -1,34 +1,66 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/redblue-blur/bookings/internal/config"
 	"github.com/redblue-blur/bookings/internal/driver"
 	"github.com/redblue-blur/bookings/internal/forms"
 	"github.com/redblue-blur/bookings/internal/helpers"
+	"github.com/redblue-blur/bookings/internal/mailer"
 	"github.com/redblue-blur/bookings/internal/models"
+	"github.com/redblue-blur/bookings/internal/payments"
+	"github.com/redblue-blur/bookings/internal/pricing"
 	"github.com/redblue-blur/bookings/internal/render"
 	"github.com/redblue-blur/bookings/internal/repository"
 	"github.com/redblue-blur/bookings/internal/repository/dbrepo"
 )
 
+//dateLayout is the format used by the date pickers on the site
+const dateLayout = "02-01-2006"
+
 //Repo is the repository used by handlers
 var Repo *Repository
 
 //Repository is the repository type
 type Repository struct {
-	App *config.AppConfig
-	DB  repository.DatabaseRepo
+	App      *config.AppConfig
+	DB       repository.DatabaseRepo
+	Payments payments.Gateway
+	Mailer   mailer.Mailer
 }
 
-//NewRepo creates a new repository
+//NewRepo creates a new repository, backed by memory when there is no database, taking payments through
+//the fake gateway and logging emails
 func NewRepo(a *config.AppConfig, db *driver.DB) *Repository {
-	return &Repository{
-		App: a,
-		DB:  dbrepo.NewPostgresRepo(db.SQL, a),
+	gateway := payments.NewFake()
+	repo := &Repository{
+		App:      a,
+		Payments: gateway,
+		Mailer:   mailer.Log{Logger: a.InfoLog},
+	}
+	if db == nil {
+		repo.DB = dbrepo.NewMemoryRepo(a)
+	} else {
+		repo.DB = dbrepo.NewPostgresRepo(db.SQL, a)
+	}
+	gateway.Subscribe(repo.paymentEvent)
+	return repo
+}
+
+//paymentEvent keeps the stored payments in step with the callbacks from the payment provider
+func (m *Repository) paymentEvent(e payments.Event) {
+	err := m.DB.UpdatePaymentStatus(context.Background(), m.Payments.Name(), e.Payment.ID, string(e.Payment.Status))
+	if err != nil {
+		m.App.ErrorLog.Println(err)
 	}
 }
 
@@ -50,16 +82,180 @@ func (m *Repository) About(w http.ResponseWriter, r *http.Request) {
 
 //Reservation is the Reservation form handeler
 func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
-	var emptyReservation models.Reservation
+	//the dates and room come from the availability search when there is one
+	reservation, _ := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
 	data := make(map[string]interface{})
-	data["reservation"] = emptyReservation
+	intMap := make(map[string]int)
+	stringMap := make(map[string]string)
+
+	if reservation.RoomID != 0 {
+		room, err := m.propertyRoom(r, reservation.RoomID)
+		if errors.Is(err, repository.ErrNotFound) {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		reservation.Room = room
+
+		quote, err := m.quote(r, room, reservation.StartDate, reservation.EndDate)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if err := m.addTaxes(r, &quote, reservation.Guests); err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["quote"] = quote
+		intMap["deposit"] = quote.Deposit(m.App.DepositPercent)
+
+		policy, err := m.cancellationPolicy(r, quote.CancellationPolicyID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		stringMap["policy"] = pricing.DescribePolicy(policy)
+
+		//the room is only held for a stay its rules allow
+		err = m.checkStayRules(r, reservation.RoomID, reservation.StartDate, reservation.EndDate)
+		var stayErr *pricing.StayError
+		if errors.As(err, &stayErr) {
+			m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Sorry, %s cannot be booked for those dates: %s", room.RoomName, stayErr.Error()))
+			http.Redirect(w, r, helpers.SitePath(r, "/search-availability"), http.StatusSeeOther)
+			return
+		}
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		//hold the room while the guest fills in the form
+		err = m.holdRoom(r, reservation)
+		if err != nil {
+			var conflict *repository.ConflictError
+			if errors.As(err, &conflict) {
+				m.App.Session.Put(r.Context(), "error", "Sorry, someone else is booking that room for those dates. Please search again.")
+				http.Redirect(w, r, helpers.SitePath(r, "/search-availability"), http.StatusSeeOther)
+				return
+			}
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	data["reservation"] = reservation
+	intMap["hold_minutes"] = int(m.App.HoldDuration.Minutes())
 
 	render.Template(w, r, "reservation.page.html", &models.TemplateData{
-		Form: forms.New(nil),
-		Data: data,
+		Form:      forms.New(nil),
+		Data:      data,
+		IntMap:    intMap,
+		StringMap: stringMap,
 	})
 }
 
+//holdRoom places a hold for the reservation's room and dates, reusing the guest's hold when it still matches,
+//a new hold replaces the one the session had so a guest holds one room at a time
+func (m *Repository) holdRoom(r *http.Request, reservation models.Reservation) error {
+	hold, _ := m.App.Session.Get(r.Context(), "hold").(models.RoomRestriction)
+	if hold.ID != 0 && hold.RoomID == reservation.RoomID && hold.StartDate.Equal(reservation.StartDate) && hold.EndDate.Equal(reservation.EndDate) {
+		return nil
+	}
+
+	owner := m.App.Session.GetString(r.Context(), "hold_owner")
+	if owner == "" {
+		token, _, err := helpers.NewToken()
+		if err != nil {
+			return err
+		}
+		owner = token
+		m.App.Session.Put(r.Context(), "hold_owner", owner)
+	}
+
+	expires := time.Now().Add(m.App.HoldDuration)
+	holdID, err := m.DB.InsertHold(r.Context(), owner, reservation.RoomID, reservation.StartDate, reservation.EndDate, expires)
+	if err != nil {
+		return err
+	}
+
+	m.App.Session.Put(r.Context(), "hold", models.RoomRestriction{
+		ID:            holdID,
+		RoomID:        reservation.RoomID,
+		StartDate:     reservation.StartDate,
+		EndDate:       reservation.EndDate,
+		RestrictionID: models.RestrictionHold,
+		HoldOwner:     owner,
+		ExpiresAt:     expires,
+	})
+	return nil
+}
+
+//propertyRoom gets one of the request's property's rooms, the rooms of other properties are not found
+func (m *Repository) propertyRoom(r *http.Request, id int) (models.Room, error) {
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if err == nil && room.PropertyID != helpers.SiteFrom(r.Context()).Property.ID {
+		return models.Room{}, repository.ErrNotFound
+	}
+	return room, err
+}
+
+//quote prices a stay in the room using the seasonal rates and discounts stored for it
+func (m *Repository) quote(r *http.Request, room models.Room, start, end time.Time) (pricing.Breakdown, error) {
+	seasons, err := m.DB.SeasonalRatesForRoom(r.Context(), room.ID, start, end)
+	if err != nil {
+		return pricing.Breakdown{}, err
+	}
+	discounts, err := m.DB.StayDiscountsForRoom(r.Context(), room.ID)
+	if err != nil {
+		return pricing.Breakdown{}, err
+	}
+	return pricing.Quote(room, start, end, pricing.Rules{
+		Seasons:   seasons,
+		Discounts: discounts,
+	})
+}
+
+//today is the current date where the site's property is, stay dates are local dates of the property
+func today(r *http.Request) time.Time {
+	return helpers.SiteFrom(r.Context()).Property.Today(time.Now())
+}
+
+//addTaxes adds the property's taxes and fees in effect today to the quote
+func (m *Repository) addTaxes(r *http.Request, quote *pricing.Breakdown, guests int) error {
+	rules, err := m.DB.AllTaxRules(r.Context(), helpers.SiteFrom(r.Context()).Property.ID)
+	if err != nil {
+		return err
+	}
+	quote.ApplyTaxes(pricing.TaxesInEffect(rules, today(r)), guests)
+	return nil
+}
+
+//bookReservation confirms the guest's hold as the reservation, or books it directly when there is no usable hold
+func (m *Repository) bookReservation(r *http.Request, reservation models.Reservation) (int, error) {
+	hold, _ := m.App.Session.Get(r.Context(), "hold").(models.RoomRestriction)
+	if hold.ID == 0 {
+		return m.DB.InsertReservation(r.Context(), reservation)
+	}
+
+	newID, err := m.DB.ConvertHold(r.Context(), hold.ID, reservation)
+	if err == nil {
+		m.App.Session.Remove(r.Context(), "hold")
+	}
+	if !errors.Is(err, repository.ErrHoldExpired) {
+		return newID, err
+	}
+
+	//the hold was swept or is for other dates, so let go of it and try to book directly
+	if err := m.DB.ReleaseHold(r.Context(), hold.ID); err != nil {
+		return 0, err
+	}
+	m.App.Session.Remove(r.Context(), "hold")
+	return m.DB.InsertReservation(r.Context(), reservation)
+}
+
 //PostReservation handels trhe posting of a reservation form
 func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
@@ -72,55 +268,279 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		LastName:  r.Form.Get("last_name"),
 		Email:     r.Form.Get("email"),
 		Phone:     r.Form.Get("phone"),
+		Guests:    1,
 	}
 	form := forms.New(r.PostForm)
 	// form.Has("first_name", r)
-	form.Required("first_name", "last_name", "email")
+	form.Required("first_name", "last_name", "email", "start_date", "end_date", "room_id",
+		"card_number", "card_exp", "card_cvc")
 	form.MinLength("first_name", 3)
 	form.IsEmail("email")
 
+	if form.Get("start_date") != "" {
+		reservation.StartDate, err = time.Parse(dateLayout, form.Get("start_date"))
+		if err != nil {
+			form.Errors.Add("start_date", "Invalid date")
+		} else if reservation.StartDate.Before(today(r)) {
+			form.Errors.Add("start_date", "Arrival can't be in the past")
+		}
+	}
+	if form.Get("end_date") != "" {
+		reservation.EndDate, err = time.Parse(dateLayout, form.Get("end_date"))
+		if err != nil {
+			form.Errors.Add("end_date", "Invalid date")
+		} else if !reservation.EndDate.After(reservation.StartDate) {
+			form.Errors.Add("end_date", "Departure must be after arrival")
+		}
+	}
+	if form.Get("room_id") != "" {
+		reservation.RoomID, err = strconv.Atoi(form.Get("room_id"))
+		if err != nil {
+			form.Errors.Add("room_id", "Invalid room")
+		}
+	}
+	card := payments.Card{
+		Number: strings.TrimSpace(form.Get("card_number")),
+		CVC:    form.Get("card_cvc"),
+	}
+	if form.Get("card_exp") != "" {
+		card.ExpMonth, card.ExpYear, err = parseCardExpiry(form.Get("card_exp"))
+		if err != nil {
+			form.Errors.Add("card_exp", "Use MM/YY")
+		}
+	}
+	if form.Get("guests") != "" {
+		reservation.Guests, err = strconv.Atoi(form.Get("guests"))
+		if err != nil || reservation.Guests < 1 {
+			form.Errors.Add("guests", "Invalid number of guests")
+		}
+	}
+
+	if form.Valid() {
+		room, err := m.propertyRoom(r, reservation.RoomID)
+		if errors.Is(err, repository.ErrNotFound) {
+			form.Errors.Add("room_id", "Invalid room")
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		} else if sleeps := room.MaxAdults + room.MaxChildren; reservation.Guests > sleeps {
+			form.Errors.Add("guests", fmt.Sprintf("This room sleeps at most %d guests", sleeps))
+		}
+		reservation.Room = room
+	}
+
+	if form.Valid() {
+		err = m.checkStayRules(r, reservation.RoomID, reservation.StartDate, reservation.EndDate)
+		var stayErr *pricing.StayError
+		if errors.As(err, &stayErr) {
+			if stayErr.Arrival != "" {
+				form.Errors.Add("start_date", stayErr.Arrival)
+			}
+			if stayErr.Departure != "" {
+				form.Errors.Add("end_date", stayErr.Departure)
+			}
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	var promo models.PromoCode
+	if code := strings.TrimSpace(form.Get("promo_code")); code != "" && form.Valid() {
+		promo, err = m.DB.GetPromoCodeByCode(r.Context(), helpers.SiteFrom(r.Context()).Property.ID, code)
+		if errors.Is(err, repository.ErrNotFound) {
+			form.Errors.Add("promo_code", "Unknown promo code")
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		} else {
+			form.Validate("promo_code", func(string) error {
+				return pricing.CheckPromo(promo, reservation.RoomID, reservation.StartDate, reservation.EndDate, today(r))
+			})
+		}
+	}
+
 	if !form.Valid() {
-		data := make(map[string]interface{})
-		data["reservation"] = reservation
+		m.renderReservationForm(w, r, reservation, form)
+		return
+	}
+
+	quote, err := m.quote(r, reservation.Room, reservation.StartDate, reservation.EndDate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if promo.ID != 0 {
+		reservation.PromoCodeID = promo.ID
+		reservation.PromoDiscount = quote.ApplyPromo(promo)
+	}
+	if err := m.addTaxes(r, &quote, reservation.Guests); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	reservation.TotalPrice = quote.Total
+	reservation.LineItems = quote.LineItems()
+	reservation.CancellationPolicyID = quote.CancellationPolicyID
+	reservation.Code, err = newReservationCode()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-		render.Template(w, r, "reservation.page.html", &models.TemplateData{
-			Form: form,
-			Data: data,
+	//the reservation is only confirmed once the deposit is authorized
+	if deposit := quote.Deposit(m.App.DepositPercent); deposit > 0 {
+		payment, err := m.Payments.Authorize(r.Context(), payments.AuthorizeRequest{
+			Amount:    deposit,
+			Card:      card,
+			Reference: fmt.Sprintf("room %d, %s to %s", reservation.RoomID, form.Get("start_date"), form.Get("end_date")),
+		})
+		var decline *payments.DeclineError
+		if errors.As(err, &decline) {
+			form.Errors.Add("card_number", decline.Message)
+			m.renderReservationForm(w, r, reservation, form)
+			return
+		}
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		reservation.Payments = append(reservation.Payments, models.Payment{
+			Provider:   m.Payments.Name(),
+			ProviderID: payment.ID,
+			Status:     string(payment.Status),
+			Amount:     payment.Amount,
+			CardLast4:  payment.CardLast4,
 		})
+	}
+
+	newReservationID, err := m.bookReservation(r, reservation)
+	if err != nil {
+		m.voidPayments(r, reservation.Payments)
+		if errors.Is(err, repository.ErrPromoCodeUsedUp) {
+			form.Errors.Add("promo_code", "This promo code has been fully redeemed")
+			m.renderReservationForm(w, r, reservation, form)
+			return
+		}
+		var conflict *repository.ConflictError
+		if errors.As(err, &conflict) {
+			m.App.Session.Put(r.Context(), "error", "Sorry, that room just got taken for those dates. Please search again.")
+			http.Redirect(w, r, helpers.SitePath(r, "/search-availability"), http.StatusSeeOther)
+			return
+		}
+		helpers.ServerError(w, err)
 		return
 	}
+	reservation.ID = newReservationID
 
 	m.App.Session.Put(r.Context(), "reservation", reservation)
 
-	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+	http.Redirect(w, r, helpers.SitePath(r, "/reservation-summary"), http.StatusSeeOther)
 
 }
 
+//checkStayRules returns a *pricing.StayError when the stay breaks one of the room's stay rules
+func (m *Repository) checkStayRules(r *http.Request, roomID int, start, end time.Time) error {
+	rules, err := m.DB.StayRulesForRoom(r.Context(), roomID, start, end)
+	if err != nil {
+		return err
+	}
+	return pricing.CheckStay(rules, start, end)
+}
+
+//voidPayments lets go of the deposit authorized for a reservation or booking that could not be booked
+func (m *Repository) voidPayments(r *http.Request, payments []models.Payment) {
+	for _, payment := range payments {
+		if _, err := m.Payments.Void(r.Context(), payment.ProviderID); err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+	}
+}
+
+//parseCardExpiry reads a card expiry date written as MM/YY
+func parseCardExpiry(s string) (int, int, error) {
+	parts := strings.Split(strings.TrimSpace(s), "/")
+	if len(parts) != 2 {
+		return 0, 0, errors.New("expiry must be MM/YY")
+	}
+	month, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || month < 1 || month > 12 {
+		return 0, 0, errors.New("invalid expiry month")
+	}
+	year, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil || year < 0 {
+		return 0, 0, errors.New("invalid expiry year")
+	}
+	if year < 100 {
+		year += 2000
+	}
+	return month, year, nil
+}
+
+//renderReservationForm shows the reservation form again with the errors found in it
+func (m *Repository) renderReservationForm(w http.ResponseWriter, r *http.Request, reservation models.Reservation, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["reservation"] = reservation
+
+	render.Template(w, r, "reservation.page.html", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
 //ReservationSummary is used to display reservation details
 func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
 	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
 	if !ok {
 		m.App.ErrorLog.Println("cannot get item from session")
 		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		http.Redirect(w, r, helpers.SitePath(r, "/"), http.StatusTemporaryRedirect)
 		return
 	}
 	m.App.Session.Remove(r.Context(), "reservation")
 	data := make(map[string]interface{})
 	data["reservation"] = reservation
+	if len(reservation.LineItems) > 0 {
+		data["quote"] = pricing.FromLineItems(reservation.LineItems)
+	}
 	render.Template(w, r, "reservation-summary.page.html", &models.TemplateData{
 		Data: data,
 	})
 }
 
-//Generals is the Room page handeler
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "generals.page.html", &models.TemplateData{})
+//Rooms lists every room of the property
+func (m *Repository) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context(), helpers.SiteFrom(r.Context()).Property.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "rooms.page.html", &models.TemplateData{
+		Data: data,
+	})
 }
 
-//Majors is the Room page handeler
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "majors.page.html", &models.TemplateData{})
+//Room is the Room page handeler, the room is looked up by the slug in the url
+func (m *Repository) Room(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomBySlug(r.Context(), helpers.SiteFrom(r.Context()).Property.ID, chi.URLParam(r, "slug"))
+	if errors.Is(err, repository.ErrNotFound) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	render.Template(w, r, "room.page.html", &models.TemplateData{
+		Data: data,
+	})
 }
 
 //Availability is the search availability page handeler
@@ -130,32 +550,215 @@ func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
 
 //PostAvailability is the search availability page handeler
 func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
-	start := r.Form.Get("start")
-	end := r.Form.Get("end")
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	startDate, err := time.Parse(dateLayout, r.Form.Get("start"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Invalid arrival date")
+		http.Redirect(w, r, helpers.SitePath(r, "/search-availability"), http.StatusSeeOther)
+		return
+	}
+	if startDate.Before(today(r)) {
+		m.App.Session.Put(r.Context(), "error", "Arrival can't be in the past")
+		http.Redirect(w, r, helpers.SitePath(r, "/search-availability"), http.StatusSeeOther)
+		return
+	}
+	endDate, err := time.Parse(dateLayout, r.Form.Get("end"))
+	if err != nil || !endDate.After(startDate) {
+		m.App.Session.Put(r.Context(), "error", "Invalid departure date")
+		http.Redirect(w, r, helpers.SitePath(r, "/search-availability"), http.StatusSeeOther)
+		return
+	}
+
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), helpers.SiteFrom(r.Context()).Property.ID, startDate, endDate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	//rooms whose stay rules turn the stay down are left out, when that leaves none the guest is told why
+	var bookable []models.Room
+	var refusal *pricing.StayError
+	for _, room := range rooms {
+		err := m.checkStayRules(r, room.ID, startDate, endDate)
+		var stayErr *pricing.StayError
+		if errors.As(err, &stayErr) {
+			if refusal == nil {
+				refusal = stayErr
+			}
+			continue
+		}
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		bookable = append(bookable, room)
+	}
+	if len(bookable) == 0 && refusal != nil {
+		m.App.Session.Put(r.Context(), "error", refusal.Error())
+		http.Redirect(w, r, helpers.SitePath(r, "/search-availability"), http.StatusSeeOther)
+		return
+	}
+	rooms = bookable
+
+	if len(rooms) == 0 {
+		m.App.Session.Put(r.Context(), "error", "No availability")
+		waitlist := fmt.Sprintf("/waitlist?s=%s&e=%s", r.Form.Get("start"), r.Form.Get("end"))
+		http.Redirect(w, r, helpers.SitePath(r, waitlist), http.StatusSeeOther)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
 
-	w.Write([]byte(fmt.Sprintf("start date is %s end date is %s", start, end)))
-	// render.Template(w, r, "search-availability.page.html", &models.TemplateData{})
+	reservation := models.Reservation{
+		StartDate: startDate,
+		EndDate:   endDate,
+	}
+	m.App.Session.Put(r.Context(), "reservation", reservation)
+
+	render.Template(w, r, "choose-room.page.html", &models.TemplateData{
+		Data: data,
+	})
 }
 
 type JsonResponse struct {
-	Ok      bool   `json:"ok"`
-	Message string `json:"message"`
+	Ok        bool   `json:"ok"`
+	Message   string `json:"message"`
+	RoomID    string `json:"room_id"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
 }
 
 //AvailabilityJSON is the requests for availability and sends Json response
 func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 	resp := JsonResponse{
-		Ok:      true,
-		Message: "available",
+		Ok:      false,
+		Message: "Invalid request",
 	}
+
+	err := r.ParseForm()
+	if err == nil {
+		sd := r.Form.Get("start")
+		ed := r.Form.Get("end")
+		startDate, errStart := time.Parse(dateLayout, sd)
+		endDate, errEnd := time.Parse(dateLayout, ed)
+		roomID, errRoom := strconv.Atoi(r.Form.Get("room_id"))
+
+		//rooms of other properties are an invalid request like rooms that do not exist
+		if errRoom == nil {
+			_, errRoom = m.propertyRoom(r, roomID)
+			if errRoom != nil && !errors.Is(errRoom, repository.ErrNotFound) {
+				helpers.ServerError(w, errRoom)
+				return
+			}
+		}
+
+		if errStart == nil && errEnd == nil && errRoom == nil && endDate.After(startDate) {
+			available, err := m.DB.SearchAvailabilityByDatesByRoomID(r.Context(), startDate, endDate, roomID)
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+			resp = JsonResponse{
+				Ok:        available,
+				Message:   "available",
+				RoomID:    strconv.Itoa(roomID),
+				StartDate: sd,
+				EndDate:   ed,
+			}
+			if !available {
+				resp.Message = "not available"
+			}
+			if available {
+				err := m.checkStayRules(r, roomID, startDate, endDate)
+				var stayErr *pricing.StayError
+				if errors.As(err, &stayErr) {
+					resp.Ok = false
+					resp.Message = stayErr.Error()
+				} else if err != nil {
+					helpers.ServerError(w, err)
+					return
+				}
+			}
+		}
+	}
+
 	out, err := json.MarshalIndent(resp, "", "    ")
 	if err != nil {
 		helpers.ServerError(w, err)
+		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(out)
 }
 
+//ChooseRoom stores the room picked from the availability results and moves on to the reservation form
+func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
+	roomID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
+		http.Redirect(w, r, helpers.SitePath(r, "/search-availability"), http.StatusSeeOther)
+		return
+	}
+	reservation.RoomID = roomID
+
+	m.App.Session.Put(r.Context(), "reservation", reservation)
+	http.Redirect(w, r, helpers.SitePath(r, "/reservation"), http.StatusSeeOther)
+}
+
+//BookRoom takes the room and dates from the room page availability check and moves on to the reservation form,
+//dates that are not a stay from today on and rooms of other properties are a bad request
+func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
+	roomID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	startDate, err := time.Parse(dateLayout, r.URL.Query().Get("s"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	endDate, err := time.Parse(dateLayout, r.URL.Query().Get("e"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	if !endDate.After(startDate) || startDate.Before(today(r)) {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	_, err = m.propertyRoom(r, roomID)
+	if errors.Is(err, repository.ErrNotFound) {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	reservation := models.Reservation{
+		RoomID:    roomID,
+		StartDate: startDate,
+		EndDate:   endDate,
+	}
+
+	m.App.Session.Put(r.Context(), "reservation", reservation)
+	http.Redirect(w, r, helpers.SitePath(r, "/reservation"), http.StatusSeeOther)
+}
+
 //Contact is the Contact page handeler
 func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "contact.page.html", &models.TemplateData{})
@@ -1,10 +1,29 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redblue-blur/bookings/internal/helpers"
+	"github.com/redblue-blur/bookings/internal/mailer"
+	"github.com/redblue-blur/bookings/internal/models"
+	"github.com/redblue-blur/bookings/internal/payments"
+	"github.com/redblue-blur/bookings/internal/pricing"
+	"github.com/redblue-blur/bookings/internal/repository"
 )
 
 type postData struct {
@@ -23,6 +42,9 @@ var theTests = []struct {
 	{"about", "/about", "GET", []postData{}, http.StatusOK},
 	{"gq", "/generals-quaters", "GET", []postData{}, http.StatusOK},
 	{"ms", "/majors-suite", "GET", []postData{}, http.StatusOK},
+	{"rooms", "/rooms", "GET", []postData{}, http.StatusOK},
+	{"room", "/rooms/generals-quarters", "GET", []postData{}, http.StatusOK},
+	{"room-missing", "/rooms/no-such-room", "GET", []postData{}, http.StatusNotFound},
 	{"sa", "/search-availability", "GET", []postData{}, http.StatusOK},
 	{"contact", "/contact", "GET", []postData{}, http.StatusOK},
 	{"reserv", "/reservation", "GET", []postData{}, http.StatusOK},
@@ -40,6 +62,114 @@ var theTests = []struct {
 		{key: "email", value: "email@mail.com"},
 		{key: "phone", value: "1234567890"},
 	}, http.StatusOK},
+	{"post-reserv-dates", "/reservation", "POST", []postData{
+		{key: "first_name", value: "Name"},
+		{key: "last_name", value: "Surname"},
+		{key: "email", value: "email@mail.com"},
+		{key: "phone", value: "1234567890"},
+		{key: "start_date", value: "01-01-2050"},
+		{key: "end_date", value: "03-01-2050"},
+		{key: "room_id", value: "1"},
+	}, http.StatusOK},
+	{"post-sa-dates", "/search-availability", "POST", []postData{
+		{key: "start", value: "01-01-2050"},
+		{key: "end", value: "03-01-2050"},
+	}, http.StatusOK},
+	{"book-room", "/book-room?id=1&s=01-01-2050&e=03-01-2050", "GET", []postData{}, http.StatusOK},
+	{"book-room-bad", "/book-room?id=x", "GET", []postData{}, http.StatusBadRequest},
+	{"book-room-backwards", "/book-room?id=1&s=03-01-2050&e=01-01-2050", "GET", []postData{}, http.StatusBadRequest},
+	{"book-room-past", "/book-room?id=1&s=01-01-2020&e=03-01-2020", "GET", []postData{}, http.StatusBadRequest},
+	{"book-room-missing", "/book-room?id=99&s=01-01-2050&e=03-01-2050", "GET", []postData{}, http.StatusBadRequest},
+	{"choose-room", "/choose-room/1", "GET", []postData{}, http.StatusOK},
+	{"waitlist", "/waitlist?s=01-01-2050&e=03-01-2050", "GET", []postData{}, http.StatusOK},
+	{"post-waitlist", "/waitlist", "POST", []postData{
+		{key: "email", value: "guest@mail.com"},
+		{key: "start", value: "01-01-2050"},
+		{key: "end", value: "03-01-2050"},
+	}, http.StatusOK},
+	{"post-waitlist-bad", "/waitlist", "POST", []postData{
+		{key: "email", value: "not an email"},
+	}, http.StatusOK},
+	{"claim-missing", "/waitlist/claim/nosuchtoken", "GET", []postData{}, http.StatusNotFound},
+	{"site-by-path", "/p/fort-smythe/", "GET", []postData{}, http.StatusOK},
+	{"site-room-by-path", "/p/fort-smythe/rooms/majors-suite", "GET", []postData{}, http.StatusOK},
+	{"site-missing", "/p/no-such-inn/rooms", "GET", []postData{}, http.StatusNotFound},
+	{"admin-properties", "/admin/properties", "GET", []postData{}, http.StatusOK},
+	{"post-admin-properties", "/admin/properties", "POST", []postData{
+		{key: "name", value: "Lighthouse Inn"},
+		{key: "slug", value: "lighthouse"},
+	}, http.StatusOK},
+	{"post-admin-properties-bad", "/admin/properties", "POST", []postData{
+		{key: "name", value: "Fort Smythe Again"},
+		{key: "slug", value: "fort-smythe"},
+	}, http.StatusOK},
+	{"admin-property", "/admin/properties/fort-smythe", "GET", []postData{}, http.StatusOK},
+	{"admin-property-missing", "/admin/properties/no-such-inn", "GET", []postData{}, http.StatusNotFound},
+	{"post-admin-property", "/admin/properties/fort-smythe", "POST", []postData{
+		{key: "name", value: "Fort Smythe Bed and Breakfast"},
+		{key: "email", value: "bookings@localhost"},
+		{key: "time_zone", value: "UTC"},
+		{key: "check_in_time", value: "15:00"},
+		{key: "check_out_time", value: "11:00"},
+		{key: "calendar_names", value: "hidden"},
+	}, http.StatusOK},
+	{"post-admin-property-bad-times", "/admin/properties/fort-smythe", "POST", []postData{
+		{key: "name", value: "Fort Smythe Bed and Breakfast"},
+		{key: "time_zone", value: "Mars/Olympus_Mons"},
+		{key: "check_in_time", value: "3pm"},
+		{key: "check_out_time", value: "11:00"},
+	}, http.StatusOK},
+	{"post-admin-room-calendar", "/admin/properties/fort-smythe/rooms/2/calendar", "POST", []postData{}, http.StatusOK},
+	{"post-admin-room-calendar-missing", "/admin/properties/fort-smythe/rooms/99/calendar", "POST", []postData{}, http.StatusNotFound},
+	{"post-admin-sync-calendar-missing", "/admin/properties/fort-smythe/calendars/99/sync", "POST", []postData{}, http.StatusNotFound},
+	{"post-admin-delete-calendar-missing", "/admin/properties/fort-smythe/calendars/99/delete", "POST", []postData{}, http.StatusNotFound},
+	{"admin-calendar", "/admin/properties/fort-smythe/calendar?month=2050-10", "GET", []postData{}, http.StatusOK},
+	{"admin-calendar-this-month", "/admin/properties/fort-smythe/calendar", "GET", []postData{}, http.StatusOK},
+	{"post-admin-calendar", "/admin/properties/fort-smythe/calendar", "POST", []postData{
+		{key: "month", value: "2050-11"},
+	}, http.StatusOK},
+	{"admin-stay-rules", "/admin/properties/fort-smythe/stay-rules", "GET", []postData{}, http.StatusOK},
+	{"post-admin-stay-rules-bad", "/admin/properties/fort-smythe/stay-rules", "POST", []postData{
+		{key: "room_id", value: "99"},
+		{key: "start_date", value: "01-01-2050"},
+		{key: "end_date", value: "01-01-2049"},
+		{key: "weekdays", value: "7"},
+		{key: "max_nights", value: "-1"},
+	}, http.StatusOK},
+	{"post-admin-delete-stay-rule-missing", "/admin/properties/fort-smythe/stay-rules/99/delete", "POST", []postData{}, http.StatusNotFound},
+	{"group-reservation-no-rooms", "/group-reservation", "GET", []postData{}, http.StatusOK},
+	{"booking-summary-no-booking", "/booking-summary", "GET", []postData{}, http.StatusOK},
+	{"login", "/user/login", "GET", []postData{}, http.StatusOK},
+	{"post-login-bad", "/user/login", "POST", []postData{
+		{key: "email", value: "not-an-email"},
+	}, http.StatusOK},
+	{"post-login", "/user/login", "POST", []postData{
+		{key: "email", value: "admin@example.com"},
+		{key: "password", value: "password"},
+	}, http.StatusOK},
+	{"logout", "/user/logout", "POST", []postData{}, http.StatusOK},
+	{"forgot-password", "/user/forgot-password", "GET", []postData{}, http.StatusOK},
+	{"post-forgot-password", "/user/forgot-password", "POST", []postData{
+		{key: "email", value: "nobody@example.com"},
+	}, http.StatusOK},
+	{"reset-password-missing", "/user/reset-password/no-such-token", "GET", []postData{}, http.StatusNotFound},
+	{"admin-guests", "/admin/guests", "GET", []postData{}, http.StatusOK},
+	{"admin-guests-search", "/admin/guests?q=surname", "GET", []postData{}, http.StatusOK},
+	{"admin-guest-missing", "/admin/guests/999", "GET", []postData{}, http.StatusNotFound},
+	{"post-admin-merge-guests-none", "/admin/guests/merge", "POST", []postData{
+		{key: "keep", value: "1"},
+	}, http.StatusOK},
+	{"admin-taxes", "/admin/properties/fort-smythe/taxes", "GET", []postData{}, http.StatusOK},
+	{"post-admin-taxes", "/admin/properties/fort-smythe/taxes", "POST", []postData{
+		{key: "kind", value: "cleaning"},
+		{key: "name", value: "Cleaning fee"},
+		{key: "amount", value: "30.00"},
+		{key: "effective_from", value: "01-01-2022"},
+	}, http.StatusOK},
+	{"post-admin-taxes-bad", "/admin/properties/fort-smythe/taxes", "POST", []postData{
+		{key: "kind", value: "bogus"},
+		{key: "amount", value: "x"},
+	}, http.StatusOK},
 }
 
 func TestHandlers(t *testing.T) {
@@ -76,3 +206,1569 @@ func TestHandlers(t *testing.T) {
 		}
 	}
 }
+
+func TestRepository_PostReservationConflict(t *testing.T) {
+	getRoutes()
+
+	postedData := url.Values{}
+	postedData.Add("first_name", "Name")
+	postedData.Add("last_name", "Surname")
+	postedData.Add("email", "email@mail.com")
+	postedData.Add("phone", "1234567890")
+	postedData.Add("start_date", "10-02-2050")
+	postedData.Add("end_date", "12-02-2050")
+	postedData.Add("room_id", "2")
+	addCard(postedData, payments.CardApproved)
+
+	for i, expected := range []string{"/reservation-summary", "/search-availability"} {
+		req, _ := http.NewRequest("POST", "/reservation", strings.NewReader(postedData.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("attempt %d: expected %d but got %d", i+1, http.StatusSeeOther, rr.Code)
+		}
+		if loc := rr.Header().Get("Location"); loc != expected {
+			t.Errorf("attempt %d: expected redirect to %s but got %s", i+1, expected, loc)
+		}
+	}
+}
+
+//addCard fills in the deposit card fields of a reservation form
+func addCard(postedData url.Values, number string) {
+	postedData.Add("card_number", number)
+	postedData.Add("card_exp", "12/99")
+	postedData.Add("card_cvc", "123")
+}
+
+//getCtx loads the session and puts the request on the default property's site
+func getCtx(req *http.Request) context.Context {
+	ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
+	if err != nil {
+		log.Println(err)
+	}
+	property, err := Repo.DB.GetPropertyBySlug(ctx, app.DefaultProperty)
+	if err != nil {
+		log.Println(err)
+	}
+	return helpers.WithSite(ctx, helpers.Site{Property: property})
+}
+
+func TestRepository_ReservationHold(t *testing.T) {
+	getRoutes()
+
+	start, _ := time.Parse(dateLayout, "07-03-2050")
+	end, _ := time.Parse(dateLayout, "09-03-2050")
+	reservation := models.Reservation{RoomID: 1, StartDate: start, EndDate: end}
+
+	//the first guest gets a hold on the room
+	guestOne, _ := http.NewRequest("GET", "/reservation", nil)
+	guestOne = guestOne.WithContext(getCtx(guestOne))
+	session.Put(guestOne.Context(), "reservation", reservation)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.Reservation).ServeHTTP(rr, guestOne)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("first guest: expected %d but got %d", http.StatusOK, rr.Code)
+	}
+
+	//the second guest is sent back to search while the hold is in place
+	guestTwo, _ := http.NewRequest("GET", "/reservation", nil)
+	guestTwo = guestTwo.WithContext(getCtx(guestTwo))
+	session.Put(guestTwo.Context(), "reservation", reservation)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.Reservation).ServeHTTP(rr, guestTwo)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("second guest: expected %d but got %d", http.StatusSeeOther, rr.Code)
+	}
+
+	//the first guest confirms and the hold becomes the reservation
+	postedData := url.Values{}
+	postedData.Add("first_name", "Name")
+	postedData.Add("last_name", "Surname")
+	postedData.Add("email", "email@mail.com")
+	postedData.Add("start_date", "07-03-2050")
+	postedData.Add("end_date", "09-03-2050")
+	postedData.Add("room_id", "1")
+	addCard(postedData, payments.CardApproved)
+	post, _ := http.NewRequest("POST", "/reservation", strings.NewReader(postedData.Encode()))
+	post = post.WithContext(guestOne.Context())
+	post.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, post)
+	if loc := rr.Header().Get("Location"); loc != "/reservation-summary" {
+		t.Errorf("confirm: expected redirect to /reservation-summary but got %s", loc)
+	}
+
+	//the summary shows the priced reservation
+	summary, _ := http.NewRequest("GET", "/reservation-summary", nil)
+	summary = summary.WithContext(guestOne.Context())
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.ReservationSummary).ServeHTTP(rr, summary)
+	if rr.Code != http.StatusOK {
+		t.Errorf("summary: expected %d but got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "$178.00") {
+		t.Error("summary does not show the total for two weekday nights")
+	}
+
+	//nothing is left to sweep once the hold is confirmed
+	n, err := Repo.DB.DeleteExpiredHolds(context.Background(), time.Now().Add(time.Hour))
+	if err != nil || n != 0 {
+		t.Errorf("expected no holds left but released %d (%v)", n, err)
+	}
+}
+
+func TestRepository_ReservationHoldPerSession(t *testing.T) {
+	getRoutes()
+	ctx := context.Background()
+
+	start, _ := time.Parse(dateLayout, "14-03-2050")
+	end, _ := time.Parse(dateLayout, "16-03-2050")
+	req, _ := http.NewRequest("GET", "/reservation", nil)
+	req = req.WithContext(getCtx(req))
+
+	//looking at one room and then another leaves only the second one held
+	for _, roomID := range []int{1, 2} {
+		session.Put(req.Context(), "reservation", models.Reservation{RoomID: roomID, StartDate: start, EndDate: end})
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.Reservation).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("room %d: expected %d but got %d", roomID, http.StatusOK, rr.Code)
+		}
+	}
+	if free, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(ctx, start, end, 1); !free {
+		t.Error("expected the hold on room 1 to be released")
+	}
+	if free, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(ctx, start, end, 2); free {
+		t.Error("expected room 2 to be held")
+	}
+
+	//even when the session lost track of its hold, placing another one replaces it
+	session.Remove(req.Context(), "hold")
+	session.Put(req.Context(), "reservation", models.Reservation{RoomID: 1, StartDate: start, EndDate: end})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.Reservation).ServeHTTP(rr, req)
+	if free, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(ctx, start, end, 2); !free {
+		t.Error("expected the hold on room 2 to be released")
+	}
+	n, err := Repo.DB.DeleteExpiredHolds(ctx, time.Now().Add(time.Hour))
+	if err != nil || n != 1 {
+		t.Errorf("expected one hold for the session but released %d (%v)", n, err)
+	}
+}
+
+func TestRepository_ExpiredHoldNotSwept(t *testing.T) {
+	getRoutes()
+	ctx := context.Background()
+
+	start, _ := time.Parse(dateLayout, "14-03-2050")
+	end, _ := time.Parse(dateLayout, "16-03-2050")
+	_, err := Repo.DB.InsertHold(ctx, "someone", 1, start, end, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//a hold that ran out blocks nothing even before the sweeper removes it
+	if free, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(ctx, start, end, 1); !free {
+		t.Error("expected the expired hold not to block the room")
+	}
+	rooms, _ := Repo.DB.SearchAvailabilityForAllRooms(ctx, 1, start, end)
+	found := false
+	for _, room := range rooms {
+		found = found || room.ID == 1
+	}
+	if !found {
+		t.Error("expected the room with an expired hold among the free rooms")
+	}
+	if _, err := Repo.DB.InsertHold(ctx, "someone else", 1, start, end, time.Now().Add(time.Minute)); err != nil {
+		t.Errorf("expected the room to be held over the expired hold but got %v", err)
+	}
+}
+
+func TestRepository_PostReservationPromoCode(t *testing.T) {
+	getRoutes()
+
+	post := func(code string) (*httptest.ResponseRecorder, context.Context) {
+		postedData := url.Values{}
+		postedData.Add("first_name", "Name")
+		postedData.Add("last_name", "Surname")
+		postedData.Add("email", "email@mail.com")
+		postedData.Add("start_date", "14-03-2050")
+		postedData.Add("end_date", "16-03-2050")
+		postedData.Add("room_id", "1")
+		addCard(postedData, payments.CardApproved)
+		postedData.Add("promo_code", code)
+		req, _ := http.NewRequest("POST", "/reservation", strings.NewReader(postedData.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+		return rr, req.Context()
+	}
+
+	//an unknown code is shown next to the field
+	rr, _ := post("NOSUCHCODE")
+	if rr.Code != http.StatusOK {
+		t.Errorf("unknown code: expected %d but got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "Unknown promo code") {
+		t.Error("unknown code: form does not show the promo code error")
+	}
+
+	//a valid code takes 10% off the stay
+	rr, ctx := post("welcome10")
+	if loc := rr.Header().Get("Location"); loc != "/reservation-summary" {
+		t.Fatalf("valid code: expected redirect to /reservation-summary but got %s", loc)
+	}
+	reservation, _ := session.Get(ctx, "reservation").(models.Reservation)
+	if reservation.PromoCodeID != 1 || reservation.PromoDiscount != 1780 || reservation.TotalPrice != 16020 {
+		t.Errorf("valid code: expected a discount of 1780 and a total of 16020 but got %d and %d",
+			reservation.PromoDiscount, reservation.TotalPrice)
+	}
+}
+
+func TestRepository_TaxesKeepBookedRates(t *testing.T) {
+	getRoutes()
+	ctx := context.Background()
+
+	_, err := Repo.DB.InsertTaxRule(ctx, models.TaxRule{
+		PropertyID:    1,
+		Kind:          models.TaxCleaning,
+		Name:          "Cleaning fee",
+		Amount:        3000,
+		EffectiveFrom: time.Now().AddDate(0, 0, -1),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	postedData := url.Values{}
+	postedData.Add("first_name", "Name")
+	postedData.Add("last_name", "Surname")
+	postedData.Add("email", "email@mail.com")
+	postedData.Add("start_date", "21-03-2050")
+	postedData.Add("end_date", "23-03-2050")
+	postedData.Add("room_id", "1")
+	addCard(postedData, payments.CardApproved)
+	postedData.Add("guests", "2")
+	req, _ := http.NewRequest("POST", "/reservation", strings.NewReader(postedData.Encode()))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+	if loc := rr.Header().Get("Location"); loc != "/reservation-summary" {
+		t.Fatalf("expected redirect to /reservation-summary but got %s", loc)
+	}
+
+	reservation, _ := session.Get(req.Context(), "reservation").(models.Reservation)
+	if reservation.TotalPrice != 17800+3000 {
+		t.Errorf("expected a total of %d with the cleaning fee but got %d", 17800+3000, reservation.TotalPrice)
+	}
+
+	//the fee goes up after the booking was made
+	_, err = Repo.DB.InsertTaxRule(ctx, models.TaxRule{
+		PropertyID:    1,
+		Kind:          models.TaxCleaning,
+		Name:          "Cleaning fee",
+		Amount:        4500,
+		EffectiveFrom: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := Repo.DB.LineItemsForReservation(ctx, reservation.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total := pricing.FromLineItems(items).Total; total != reservation.TotalPrice {
+		t.Errorf("expected the stored line items to add up to %d but got %d", reservation.TotalPrice, total)
+	}
+}
+
+func TestRepository_PostReservationTooManyGuests(t *testing.T) {
+	getRoutes()
+
+	postedData := url.Values{}
+	postedData.Add("first_name", "Name")
+	postedData.Add("last_name", "Surname")
+	postedData.Add("email", "email@mail.com")
+	postedData.Add("start_date", "28-03-2050")
+	postedData.Add("end_date", "30-03-2050")
+	postedData.Add("room_id", "1")
+	addCard(postedData, payments.CardApproved)
+	postedData.Add("guests", "4")
+	req, _ := http.NewRequest("POST", "/reservation", strings.NewReader(postedData.Encode()))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "This room sleeps at most 3 guests") {
+		t.Errorf("expected the form again with a guests error but got %d", rr.Code)
+	}
+}
+
+func TestRepository_PostReservationDeposit(t *testing.T) {
+	getRoutes()
+
+	post := func(card string) (*httptest.ResponseRecorder, context.Context) {
+		postedData := url.Values{}
+		postedData.Add("first_name", "Name")
+		postedData.Add("last_name", "Surname")
+		postedData.Add("email", "email@mail.com")
+		postedData.Add("start_date", "04-04-2050")
+		postedData.Add("end_date", "06-04-2050")
+		postedData.Add("room_id", "1")
+		addCard(postedData, card)
+		req, _ := http.NewRequest("POST", "/reservation", strings.NewReader(postedData.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+		return rr, req.Context()
+	}
+
+	//a declined card leaves the room free and shows why next to the card number
+	rr, _ := post(payments.CardInsufficientFunds)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Your card has insufficient funds") {
+		t.Errorf("declined card: expected the form again with the decline but got %d", rr.Code)
+	}
+	start, _ := time.Parse(dateLayout, "04-04-2050")
+	end, _ := time.Parse(dateLayout, "06-04-2050")
+	if free, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(context.Background(), start, end, 1); !free {
+		t.Error("declined card: the room was booked anyway")
+	}
+
+	//an approved card authorizes 20% of the total as the deposit
+	rr, ctx := post(payments.CardApproved)
+	if loc := rr.Header().Get("Location"); loc != "/reservation-summary" {
+		t.Fatalf("approved card: expected redirect to /reservation-summary but got %s", loc)
+	}
+	reservation, _ := session.Get(ctx, "reservation").(models.Reservation)
+	stored, err := Repo.DB.PaymentsForReservation(context.Background(), reservation.ID)
+	if err != nil || len(stored) != 1 {
+		t.Fatalf("approved card: expected one stored payment but got %d (%v)", len(stored), err)
+	}
+	if stored[0].Amount != 3560 || stored[0].Status != string(payments.StatusAuthorized) {
+		t.Errorf("approved card: expected an authorized deposit of 3560 but got %s %d", stored[0].Status, stored[0].Amount)
+	}
+
+	//callbacks from the gateway update the stored payment
+	if _, err := Repo.Payments.Capture(context.Background(), stored[0].ProviderID, 0); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ = Repo.DB.PaymentsForReservation(context.Background(), reservation.ID)
+	if stored[0].Status != string(payments.StatusCaptured) {
+		t.Errorf("expected the capture callback to mark the payment captured but it is %s", stored[0].Status)
+	}
+}
+
+//bookTestReservation posts a reservation for room 1 and returns the one stored in the session
+func bookTestReservation(t *testing.T, roomID int, start, end string) models.Reservation {
+	postedData := url.Values{}
+	postedData.Add("first_name", "Name")
+	postedData.Add("last_name", "Surname")
+	postedData.Add("email", "email@mail.com")
+	postedData.Add("start_date", start)
+	postedData.Add("end_date", end)
+	postedData.Add("room_id", strconv.Itoa(roomID))
+	addCard(postedData, payments.CardApproved)
+	req, _ := http.NewRequest("POST", "/reservation", strings.NewReader(postedData.Encode()))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+	if loc := rr.Header().Get("Location"); loc != "/reservation-summary" {
+		t.Fatalf("booking %s to %s: expected redirect to /reservation-summary but got %s", start, end, loc)
+	}
+	reservation, _ := session.Get(req.Context(), "reservation").(models.Reservation)
+	return reservation
+}
+
+func TestRepository_CancelReservation(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	reservation := bookTestReservation(t, 1, "11-04-2050", "13-04-2050")
+	cancelURL := ts.URL + "/reservations/" + reservation.Code + "/cancel"
+
+	resp, err := ts.Client().Get(cancelURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected %d for the cancellation page but got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	resp, err = ts.Client().PostForm(cancelURL, url.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected %d after cancelling but got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	ctx := context.Background()
+	cancelled, _ := Repo.DB.GetReservationByID(ctx, reservation.ID)
+	if cancelled.CancelledAt.IsZero() || cancelled.CancellationFee != 0 {
+		t.Errorf("expected a free cancellation but got cancelled at %v with a fee of %d", cancelled.CancelledAt, cancelled.CancellationFee)
+	}
+	if free, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(ctx, reservation.StartDate, reservation.EndDate, 1); !free {
+		t.Error("the room is still taken after cancelling")
+	}
+	stored, _ := Repo.DB.PaymentsForReservation(ctx, reservation.ID)
+	if len(stored) != 1 || stored[0].Status != string(payments.StatusVoided) {
+		t.Errorf("expected the deposit to be voided but got %v", stored)
+	}
+
+	resp, _ = ts.Client().Get(ts.URL + "/reservations/NOSUCHCODE/cancel")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected %d for an unknown code but got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestRepository_CancelReservationLate(t *testing.T) {
+	getRoutes()
+
+	today := time.Now().UTC()
+	reservation := bookTestReservation(t, 1, today.Format(dateLayout), today.AddDate(0, 0, 2).Format(dateLayout))
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/reservations/%d/cancel", reservation.ID), nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", strconv.Itoa(reservation.ID))
+	req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostAdminCancelReservation).ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected %d but got %d", http.StatusSeeOther, rr.Code)
+	}
+
+	//arriving today is past the free period of the flexible policy, so the first night is charged
+	ctx := context.Background()
+	cancelled, _ := Repo.DB.GetReservationByID(ctx, reservation.ID)
+	if cancelled.CancellationFee != reservation.LineItems[0].Amount {
+		t.Errorf("expected the first night of %d as the fee but got %d", reservation.LineItems[0].Amount, cancelled.CancellationFee)
+	}
+	stored, _ := Repo.DB.PaymentsForReservation(ctx, reservation.ID)
+	if len(stored) != 1 || stored[0].Status != string(payments.StatusCaptured) {
+		t.Errorf("expected the deposit to be captured for the fee but got %v", stored)
+	}
+}
+
+func TestRepository_CancelReservationTwice(t *testing.T) {
+	getRoutes()
+	ctx := context.Background()
+
+	reservation := bookTestReservation(t, 1, "21-04-2050", "23-04-2050")
+	paid, _ := Repo.DB.PaymentsForReservation(ctx, reservation.ID)
+	if len(paid) != 1 {
+		t.Fatalf("expected one deposit but got %v", paid)
+	}
+	_, err := Repo.Payments.Capture(ctx, paid[0].ProviderID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refunds := 0
+	Repo.Payments.(*payments.Fake).Subscribe(func(e payments.Event) {
+		if e.Type == "payment.refunded" && e.Payment.ID == paid[0].ProviderID {
+			refunds++
+		}
+	})
+
+	//both requests loaded the reservation before either cancelled it, as when the form is posted twice at once
+	res, _ := Repo.DB.GetReservationByID(ctx, reservation.ID)
+	res.Room, _ = Repo.DB.GetRoomByID(ctx, res.RoomID)
+	res.Payments, _ = Repo.DB.PaymentsForReservation(ctx, res.ID)
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/reservations/%d/cancel", res.ID), nil)
+		req = req.WithContext(getCtx(req))
+		rr := httptest.NewRecorder()
+		Repo.cancelReservation(rr, req, res, "/admin/reservations")
+		if rr.Code != http.StatusSeeOther {
+			t.Fatalf("expected %d but got %d", http.StatusSeeOther, rr.Code)
+		}
+	}
+
+	if refunds != 1 {
+		t.Errorf("expected the deposit to be refunded once but it was refunded %d times", refunds)
+	}
+	cancelled, _ := Repo.DB.GetReservationByID(ctx, res.ID)
+	if cancelled.RefundAmount != paid[0].Amount || cancelled.SettlementError != "" {
+		t.Errorf("expected %d refunded and no settlement error but got %d and %q", paid[0].Amount, cancelled.RefundAmount, cancelled.SettlementError)
+	}
+	stored, _ := Repo.DB.PaymentsForReservation(ctx, res.ID)
+	if stored[0].Status != string(payments.StatusRefunded) || stored[0].SettledAt.IsZero() {
+		t.Errorf("expected the deposit to be refunded and settled but got %v", stored[0])
+	}
+}
+
+func TestRepository_Waitlist(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+	sent := Repo.Mailer.(*mailer.Memory)
+
+	first := bookTestReservation(t, 1, "02-05-2050", "04-05-2050")
+	second := bookTestReservation(t, 2, "02-05-2050", "04-05-2050")
+	holds := func(roomID int) int {
+		restrictions, err := Repo.DB.RoomRestrictionsForRoom(context.Background(), roomID, first.StartDate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for _, restriction := range restrictions {
+			if restriction.RestrictionID == models.RestrictionHold {
+				n++
+			}
+		}
+		return n
+	}
+
+	//a search with nothing free offers the waitlist
+	req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader("start=02-05-2050&end=04-05-2050"))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostAvailability).ServeHTTP(rr, req)
+	if loc := rr.Header().Get("Location"); loc != "/waitlist?s=02-05-2050&e=04-05-2050" {
+		t.Errorf("expected a redirect to the waitlist but got %q", loc)
+	}
+
+	for _, email := range []string{"a@mail.com", "b@mail.com", "c@mail.com"} {
+		ts.Client().PostForm(ts.URL+"/waitlist", url.Values{
+			"email": {email},
+			"start": {"02-05-2050"},
+			"end":   {"04-05-2050"},
+		})
+	}
+
+	//the first cancellation goes to the first guest in line
+	ts.Client().PostForm(ts.URL+"/reservations/"+first.Code+"/cancel", url.Values{})
+	if len(sent.Sent()) != 1 || sent.Sent()[0].To != "a@mail.com" {
+		t.Fatalf("expected a claim link for a@mail.com but sent %v", sent.Sent())
+	}
+	body := sent.Sent()[0].Body
+	token := body[strings.Index(body, "/waitlist/claim/")+len("/waitlist/claim/"):]
+	token = strings.TrimSpace(token)
+
+	openClaim := func() (*http.Request, string) {
+		claim, _ := http.NewRequest("GET", "/waitlist/claim/"+token, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("token", token)
+		claim = claim.WithContext(context.WithValue(getCtx(claim), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.ClaimWaitlist).ServeHTTP(rr, claim)
+		return claim, rr.Header().Get("Location")
+	}
+
+	//opening the link, by a link scanner or on another device, does not use it up
+	if _, loc := openClaim(); loc != "/reservation" {
+		t.Errorf("claim: expected a redirect to /reservation but got %q", loc)
+	}
+	claim, loc := openClaim()
+	if loc != "/reservation" {
+		t.Errorf("claim: expected the link to work when opened again but got %q", loc)
+	}
+	reservation, _ := session.Get(claim.Context(), "reservation").(models.Reservation)
+	if reservation.RoomID != first.RoomID || reservation.Email != "a@mail.com" {
+		t.Errorf("claim: expected room %d for a@mail.com but got room %d for %s", first.RoomID, reservation.RoomID, reservation.Email)
+	}
+
+	//the room was held for the guest since the offer and booking it converts that hold
+	hold, _ := session.Get(claim.Context(), "hold").(models.RoomRestriction)
+	if hold.ID == 0 || holds(first.RoomID) != 1 {
+		t.Fatalf("claim: expected the offer's hold in the session but got %+v", hold)
+	}
+	postedData := url.Values{}
+	postedData.Add("first_name", "Waiting")
+	postedData.Add("last_name", "Guest")
+	postedData.Add("email", "a@mail.com")
+	postedData.Add("start_date", "02-05-2050")
+	postedData.Add("end_date", "04-05-2050")
+	postedData.Add("room_id", strconv.Itoa(first.RoomID))
+	addCard(postedData, payments.CardApproved)
+	post, _ := http.NewRequest("POST", "/reservation", strings.NewReader(postedData.Encode()))
+	post = post.WithContext(claim.Context())
+	post.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, post)
+	if loc := rr.Header().Get("Location"); loc != "/reservation-summary" {
+		t.Fatalf("claim: expected the booking to go through but got %q", loc)
+	}
+	booked, _ := session.Get(claim.Context(), "reservation").(models.Reservation)
+	restrictions, _ := Repo.DB.RoomRestrictionsForRoom(context.Background(), first.RoomID, first.StartDate)
+	converted := false
+	for _, restriction := range restrictions {
+		converted = converted || (restriction.ID == hold.ID && restriction.ReservationID == booked.ID)
+	}
+	if !converted || holds(first.RoomID) != 0 {
+		t.Errorf("claim: expected hold %d to become reservation %d but got %+v", hold.ID, booked.ID, restrictions)
+	}
+	if _, loc := openClaim(); loc != "/" {
+		t.Errorf("claim: expected the link to be used up by the booking but got %q", loc)
+	}
+
+	//a claim nobody uses passes to the next guest in line
+	app.WaitlistClaimDuration = -time.Minute
+	defer func() { app.WaitlistClaimDuration = 24 * time.Hour }()
+	ts.Client().PostForm(ts.URL+"/reservations/"+second.Code+"/cancel", url.Values{})
+	if err := Repo.OfferExpiredClaims(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	messages := sent.Sent()
+	if len(messages) != 3 || messages[1].To != "b@mail.com" || messages[2].To != "c@mail.com" {
+		t.Errorf("expected the second room to go to b@mail.com then c@mail.com but sent %v", messages)
+	}
+	if holds(second.RoomID) != 1 {
+		t.Errorf("expected the hold for b@mail.com to be released and one held for c@mail.com but got %d", holds(second.RoomID))
+	}
+}
+
+func TestRepository_Properties(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+	ctx := context.Background()
+
+	propertyID, err := Repo.DB.InsertProperty(ctx, models.Property{
+		Name:     "Harbour Inn",
+		Slug:     "harbour-inn",
+		Hostname: "harbour.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roomID, err := Repo.DB.InsertRoom(ctx, models.Room{
+		PropertyID: propertyID,
+		RoomName:   "Garden Room",
+		Slug:       "garden-room",
+		MaxAdults:  2,
+		BasePrice:  7500,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(host, path string) *http.Response {
+		req, _ := http.NewRequest("GET", ts.URL+path, nil)
+		req.Host = host
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	var tests = []struct {
+		name     string
+		host     string
+		path     string
+		expected int
+	}{
+		{"by path", "", "/p/harbour-inn/rooms/garden-room", http.StatusOK},
+		{"by hostname", "harbour.test", "/rooms/garden-room", http.StatusOK},
+		{"by hostname with port", "harbour.test:8080", "/rooms/garden-room", http.StatusOK},
+		{"other property by path", "", "/p/fort-smythe/rooms/garden-room", http.StatusNotFound},
+		{"default property", "", "/rooms/garden-room", http.StatusNotFound},
+		{"other property's room", "harbour.test", "/rooms/generals-quarters", http.StatusNotFound},
+	}
+	for _, e := range tests {
+		if resp := get(e.host, e.path); resp.StatusCode != e.expected {
+			t.Errorf("%s: expected %d for %s but got %d", e.name, e.expected, e.path, resp.StatusCode)
+		}
+	}
+
+	//availability only offers the property's own rooms
+	resp, err := ts.Client().PostForm(ts.URL+"/p/harbour-inn/search-availability", url.Values{
+		"start": {"01-06-2050"},
+		"end":   {"03-06-2050"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := new(strings.Builder)
+	io.Copy(body, resp.Body)
+	if !strings.Contains(body.String(), "/p/harbour-inn/choose-room/"+strconv.Itoa(roomID)) {
+		t.Errorf("expected the garden room to be offered under /p/harbour-inn")
+	}
+	if strings.Contains(body.String(), "General&#39;s Quarters") {
+		t.Errorf("expected no rooms from the other property")
+	}
+
+	//rooms of another property cannot be booked through this one
+	postedData := url.Values{}
+	postedData.Add("first_name", "Name")
+	postedData.Add("last_name", "Surname")
+	postedData.Add("email", "email@mail.com")
+	postedData.Add("start_date", "01-06-2050")
+	postedData.Add("end_date", "03-06-2050")
+	postedData.Add("room_id", strconv.Itoa(roomID))
+	addCard(postedData, payments.CardApproved)
+	req, _ := http.NewRequest("POST", "/reservation", strings.NewReader(postedData.Encode()))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected the form back with an error but got %d redirecting to %s", rr.Code, rr.Header().Get("Location"))
+	}
+}
+
+func TestRepository_PropertyTimeZone(t *testing.T) {
+	getRoutes()
+	ctx := context.Background()
+
+	//far enough ahead of UTC that the property's today is often tomorrow in UTC
+	property, err := Repo.DB.GetPropertyBySlug(ctx, app.DefaultProperty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := property
+	property.TimeZone = "Pacific/Kiritimati"
+	if err := Repo.DB.UpdateProperty(ctx, property); err != nil {
+		t.Fatal(err)
+	}
+	defer Repo.DB.UpdateProperty(ctx, saved)
+
+	today := property.Today(time.Now())
+	if expected := time.Now().In(property.Location()).Format(dateLayout); today.Format(dateLayout) != expected {
+		t.Errorf("expected the property's today to be %s but got %s", expected, today.Format(dateLayout))
+	}
+
+	//arriving yesterday where the property is cannot be booked
+	yesterday := today.AddDate(0, 0, -1)
+	postedData := url.Values{}
+	postedData.Add("first_name", "Name")
+	postedData.Add("last_name", "Surname")
+	postedData.Add("email", "email@mail.com")
+	postedData.Add("start_date", yesterday.Format(dateLayout))
+	postedData.Add("end_date", today.AddDate(0, 0, 1).Format(dateLayout))
+	postedData.Add("room_id", "2")
+	addCard(postedData, payments.CardApproved)
+	req, _ := http.NewRequest("POST", "/reservation", strings.NewReader(postedData.Encode()))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Arrival can&#39;t be in the past") {
+		t.Errorf("expected the form back with a past arrival error but got %d redirecting to %s", rr.Code, rr.Header().Get("Location"))
+	}
+
+	//arriving today where the property is can
+	bookTestReservation(t, 2, today.Format(dateLayout), today.AddDate(0, 0, 1).Format(dateLayout))
+}
+
+func TestRepository_RoomCalendar(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+	ctx := context.Background()
+
+	get := func(path string) (*http.Response, string) {
+		resp, err := ts.Client().Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body := new(strings.Builder)
+		io.Copy(body, resp.Body)
+		return resp, body.String()
+	}
+
+	if resp, _ := get("/rooms/1/calendar.ics?token="); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected no feed before a link was made but got %d", resp.StatusCode)
+	}
+
+	if err := Repo.DB.SetRoomCalendarToken(ctx, 1, "room-one-secret"); err != nil {
+		t.Fatal(err)
+	}
+	bookTestReservation(t, 1, "01-07-2050", "04-07-2050")
+
+	if resp, _ := get("/rooms/1/calendar.ics?token=guessed"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected a wrong token to be refused but got %d", resp.StatusCode)
+	}
+
+	resp, body := get("/rooms/1/calendar.ics?token=room-one-secret")
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/calendar") {
+		t.Fatalf("expected the feed but got %d with %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(body, "DTSTART;VALUE=DATE:20500701\r\nDTEND;VALUE=DATE:20500704\r\nSUMMARY:Reserved\r\n") {
+		t.Errorf("expected the stay without the guest's name in %q", body)
+	}
+	if strings.Contains(body, "Surname") {
+		t.Error("expected no guest names in the feed")
+	}
+
+	//the property can choose to show initials
+	property, err := Repo.DB.GetPropertyBySlug(ctx, app.DefaultProperty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := property
+	property.CalendarNames = models.CalendarNamesInitials
+	if err := Repo.DB.UpdateProperty(ctx, property); err != nil {
+		t.Fatal(err)
+	}
+	defer Repo.DB.UpdateProperty(ctx, saved)
+
+	if _, body := get("/rooms/1/calendar.ics?token=room-one-secret"); !strings.Contains(body, "SUMMARY:Reserved: N. S.\r\n") {
+		t.Errorf("expected the guest's initials in %q", body)
+	}
+
+	//the feed belongs to the room's own property
+	if resp, _ := get("/p/harbour-inn/rooms/1/calendar.ics?token=room-one-secret"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the feed only under the room's property but got %d", resp.StatusCode)
+	}
+}
+
+func TestRepository_ImportCalendar(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+	ctx := context.Background()
+
+	event := func(uid, start, end string) string {
+		return "BEGIN:VEVENT\r\nUID:" + uid + "\r\nDTSTART;VALUE=DATE:" + start + "\r\nDTEND;VALUE=DATE:" + end +
+			"\r\nSUMMARY:Not available\r\nEND:VEVENT\r\n"
+	}
+	calendar := func(events ...string) string {
+		return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//OTA//EN\r\n" + strings.Join(events, "") + "END:VCALENDAR\r\n"
+	}
+
+	//the OTA's feed for room 2
+	feed := calendar(
+		event("a@ota", "20500801", "20500804"),
+		event("b@ota", "20500805", "20500807"),
+		event("c@ota", "20500811", "20500813"),
+	)
+	status := http.StatusOK
+	ota := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		io.WriteString(w, feed)
+	}))
+	defer ota.Close()
+
+	//the OTA test server listens on loopback, which the calendar client refuses to connect to
+	defer func(client *http.Client) { calendarClient = client }(calendarClient)
+	calendarClient = ota.Client()
+
+	free := func(start, end string) bool {
+		s, _ := time.Parse(dateLayout, start)
+		e, _ := time.Parse(dateLayout, end)
+		ok, err := Repo.DB.SearchAvailabilityByDatesByRoomID(ctx, s, e, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	//the third event collides with a booking made here
+	reservation := bookTestReservation(t, 2, "10-08-2050", "12-08-2050")
+
+	resp, err := ts.Client().PostForm(ts.URL+"/admin/properties/fort-smythe/calendars", url.Values{
+		"room_id": {"2"},
+		"name":    {"OTA"},
+		"url":     {ota.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if free("01-08-2050", "04-08-2050") || free("05-08-2050", "07-08-2050") {
+		t.Error("expected the OTA's bookings to block the room")
+	}
+	calendars, err := Repo.DB.RoomCalendarsForProperty(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calendars) != 1 || calendars[0].LastSyncedAt.IsZero() {
+		t.Fatalf("expected the calendar to be synced when it was added but got %+v", calendars)
+	}
+	conflicts := calendars[0].Conflicts
+	if len(conflicts) != 1 || conflicts[0].UID != "c@ota" || conflicts[0].ReservationID != reservation.ID {
+		t.Errorf("expected c@ota to be reported against reservation %d but got %+v", reservation.ID, conflicts)
+	}
+
+	//the first booking is cancelled on the OTA and the second one moves
+	feed = calendar(
+		event("b@ota", "20500806", "20500809"),
+		event("c@ota", "20500811", "20500813"),
+	)
+	if err := Repo.SyncRoomCalendars(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !free("01-08-2050", "04-08-2050") || !free("05-08-2050", "06-08-2050") {
+		t.Error("expected the dates the OTA no longer has booked to be free")
+	}
+	if free("06-08-2050", "09-08-2050") {
+		t.Error("expected the moved booking to block its new dates")
+	}
+
+	//a feed that cannot be read keeps the dates it blocked
+	status = http.StatusInternalServerError
+	if err := Repo.SyncRoomCalendars(ctx); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Repo.DB.GetRoomCalendar(ctx, calendars[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.LastError == "" || free("06-08-2050", "09-08-2050") {
+		t.Errorf("expected the error to be recorded and the dates kept but got %q", c.LastError)
+	}
+
+	//removing the calendar frees its dates
+	resp, err = ts.Client().PostForm(ts.URL+fmt.Sprintf("/admin/properties/fort-smythe/calendars/%d/delete", c.ID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !free("06-08-2050", "09-08-2050") {
+		t.Error("expected the removed calendar's dates to be free")
+	}
+}
+
+func TestRepository_ImportCalendarFromFile(t *testing.T) {
+	getRoutes()
+	ctx := context.Background()
+
+	app.CalendarImportDir = t.TempDir()
+	defer func() { app.CalendarImportDir = "" }()
+
+	path := filepath.Join(app.CalendarImportDir, "room.ics")
+	feed := "BEGIN:VCALENDAR\nVERSION:2.0\nBEGIN:VEVENT\nUID:owner@file\nDTSTART;VALUE=DATE:20500901\n" +
+		"DTEND;VALUE=DATE:20500903\nEND:VEVENT\nEND:VCALENDAR\n"
+	if err := os.WriteFile(path, []byte(feed), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := Repo.DB.InsertRoomCalendar(ctx, models.RoomCalendar{RoomID: 1, URL: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Repo.DB.DeleteRoomCalendar(ctx, id)
+
+	if err := Repo.SyncRoomCalendars(ctx); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2050, 9, 1, 0, 0, 0, 0, time.UTC)
+	free, err := Repo.DB.SearchAvailabilityByDatesByRoomID(ctx, start, start.AddDate(0, 0, 2), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free {
+		t.Error("expected the event in the file to block the room")
+	}
+}
+
+func TestRepository_FetchCalendarRefused(t *testing.T) {
+	getRoutes()
+	ctx := context.Background()
+
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nEND:VCALENDAR\r\n")
+	}))
+	defer feed.Close()
+
+	app.CalendarImportDir = t.TempDir()
+	defer func() { app.CalendarImportDir = "" }()
+	outside := filepath.Join(t.TempDir(), "outside.ics")
+	if err := os.WriteFile(outside, []byte("BEGIN:VCALENDAR\nEND:VCALENDAR\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	inside := filepath.Join(app.CalendarImportDir, "inside.ics")
+	if err := os.WriteFile(inside, []byte("BEGIN:VCALENDAR\nEND:VCALENDAR\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, source := range []string{
+		feed.URL,
+		"http://localhost:1/calendar.ics",
+		"http://10.0.0.1/calendar.ics",
+		"http://169.254.169.254/latest/meta-data",
+		"ftp://example.com/calendar.ics",
+		outside,
+		"file://" + outside,
+		filepath.Join(app.CalendarImportDir, "..", filepath.Base(filepath.Dir(outside)), "outside.ics"),
+	} {
+		if _, err := Repo.fetchCalendar(ctx, source); err == nil {
+			t.Errorf("expected %s to be refused", source)
+		}
+	}
+
+	if _, err := Repo.fetchCalendar(ctx, "file://"+inside); err != nil {
+		t.Errorf("expected a file in the import directory to be read but got %v", err)
+	}
+	app.InProduction = true
+	defer func() { app.InProduction = false }()
+	if _, err := Repo.fetchCalendar(ctx, inside); err == nil {
+		t.Error("expected files to be refused in production")
+	}
+}
+
+func TestRepository_AdminReservationCalendar(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+	ctx := context.Background()
+
+	post := func(blocks ...string) {
+		resp, err := ts.Client().PostForm(ts.URL+"/admin/properties/fort-smythe/calendar", url.Values{
+			"month": {"2050-10"},
+			"block": blocks,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	free := func(roomID int, start, end string) bool {
+		s, _ := time.Parse(dateLayout, start)
+		e, _ := time.Parse(dateLayout, end)
+		ok, err := Repo.DB.SearchAvailabilityByDatesByRoomID(ctx, s, e, roomID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	reservation := bookTestReservation(t, 1, "20-10-2050", "22-10-2050")
+
+	//the 10th to the 12th become one block
+	post("1:2050-10-10", "1:2050-10-11", "1:2050-10-12")
+	if free(1, "10-10-2050", "11-10-2050") || free(1, "12-10-2050", "13-10-2050") {
+		t.Error("expected the ticked nights to be blocked")
+	}
+	if !free(1, "13-10-2050", "20-10-2050") {
+		t.Error("expected the nights that were not ticked to stay free")
+	}
+
+	//unticking the 11th splits the block in two
+	post("1:2050-10-10", "1:2050-10-12")
+	if !free(1, "11-10-2050", "12-10-2050") || free(1, "10-10-2050", "11-10-2050") || free(1, "12-10-2050", "13-10-2050") {
+		t.Error("expected only the unticked night to be freed")
+	}
+
+	//nights taken by a reservation cannot be blocked and are left alone
+	post("1:2050-10-10", "1:2050-10-12", "1:2050-10-20")
+	restrictions, err := Repo.DB.RoomRestrictionsForRoom(ctx, 1, time.Date(2050, 10, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := 0
+	for _, rr := range restrictions {
+		if rr.RestrictionID == models.RestrictionOwnerBlock {
+			blocks++
+		} else if rr.ReservationID != reservation.ID {
+			t.Errorf("expected nothing but the blocks and the reservation but got %+v", rr)
+		}
+	}
+	if blocks != 2 {
+		t.Errorf("expected two blocks but got %d", blocks)
+	}
+
+	//a block over a room that was booked in the meantime changes nothing at all
+	err = Repo.DB.UpdateOwnerBlocks(ctx, []models.OwnerBlockChange{
+		{RoomID: 1, Night: time.Date(2050, 10, 11, 0, 0, 0, 0, time.UTC), Block: true},
+		{RoomID: 1, Night: time.Date(2050, 10, 21, 0, 0, 0, 0, time.UTC), Block: true},
+	})
+	var conflict *repository.ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("expected a conflict but got %v", err)
+	}
+	if !free(1, "11-10-2050", "12-10-2050") {
+		t.Error("expected the other night not to be blocked either")
+	}
+
+	//unticking everything frees the month
+	post()
+	if !free(1, "01-10-2050", "20-10-2050") {
+		t.Error("expected every block to be gone")
+	}
+}
+
+func TestRepository_StayRules(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+	ctx := context.Background()
+
+	post := func(path string, data url.Values) string {
+		resp, err := ts.Client().PostForm(ts.URL+path, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body := new(strings.Builder)
+		io.Copy(body, resp.Body)
+		return body.String()
+	}
+	reserve := func(roomID int, start, end string) string {
+		postedData := url.Values{}
+		postedData.Add("first_name", "Name")
+		postedData.Add("last_name", "Surname")
+		postedData.Add("email", "email@mail.com")
+		postedData.Add("start_date", start)
+		postedData.Add("end_date", end)
+		postedData.Add("room_id", strconv.Itoa(roomID))
+		addCard(postedData, payments.CardApproved)
+		req, _ := http.NewRequest("POST", "/reservation", strings.NewReader(postedData.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+		return rr.Body.String()
+	}
+
+	//room 2 needs two nights over november weekends and nobody can arrive in room 1 on saturday the 12th
+	post("/admin/properties/fort-smythe/stay-rules", url.Values{
+		"room_id":    {"2"},
+		"name":       {"Weekends"},
+		"start_date": {"01-11-2050"},
+		"end_date":   {"01-12-2050"},
+		"weekdays":   {strconv.Itoa(int(time.Friday)), strconv.Itoa(int(time.Saturday))},
+		"min_nights": {"2"},
+	})
+	post("/admin/properties/fort-smythe/stay-rules", url.Values{
+		"room_id":           {"1"},
+		"start_date":        {"12-11-2050"},
+		"end_date":          {"13-11-2050"},
+		"closed_to_arrival": {"1"},
+	})
+	rules, err := Repo.DB.StayRulesForProperty(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("expected the two rules to be saved but got %d", len(rules))
+	}
+
+	body := post("/search-availability", url.Values{"start": {"11-11-2050"}, "end": {"12-11-2050"}})
+	if !strings.Contains(body, "/choose-room/1") || strings.Contains(body, "/choose-room/2") {
+		t.Error("expected a friday night alone to leave out only room 2")
+	}
+	if body := post("/search-availability", url.Values{"start": {"12-11-2050"}, "end": {"13-11-2050"}}); strings.Contains(body, "/choose-room/") {
+		t.Error("expected no rooms when both turn the stay down")
+	}
+
+	body = post("/search-availability-json", url.Values{"start": {"11-11-2050"}, "end": {"12-11-2050"}, "room_id": {"2"}})
+	var resp JsonResponse
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Ok || !strings.Contains(resp.Message, "at least 2 nights") {
+		t.Errorf("expected the minimum stay in the response but got %+v", resp)
+	}
+
+	if body := reserve(2, "11-11-2050", "12-11-2050"); !strings.Contains(body, "must be at least 2 nights") {
+		t.Error("expected the form again with the minimum stay on the departure date")
+	}
+	if body := reserve(1, "12-11-2050", "14-11-2050"); !strings.Contains(body, "Arrivals aren&#39;t possible on Saturday 12-11-2050") {
+		t.Error("expected the form again with the closed arrival on the arrival date")
+	}
+
+	//a stay the rules turn down is not held while the form is filled in
+	start, _ := time.Parse(dateLayout, "12-11-2050")
+	end, _ := time.Parse(dateLayout, "14-11-2050")
+	req, _ := http.NewRequest("GET", "/reservation", nil)
+	req = req.WithContext(getCtx(req))
+	session.Put(req.Context(), "reservation", models.Reservation{RoomID: 1, StartDate: start, EndDate: end})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.Reservation).ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther || session.Get(req.Context(), "hold") != nil {
+		t.Errorf("expected a redirect without a hold but got %d", rr.Code)
+	}
+	if free, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(ctx, start, end, 1); !free {
+		t.Error("expected room 1 to be left free")
+	}
+	bookTestReservation(t, 2, "11-11-2050", "13-11-2050")
+
+	post(fmt.Sprintf("/admin/properties/fort-smythe/stay-rules/%d/delete", rules[0].ID), url.Values{})
+	if rules, _ := Repo.DB.StayRulesForProperty(ctx, 1); len(rules) != 1 {
+		t.Errorf("expected one rule left but got %d", len(rules))
+	}
+	bookTestReservation(t, 1, "12-11-2050", "14-11-2050")
+}
+
+func TestRepository_GroupBooking(t *testing.T) {
+	getRoutes()
+	sent := Repo.Mailer.(*mailer.Memory)
+	start := time.Date(2050, 12, 5, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 2)
+
+	post := func(ctx context.Context, handler http.HandlerFunc, path string, postedData url.Values) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, strings.NewReader(postedData.Encode()))
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	free := func(roomID int, start, end time.Time) bool {
+		ok, err := Repo.DB.SearchAvailabilityByDatesByRoomID(context.Background(), start, end, roomID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	guest := func(guests string) url.Values {
+		postedData := url.Values{}
+		postedData.Add("first_name", "Lead")
+		postedData.Add("last_name", "Guest")
+		postedData.Add("email", "lead@mail.com")
+		postedData.Add("guests_1", guests)
+		postedData.Add("guests_2", "2")
+		addCard(postedData, payments.CardApproved)
+		return postedData
+	}
+
+	//the rooms ticked in the search results make up the booking
+	req, _ := http.NewRequest("POST", "/choose-rooms", nil)
+	ctx := getCtx(req)
+	session.Put(ctx, "reservation", models.Reservation{StartDate: start, EndDate: end})
+	rr := post(ctx, Repo.PostChooseRooms, "/choose-rooms", url.Values{"room_id": {"1", "2", "2"}})
+	if loc := rr.Header().Get("Location"); loc != "/group-reservation" {
+		t.Fatalf("expected a redirect to the group booking form but got %q", loc)
+	}
+	if booking, _ := session.Get(ctx, "booking").(models.Booking); len(booking.Reservations) != 2 {
+		t.Fatalf("expected two rooms in the booking but got %+v", booking)
+	}
+
+	get := func(handler http.HandlerFunc, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := get(Repo.GroupReservation, "/group-reservation"); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Major&#39;s Suite") {
+		t.Errorf("expected the form with both rooms but got %d", rr.Code)
+	}
+
+	rr = post(ctx, Repo.PostGroupReservation, "/group-reservation", guest("9"))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "This room sleeps at most 3 guests") {
+		t.Errorf("expected the form again with a guests error but got %d", rr.Code)
+	}
+
+	rr = post(ctx, Repo.PostGroupReservation, "/group-reservation", guest("3"))
+	if loc := rr.Header().Get("Location"); loc != "/booking-summary" {
+		t.Fatalf("expected a redirect to the booking summary but got %q", loc)
+	}
+	booking, _ := session.Get(ctx, "booking").(models.Booking)
+	if booking.ID == 0 || booking.Code == "" || len(booking.Reservations[0].Payments) != 1 || len(booking.Reservations[1].Payments) != 1 {
+		t.Fatalf("expected the booking with a deposit for each room in the session but got %+v", booking)
+	}
+	if booking.TotalPrice != booking.Reservations[0].TotalPrice+booking.Reservations[1].TotalPrice {
+		t.Errorf("expected the booking's total to add up the rooms' but got %d", booking.TotalPrice)
+	}
+	for _, res := range booking.Reservations {
+		stored, err := Repo.DB.GetReservationByCode(context.Background(), res.Code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.BookingID != booking.ID || stored.LastName != "Guest" || free(res.RoomID, start, end) {
+			t.Errorf("expected room %d booked for the lead guest in the booking but got %+v", res.RoomID, stored)
+		}
+	}
+	if rr := get(Repo.BookingSummary, "/booking-summary"); !strings.Contains(rr.Body.String(), booking.Code) {
+		t.Error("expected the confirmation code on the summary")
+	}
+	last := sent.Sent()[len(sent.Sent())-1]
+	if last.To != "lead@mail.com" || !strings.Contains(last.Body, booking.Code) || !strings.Contains(last.Body, booking.Reservations[1].Code) {
+		t.Errorf("expected one confirmation listing every room but sent %+v", last)
+	}
+
+	//cancelling one room settles its own deposit and leaves the other room booked with its deposit
+	cancelled, _ := Repo.DB.GetReservationByCode(context.Background(), booking.Reservations[0].Code)
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/admin/reservations/%d/cancel", cancelled.ID), nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", strconv.Itoa(cancelled.ID))
+	req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostAdminCancelReservation).ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected %d after cancelling one room but got %d", http.StatusSeeOther, rr.Code)
+	}
+	for i, want := range []payments.Status{payments.StatusVoided, payments.StatusAuthorized} {
+		res, _ := Repo.DB.GetReservationByCode(context.Background(), booking.Reservations[i].Code)
+		stored, _ := Repo.DB.PaymentsForReservation(context.Background(), res.ID)
+		if len(stored) != 1 || stored[0].Status != string(want) || stored[0].BookingID != booking.ID {
+			t.Errorf("expected the deposit of room %d to be %s but got %+v", res.RoomID, want, stored)
+		}
+	}
+	if !free(booking.Reservations[0].RoomID, start, end) || free(booking.Reservations[1].RoomID, start, end) {
+		t.Error("expected only the cancelled room to be freed")
+	}
+
+	//when one room is taken nothing is booked
+	start = start.AddDate(0, 0, 7)
+	end = end.AddDate(0, 0, 7)
+	bookTestReservation(t, 1, start.Format(dateLayout), end.Format(dateLayout))
+	_, err := Repo.DB.InsertBooking(context.Background(), models.Booking{
+		Code:  "GROUPCONFLICT",
+		Email: "lead@mail.com",
+		Reservations: []models.Reservation{
+			{Code: "GROUPROOM2", RoomID: 2, StartDate: start, EndDate: end, Guests: 1},
+			{Code: "GROUPROOM1", RoomID: 1, StartDate: start, EndDate: end, Guests: 1},
+		},
+	})
+	var conflict *repository.ConflictError
+	if !errors.As(err, &conflict) || conflict.RoomID != 1 {
+		t.Fatalf("expected a conflict for room 1 but got %v", err)
+	}
+	if !free(2, start, end) {
+		t.Error("expected room 2 to stay free when room 1 was taken")
+	}
+
+	session.Put(ctx, "booking", models.Booking{Reservations: []models.Reservation{
+		{RoomID: 1, StartDate: start, EndDate: end, Guests: 1},
+		{RoomID: 2, StartDate: start, EndDate: end, Guests: 1},
+	}})
+	rr = post(ctx, Repo.PostGroupReservation, "/group-reservation", guest("1"))
+	if loc := rr.Header().Get("Location"); loc != "/search-availability" || !free(2, start, end) {
+		t.Errorf("expected nothing booked and a redirect to search again but got %q", loc)
+	}
+}
+
+func TestRepository_GuestProfiles(t *testing.T) {
+	getRoutes()
+	ctx := context.Background()
+
+	book := func(email string, day int) models.Reservation {
+		start := time.Date(2051, 1, day, 0, 0, 0, 0, time.UTC)
+		id, err := Repo.DB.InsertReservation(ctx, models.Reservation{
+			FirstName: "Jane",
+			LastName:  "Doe",
+			Email:     email,
+			StartDate: start,
+			EndDate:   start.AddDate(0, 0, 1),
+			RoomID:    1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := Repo.DB.GetReservationByID(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	//the same email written differently is the same guest
+	first := book("Jane.Doe@Mail.com ", 3)
+	second := book("jane.doe@mail.com", 5)
+	other := book("jd@work.example", 7)
+	if first.GuestID == 0 || first.GuestID != second.GuestID {
+		t.Fatalf("expected both reservations on one profile but got %d and %d", first.GuestID, second.GuestID)
+	}
+	if other.GuestID == first.GuestID {
+		t.Fatal("expected another email to get its own profile")
+	}
+
+	//search finds the text as written, characters a like pattern would treat as wildcards included
+	for search, expected := range map[string]int{"jane.doe@": 1, "DOE": 2, "_": 0, "%": 0} {
+		if guests, _ := Repo.DB.AllGuests(ctx, search); len(guests) != expected {
+			t.Errorf("search %q: expected %d guests but got %d", search, expected, len(guests))
+		}
+	}
+
+	guest, err := Repo.DB.GetGuest(ctx, first.GuestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if guest.Email != "jane.doe@mail.com" || guest.Stays != 2 || len(guest.Reservations) != 2 {
+		t.Errorf("expected the normalised email and two stays but got %+v", guest)
+	}
+
+	err = Repo.DB.UpdateGuest(ctx, models.Guest{ID: other.GuestID, FirstName: "Jane", LastName: "Doe", Notes: "Prefers a quiet room"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	postedData := url.Values{
+		"keep":  {strconv.Itoa(first.GuestID)},
+		"merge": {strconv.Itoa(first.GuestID), strconv.Itoa(other.GuestID)},
+	}
+	req, _ := http.NewRequest("POST", "/admin/guests/merge", strings.NewReader(postedData.Encode()))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostAdminMergeGuests).ServeHTTP(rr, req)
+	if loc := rr.Header().Get("Location"); loc != fmt.Sprintf("/admin/guests/%d", first.GuestID) {
+		t.Fatalf("expected a redirect to the profile kept but got %q", loc)
+	}
+
+	if _, err := Repo.DB.GetGuest(ctx, other.GuestID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected the merged profile to be gone but got %v", err)
+	}
+	guest, err = Repo.DB.GetGuest(ctx, first.GuestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if guest.Stays != 3 || len(guest.Emails) != 2 || guest.Notes != "Prefers a quiet room" {
+		t.Errorf("expected the stays, emails and notes of both profiles but got %+v", guest)
+	}
+
+	//a later booking with the merged email goes to the profile kept
+	if res := book("JD@work.example", 9); res.GuestID != first.GuestID {
+		t.Errorf("expected the merged email to find the profile kept but got %d", res.GuestID)
+	}
+}
+
+func TestRepository_PostShowLogin(t *testing.T) {
+	getRoutes()
+
+	var tests = []struct {
+		name     string
+		email    string
+		password string
+		location string
+		userID   int
+	}{
+		{"valid", "Admin@Example.com", "password", "/admin/properties", 1},
+		{"housekeeping", "housekeeping@example.com", "password", "/admin/properties/fort-smythe/calendar", 4},
+		{"wrong password", "admin@example.com", "wrong", "/user/login", 0},
+		{"unknown user", "nobody@example.com", "password", "/user/login", 0},
+	}
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("email", e.email)
+		postedData.Add("password", e.password)
+		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostShowLogin).ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != e.location {
+			t.Errorf("%s: expected a redirect to %s but got %q", e.name, e.location, loc)
+		}
+		if id := session.GetInt(req.Context(), "user_id"); id != e.userID {
+			t.Errorf("%s: expected user %d in the session but got %d", e.name, e.userID, id)
+		}
+	}
+}
+
+func TestRepository_Roles(t *testing.T) {
+	routes := getRoutes()
+	reservation := bookTestReservation(t, 1, "03-02-2051", "05-02-2051")
+	cancelURL := fmt.Sprintf("/admin/reservations/%d/cancel", reservation.ID)
+	calendarURL := "/admin/properties/fort-smythe/calendar?month=2051-02"
+
+	var tests = []struct {
+		name               string
+		userID             int
+		method             string
+		url                string
+		expectedStatusCode int
+	}{
+		{"housekeeping sees room status", 4, "GET", calendarURL, http.StatusOK},
+		{"housekeeping cannot see payments", 4, "GET", cancelURL, http.StatusForbidden},
+		{"housekeeping cannot block nights", 4, "POST", calendarURL, http.StatusForbidden},
+		{"housekeeping cannot see guests", 4, "GET", "/admin/guests", http.StatusForbidden},
+		{"housekeeping cannot list properties", 4, "GET", "/admin/properties", http.StatusForbidden},
+		{"front desk cannot list properties", 3, "GET", "/admin/properties", http.StatusForbidden},
+		{"manager lists properties", 2, "GET", "/admin/properties", http.StatusOK},
+		{"front desk sees payments", 3, "GET", cancelURL, http.StatusOK},
+		{"front desk sees guests", 3, "GET", "/admin/guests", http.StatusOK},
+		{"front desk cannot merge guests", 3, "POST", "/admin/guests/merge", http.StatusForbidden},
+		{"front desk cannot change settings", 3, "GET", "/admin/properties/fort-smythe/taxes", http.StatusForbidden},
+		{"manager changes settings", 2, "GET", "/admin/properties/fort-smythe/taxes", http.StatusOK},
+		{"manager cannot add properties", 2, "POST", "/admin/properties", http.StatusForbidden},
+		{"removed user is logged out", 99, "GET", "/admin/properties", http.StatusSeeOther},
+	}
+
+	request := func(userID int, method, url string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, nil)
+		ctx := getCtx(req)
+		session.Put(ctx, "user_id", userID)
+		session.Put(ctx, "session_version", 1)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req.WithContext(ctx))
+		return rr
+	}
+	for _, e := range tests {
+		if rr := request(e.userID, e.method, e.url); rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+
+	//housekeeping sees which nights are taken, without links to what the guest paid or the boxes to block nights
+	body := request(4, "GET", calendarURL).Body.String()
+	if strings.Contains(body, cancelURL) || strings.Contains(body, "Save blocks") {
+		t.Error("expected the calendar without payment links or blocking for housekeeping")
+	}
+	body = request(1, "GET", calendarURL).Body.String()
+	if !strings.Contains(body, cancelURL) || !strings.Contains(body, "Save blocks") {
+		t.Error("expected the calendar with payment links and blocking for the owner")
+	}
+}
+
+func TestRepository_ResetPassword(t *testing.T) {
+	routes := getRoutes()
+	sent := Repo.Mailer.(*mailer.Memory)
+
+	request := func(ctx context.Context, method, path string, postedData url.Values) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req.WithContext(ctx))
+		return rr
+	}
+	newCtx := func() context.Context {
+		req, _ := http.NewRequest("GET", "/", nil)
+		return getCtx(req)
+	}
+
+	//a session logged in before the reset
+	other := newCtx()
+	request(other, "POST", "/user/login", url.Values{"email": {"manager@example.com"}, "password": {"password"}})
+	if rr := request(other, "GET", "/admin/guests", nil); rr.Code != http.StatusOK {
+		t.Fatalf("expected the other session to be logged in but got %d", rr.Code)
+	}
+
+	ctx := newCtx()
+	before := len(sent.Sent())
+	request(ctx, "POST", "/user/forgot-password", url.Values{"email": {"Manager@Example.com"}})
+	if len(sent.Sent()) != before+1 {
+		t.Fatal("expected a reset link to be emailed")
+	}
+	msg := sent.Sent()[before]
+	i := strings.Index(msg.Body, "/user/reset-password/")
+	if msg.To != "manager@example.com" || i == -1 {
+		t.Fatalf("expected a reset link to the manager but got %+v", msg)
+	}
+	link := strings.Fields(msg.Body[i:])[0]
+
+	if rr := request(ctx, "GET", link, nil); rr.Code != http.StatusOK {
+		t.Errorf("expected the reset form but got %d", rr.Code)
+	}
+	rr := request(ctx, "POST", link, url.Values{"password": {"weakpassword"}, "confirm_password": {"weakpassword"}})
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "at least one digit or symbol") {
+		t.Errorf("expected the form again for a weak password but got %d", rr.Code)
+	}
+	rr = request(ctx, "POST", link, url.Values{"password": {"new password 2"}, "confirm_password": {"new password 2"}})
+	if loc := rr.Header().Get("Location"); loc != "/admin/properties" {
+		t.Fatalf("expected to be logged in after the reset but got %q", loc)
+	}
+
+	//the link works once, the new password logs in and the other session is logged out
+	if rr := request(newCtx(), "GET", link, nil); rr.Header().Get("Location") != "/user/forgot-password" {
+		t.Errorf("expected a used link to have expired but got %d", rr.Code)
+	}
+	if _, err := Repo.DB.Authenticate(context.Background(), "manager@example.com", "new password 2"); err != nil {
+		t.Errorf("expected the new password to log in but got %v", err)
+	}
+	if rr := request(ctx, "GET", "/admin/guests", nil); rr.Code != http.StatusOK {
+		t.Errorf("expected the session that reset the password to stay logged in but got %d", rr.Code)
+	}
+	if rr := request(other, "GET", "/admin/guests", nil); rr.Header().Get("Location") != "/user/login" {
+		t.Errorf("expected the other session to be logged out but got %d", rr.Code)
+	}
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redblue-blur/bookings/internal/forms"
+	"github.com/redblue-blur/bookings/internal/helpers"
+	"github.com/redblue-blur/bookings/internal/models"
+	"github.com/redblue-blur/bookings/internal/render"
+	"github.com/redblue-blur/bookings/internal/repository"
+)
+
+//slugPattern is what a property slug may look like, it ends up in urls
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+//propertyPrefix is the path a property's public site is served under
+func propertyPrefix(slug string) string {
+	return "/p/" + slug
+}
+
+//PropertyFromHost serves the site of the property claiming the request's host, or the default property's
+func (m *Repository) PropertyFromHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+
+		property, err := m.DB.GetPropertyByHostname(r.Context(), host)
+		if errors.Is(err, repository.ErrNotFound) {
+			property, err = m.DB.GetPropertyBySlug(r.Context(), m.App.DefaultProperty)
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		ctx := helpers.WithSite(r.Context(), helpers.Site{Property: property})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+//PropertyFromPath serves the site of the property named by the {property} slug in the url
+func (m *Repository) PropertyFromPath(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		property, err := m.DB.GetPropertyBySlug(r.Context(), chi.URLParam(r, "property"))
+		if errors.Is(err, repository.ErrNotFound) {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		ctx := helpers.WithSite(r.Context(), helpers.Site{
+			Property: property,
+			Prefix:   propertyPrefix(property.Slug),
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+//AdminProperties lists the properties an admin manages with the form to add one
+func (m *Repository) AdminProperties(w http.ResponseWriter, r *http.Request) {
+	m.renderAdminProperties(w, r, forms.New(nil))
+}
+
+//PostAdminProperties adds a property, its other settings are filled in on its own page
+func (m *Repository) PostAdminProperties(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "slug")
+
+	property := models.Property{
+		Name:          strings.TrimSpace(form.Get("name")),
+		Slug:          strings.TrimSpace(form.Get("slug")),
+		TimeZone:      "UTC",
+		CheckInTime:   "15:00",
+		CheckOutTime:  "11:00",
+		CalendarNames: models.CalendarNamesHidden,
+	}
+	if err := m.checkProperty(r, form, property); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if !form.Valid() {
+		m.renderAdminProperties(w, r, form)
+		return
+	}
+
+	_, err = m.DB.InsertProperty(r.Context(), property)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Property added")
+	http.Redirect(w, r, "/admin/properties/"+property.Slug, http.StatusSeeOther)
+}
+
+//AdminProperty shows the settings of the property in the url
+func (m *Repository) AdminProperty(w http.ResponseWriter, r *http.Request) {
+	property := helpers.SiteFrom(r.Context()).Property
+	m.renderAdminProperty(w, r, forms.New(propertyValues(property)))
+}
+
+//PostAdminProperty saves the settings of the property in the url
+func (m *Repository) PostAdminProperty(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "time_zone", "check_in_time", "check_out_time")
+
+	property := helpers.SiteFrom(r.Context()).Property
+	property.Name = strings.TrimSpace(form.Get("name"))
+	property.Hostname = strings.ToLower(strings.TrimSpace(form.Get("hostname")))
+	property.Email = strings.TrimSpace(form.Get("email"))
+	property.Phone = strings.TrimSpace(form.Get("phone"))
+	property.Address = strings.TrimSpace(form.Get("address"))
+	property.TimeZone = strings.TrimSpace(form.Get("time_zone"))
+	property.CheckInTime = strings.TrimSpace(form.Get("check_in_time"))
+	property.CheckOutTime = strings.TrimSpace(form.Get("check_out_time"))
+	property.CalendarNames = form.Get("calendar_names")
+	if property.Email != "" {
+		form.IsEmail("email")
+	}
+	if _, err := time.LoadLocation(property.TimeZone); property.TimeZone != "" && err != nil {
+		form.Errors.Add("time_zone", "Unknown time zone")
+	}
+	for field, value := range map[string]string{
+		"check_in_time":  property.CheckInTime,
+		"check_out_time": property.CheckOutTime,
+	} {
+		if _, err := time.Parse(models.ClockLayout, value); value != "" && err != nil {
+			form.Errors.Add(field, "Use HH:MM")
+		}
+	}
+	if property.CalendarNames != models.CalendarNamesHidden && property.CalendarNames != models.CalendarNamesInitials {
+		form.Errors.Add("calendar_names", "Choose how guest names show")
+	}
+	if err := m.checkProperty(r, form, property); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if !form.Valid() {
+		m.renderAdminProperty(w, r, form)
+		return
+	}
+
+	err = m.DB.UpdateProperty(r.Context(), property)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Settings saved")
+	http.Redirect(w, r, "/admin/properties/"+property.Slug, http.StatusSeeOther)
+}
+
+//checkProperty adds form errors for a slug or hostname that is malformed or taken by another property
+func (m *Repository) checkProperty(r *http.Request, form *forms.Form, property models.Property) error {
+	if property.Slug != "" && !slugPattern.MatchString(property.Slug) {
+		form.Errors.Add("slug", "Use lowercase letters, numbers and dashes")
+	}
+
+	other, err := m.DB.GetPropertyBySlug(r.Context(), property.Slug)
+	if err == nil && other.ID != property.ID {
+		form.Errors.Add("slug", "This slug is already in use")
+	} else if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+
+	if property.Hostname == "" {
+		return nil
+	}
+	other, err = m.DB.GetPropertyByHostname(r.Context(), property.Hostname)
+	if err == nil && other.ID != property.ID {
+		form.Errors.Add("hostname", "This hostname is already used by "+other.Name)
+	} else if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+//propertyValues fills the settings form with a property's current settings
+func propertyValues(property models.Property) url.Values {
+	return url.Values{
+		"name":           {property.Name},
+		"hostname":       {property.Hostname},
+		"email":          {property.Email},
+		"phone":          {property.Phone},
+		"address":        {property.Address},
+		"time_zone":      {property.TimeZone},
+		"check_in_time":  {property.CheckInTime},
+		"check_out_time": {property.CheckOutTime},
+		"calendar_names": {property.CalendarNames},
+	}
+}
+
+//renderAdminProperties shows the list of properties with the form for a new one
+func (m *Repository) renderAdminProperties(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	properties, err := m.DB.AllProperties(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["properties"] = properties
+
+	render.Template(w, r, "admin-properties.page.html", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+//renderAdminProperty shows a property's settings form and the calendars its rooms export and import
+func (m *Repository) renderAdminProperty(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	property := helpers.SiteFrom(r.Context()).Property
+	rooms, err := m.DB.AllRooms(r.Context(), property.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	calendars := make(map[int]string)
+	for _, room := range rooms {
+		calendars[room.ID] = m.roomCalendarURL(property, room)
+	}
+
+	imported, err := m.DB.RoomCalendarsForProperty(r.Context(), property.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	data["calendars"] = calendars
+	data["imported"] = imported
+
+	render.Template(w, r, "admin-property.page.html", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
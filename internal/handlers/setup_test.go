@@ -11,15 +11,21 @@ import (
 	"time"
 
 	"github.com/alexedwards/scs/v2"
-	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/justinas/nosurf"
 	"github.com/redblue-blur/bookings/internal/config"
+	"github.com/redblue-blur/bookings/internal/helpers"
+	"github.com/redblue-blur/bookings/internal/mailer"
 	"github.com/redblue-blur/bookings/internal/models"
 	"github.com/redblue-blur/bookings/internal/render"
 )
 
-var functions = template.FuncMap{}
+var functions = template.FuncMap{
+	"humanDate":   render.HumanDate,
+	"formatMoney": render.FormatMoney,
+	"can":         render.Can,
+}
 var app config.AppConfig
 var session *scs.SessionManager
 var pathToTemplates = "./../../templates"
@@ -27,8 +33,16 @@ var pathToTemplates = "./../../templates"
 func getRoutes() http.Handler {
 	//what am i going to put in the session
 	gob.Register(models.Reservation{})
+	gob.Register(models.Booking{})
+	gob.Register(models.RoomRestriction{})
 	//true if in Production
 	app.InProduction = false
+	app.HoldDuration = 15 * time.Minute
+	app.DepositPercent = 20
+	app.WaitlistClaimDuration = 24 * time.Hour
+	app.PasswordResetDuration = time.Hour
+	app.BaseURL = "http://localhost:8080"
+	app.DefaultProperty = "fort-smythe"
 
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.InfoLog = infoLog
@@ -51,9 +65,11 @@ func getRoutes() http.Handler {
 	app.TemplateCache = tc
 	app.UseCache = true
 
-	repo := NewRepo(&app)
+	repo := NewRepo(&app, nil)
+	repo.Mailer = &mailer.Memory{}
 	NewHandlers(repo)
 	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 
 	mux := chi.NewRouter()
 
@@ -61,23 +77,97 @@ func getRoutes() http.Handler {
 	//mux.Use(NoSurf)
 	mux.Use(SessionLoad)
 
+	mux.Handle("/generals-quaters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently))
+	mux.Handle("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently))
+
+	mux.Get("/user/login", Repo.ShowLogin)
+	mux.Post("/user/login", Repo.PostShowLogin)
+	mux.Post("/user/logout", Repo.Logout)
+	mux.Get("/user/forgot-password", Repo.ForgotPassword)
+	mux.Post("/user/forgot-password", Repo.PostForgotPassword)
+	mux.Get("/user/reset-password/{token}", Repo.ResetPassword)
+	mux.Post("/user/reset-password/{token}", Repo.PostResetPassword)
+
+	mux.Route("/admin", func(mux chi.Router) {
+		//mux.Use(Auth)
+		mux.Use(testUser)
+		mux.Use(Repo.LoadUser)
+		mux.With(Repo.Require(models.PermManageProperty)).Get("/properties", Repo.AdminProperties)
+		mux.With(Repo.Require(models.PermAddProperties)).Post("/properties", Repo.PostAdminProperties)
+		mux.Route("/properties/{property}", func(mux chi.Router) {
+			mux.Use(Repo.PropertyFromPath)
+			mux.With(Repo.Require(models.PermViewRoomStatus)).Get("/calendar", Repo.AdminReservationCalendar)
+			mux.With(Repo.Require(models.PermManageReservations)).Post("/calendar", Repo.PostAdminReservationCalendar)
+			mux.Group(func(mux chi.Router) {
+				mux.Use(Repo.Require(models.PermManageProperty))
+				mux.Get("/", Repo.AdminProperty)
+				mux.Post("/", Repo.PostAdminProperty)
+				mux.Get("/taxes", Repo.AdminTaxes)
+				mux.Post("/taxes", Repo.PostAdminTaxes)
+				mux.Get("/stay-rules", Repo.AdminStayRules)
+				mux.Post("/stay-rules", Repo.PostAdminStayRules)
+				mux.Post("/stay-rules/{id}/delete", Repo.PostAdminDeleteStayRule)
+				mux.Post("/rooms/{id}/calendar", Repo.PostAdminRoomCalendar)
+				mux.Post("/calendars", Repo.PostAdminRoomCalendars)
+				mux.Post("/calendars/{id}/sync", Repo.PostAdminSyncRoomCalendar)
+				mux.Post("/calendars/{id}/delete", Repo.PostAdminDeleteRoomCalendar)
+			})
+		})
+		//the cancellation page shows what the guest paid and gets back
+		mux.With(Repo.Require(models.PermViewPayments)).Get("/reservations/{id}/cancel", Repo.AdminCancelReservation)
+		mux.With(Repo.Require(models.PermManageReservations)).Post("/reservations/{id}/cancel", Repo.PostAdminCancelReservation)
+		mux.Group(func(mux chi.Router) {
+			mux.Use(Repo.Require(models.PermViewGuests))
+			mux.Get("/guests", Repo.AdminGuests)
+			mux.With(Repo.Require(models.PermMergeGuests)).Post("/guests/merge", Repo.PostAdminMergeGuests)
+			mux.Get("/guests/{id}", Repo.AdminGuest)
+			mux.Post("/guests/{id}", Repo.PostAdminGuest)
+		})
+	})
+
+	fileServer := http.FileServer(http.Dir("./static/"))
+	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
+
+	//every property's site is served under /p/<slug>, and at the root for the property claiming the host
+	mux.Route("/p/{property}", func(mux chi.Router) {
+		mux.Use(Repo.PropertyFromPath)
+		siteRoutes(mux)
+	})
+	mux.Group(func(mux chi.Router) {
+		mux.Use(Repo.PropertyFromHost)
+		siteRoutes(mux)
+	})
+
+	return mux
+}
+
+//siteRoutes are the public pages of a property's site
+func siteRoutes(mux chi.Router) {
 	mux.Get("/", Repo.Home)
 	mux.Get("/about", Repo.About)
-	mux.Get("/generals-quaters", Repo.Generals)
-	mux.Get("/majors-suite", Repo.Majors)
+	mux.Get("/rooms", Repo.Rooms)
+	mux.Get("/rooms/{slug}", Repo.Room)
+	mux.Get("/rooms/{id}/calendar.ics", Repo.RoomCalendar)
 
 	mux.Get("/search-availability", Repo.Availability)
 	mux.Post("/search-availability", Repo.PostAvailability)
 	mux.Post("/search-availability-json", Repo.AvailabilityJSON)
+	mux.Get("/choose-room/{id}", Repo.ChooseRoom)
+	mux.Post("/choose-rooms", Repo.PostChooseRooms)
+	mux.Get("/book-room", Repo.BookRoom)
+	mux.Get("/waitlist", Repo.Waitlist)
+	mux.Post("/waitlist", Repo.PostWaitlist)
+	mux.Get("/waitlist/claim/{token}", Repo.ClaimWaitlist)
 
 	mux.Get("/contact", Repo.Contact)
 	mux.Get("/reservation", Repo.Reservation)
 	mux.Post("/reservation", Repo.PostReservation)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
-
-	fileServer := http.FileServer(http.Dir("./static/"))
-	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
-	return mux
+	mux.Get("/group-reservation", Repo.GroupReservation)
+	mux.Post("/group-reservation", Repo.PostGroupReservation)
+	mux.Get("/booking-summary", Repo.BookingSummary)
+	mux.Get("/reservations/{code}/cancel", Repo.CancelReservation)
+	mux.Post("/reservations/{code}/cancel", Repo.PostCancelReservation)
 }
 
 //NoSurf adds CSRF protection to all requests
@@ -97,6 +187,18 @@ func NoSurf(next http.Handler) http.Handler {
 func SessionLoad(next http.Handler) http.Handler {
 	return session.LoadAndSave(next)
 }
+
+//testUser stands in for logging in, requests without a user in the session are made as the seeded owner
+func testUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !session.Exists(r.Context(), "user_id") {
+			session.Put(r.Context(), "user_id", 1)
+			session.Put(r.Context(), "session_version", 1)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func CreateTestTemplateCache() (map[string]*template.Template, error) {
 
 	myCache := map[string]*template.Template{}
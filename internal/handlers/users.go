@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redblue-blur/bookings/internal/forms"
+	"github.com/redblue-blur/bookings/internal/helpers"
+	"github.com/redblue-blur/bookings/internal/mailer"
+	"github.com/redblue-blur/bookings/internal/models"
+	"github.com/redblue-blur/bookings/internal/render"
+	"github.com/redblue-blur/bookings/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//ShowLogin shows the login page
+func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "login.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+//PostShowLogin logs a user in and sends them to the admin pages
+func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
+	//a new token on login keeps a session id set before it from being used to act as the user
+	err := m.App.Session.RenewToken(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email", "password")
+	form.IsEmail("email")
+	if !form.Valid() {
+		render.Template(w, r, "login.page.html", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	id, err := m.DB.Authenticate(r.Context(), form.Get("email"), form.Get("password"))
+	if errors.Is(err, repository.ErrInvalidCredentials) {
+		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	user, err := m.DB.GetUserByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.logIn(r, user)
+	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+	http.Redirect(w, r, m.adminHome(user), http.StatusSeeOther)
+}
+
+//Logout logs the user out, it is posted with the CSRF token so another site cannot log the user out
+func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
+	err := m.App.Session.Destroy(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	err = m.App.Session.RenewToken(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+//ForgotPassword shows the form to ask for a link to reset a password
+func (m *Repository) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "forgot-password.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+//PostForgotPassword emails a link to reset the password to the user with the email, the answer is the same
+//whether there is one or not so the form cannot be used to find out who has an account
+func (m *Repository) PostForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.IsEmail("email")
+	if !form.Valid() {
+		render.Template(w, r, "forgot-password.page.html", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	user, err := m.DB.GetUserByEmail(r.Context(), form.Get("email"))
+	if err == nil {
+		err = m.sendPasswordReset(r, user)
+	}
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "If an account uses that email, a link to reset its password is on its way")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+//ResetPassword shows the form to choose a new password for the user a reset link was sent to
+func (m *Repository) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	if _, ok := m.passwordReset(w, r); ok {
+		m.renderResetPassword(w, r, forms.New(nil))
+	}
+}
+
+//PostResetPassword sets a new password with a reset link, logging out every other session of the user
+func (m *Repository) PostResetPassword(w http.ResponseWriter, r *http.Request) {
+	reset, ok := m.passwordReset(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("password", "confirm_password")
+	if form.IsStrongPassword("password") {
+		form.Matches("confirm_password", "password")
+	}
+	if !form.Valid() {
+		m.renderResetPassword(w, r, form)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(form.Get("password")), bcrypt.DefaultCost)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.ResetPassword(r.Context(), reset.ID, string(hash))
+	if errors.Is(err, repository.ErrResetExpired) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this link has expired. Please ask for a new one.")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	user, err := m.DB.GetUserByID(r.Context(), reset.UserID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	//this session is logged in with the new session version, the others are logged out by LoadUser
+	err = m.App.Session.RenewToken(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.logIn(r, user)
+	m.App.Session.Put(r.Context(), "flash", "Your password has been changed")
+	http.Redirect(w, r, m.adminHome(user), http.StatusSeeOther)
+}
+
+//passwordReset finds the password reset for the token in the url, writing the error response when it cannot
+//or the link can no longer be used
+func (m *Repository) passwordReset(w http.ResponseWriter, r *http.Request) (models.PasswordReset, bool) {
+	reset, err := m.DB.GetPasswordResetByToken(r.Context(), helpers.HashToken(chi.URLParam(r, "token")))
+	if errors.Is(err, repository.ErrNotFound) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return reset, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return reset, false
+	}
+
+	if !reset.UsedAt.IsZero() || !reset.ExpiresAt.After(time.Now()) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this link has expired. Please ask for a new one.")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return reset, false
+	}
+	return reset, true
+}
+
+//sendPasswordReset emails a user a link to reset their password
+func (m *Repository) sendPasswordReset(r *http.Request, user models.User) error {
+	token, hash, err := helpers.NewToken()
+	if err != nil {
+		return err
+	}
+	expires := time.Now().Add(m.App.PasswordResetDuration)
+
+	_, err = m.DB.InsertPasswordReset(r.Context(), user.ID, hash, expires)
+	if err != nil {
+		return err
+	}
+
+	return m.Mailer.Send(r.Context(), mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Dear %s %s,\n\nsomeone asked to reset the password of your account. "+
+			"If it was you, choose a new password within %s using this link:\n%s/user/reset-password/%s\n\n"+
+			"If it was not, you can ignore this email.\n",
+			user.FirstName, user.LastName, m.App.PasswordResetDuration, m.App.BaseURL, token),
+	})
+}
+
+//renderResetPassword shows the form to choose a new password
+func (m *Repository) renderResetPassword(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	stringMap := make(map[string]string)
+	stringMap["token"] = chi.URLParam(r, "token")
+
+	render.Template(w, r, "reset-password.page.html", &models.TemplateData{
+		Form:      form,
+		StringMap: stringMap,
+	})
+}
+
+//logIn stores the user in the session, with the session version that keeps it logged in
+func (m *Repository) logIn(r *http.Request, user models.User) {
+	m.App.Session.Put(r.Context(), "user_id", user.ID)
+	m.App.Session.Put(r.Context(), "session_version", user.SessionVersion)
+}
+
+//adminHome is the admin page a user starts on, the properties list for those who manage them and the reservation
+//calendar of the default property for the others
+func (m *Repository) adminHome(user models.User) string {
+	if user.Can(models.PermManageProperty) {
+		return "/admin/properties"
+	}
+	return "/admin/properties/" + m.App.DefaultProperty + "/calendar"
+}
+
+//LoadUser puts the logged in user in the request context, logging out a session whose user was removed
+func (m *Repository) LoadUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := m.App.Session.GetInt(r.Context(), "user_id")
+		if id == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := m.DB.GetUserByID(r.Context(), id)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			helpers.ServerError(w, err)
+			return
+		}
+		//the session of a removed user, or one logged in before the password was reset, ends
+		if err != nil || m.App.Session.GetInt(r.Context(), "session_version") != user.SessionVersion {
+			m.App.Session.Remove(r.Context(), "user_id")
+			m.App.Session.Remove(r.Context(), "session_version")
+			m.App.Session.Put(r.Context(), "error", "Log in first!")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(helpers.WithUser(r.Context(), user)))
+	})
+}
+
+//Require lets through users whose role has the permission and forbids the page to the others, it goes after
+//LoadUser
+func (m *Repository) Require(p models.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !helpers.UserFrom(r.Context()).Can(p) {
+				helpers.ClientError(w, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
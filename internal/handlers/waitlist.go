@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redblue-blur/bookings/internal/forms"
+	"github.com/redblue-blur/bookings/internal/helpers"
+	"github.com/redblue-blur/bookings/internal/mailer"
+	"github.com/redblue-blur/bookings/internal/models"
+	"github.com/redblue-blur/bookings/internal/render"
+	"github.com/redblue-blur/bookings/internal/repository"
+)
+
+//Waitlist shows the form to join the waitlist for dates with no free room
+func (m *Repository) Waitlist(w http.ResponseWriter, r *http.Request) {
+	values := url.Values{}
+	values.Add("start", r.URL.Query().Get("s"))
+	values.Add("end", r.URL.Query().Get("e"))
+
+	render.Template(w, r, "waitlist.page.html", &models.TemplateData{
+		Form: forms.New(values),
+	})
+}
+
+//PostWaitlist adds the guest to the waitlist
+func (m *Repository) PostWaitlist(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email", "start", "end")
+	form.IsEmail("email")
+
+	entry := models.WaitlistEntry{
+		PropertyID: helpers.SiteFrom(r.Context()).Property.ID,
+		Email:      form.Get("email"),
+	}
+	if form.Get("start") != "" {
+		entry.StartDate, err = time.Parse(dateLayout, form.Get("start"))
+		if err != nil {
+			form.Errors.Add("start", "Invalid date")
+		} else if entry.StartDate.Before(today(r)) {
+			form.Errors.Add("start", "Arrival can't be in the past")
+		}
+	}
+	if form.Get("end") != "" {
+		entry.EndDate, err = time.Parse(dateLayout, form.Get("end"))
+		if err != nil {
+			form.Errors.Add("end", "Invalid date")
+		} else if !entry.EndDate.After(entry.StartDate) {
+			form.Errors.Add("end", "Departure must be after arrival")
+		}
+	}
+
+	if !form.Valid() {
+		render.Template(w, r, "waitlist.page.html", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	_, err = m.DB.InsertWaitlistEntry(r.Context(), entry)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "You are on the waitlist, we will email you if a room frees up")
+	http.Redirect(w, r, helpers.SitePath(r, "/"), http.StatusSeeOther)
+}
+
+//ClaimWaitlist starts the reservation for a guest following the link sent when a room freed up, the link can be
+//opened as often as needed until the claim runs out, the entry is only claimed once the held room is booked
+func (m *Repository) ClaimWaitlist(w http.ResponseWriter, r *http.Request) {
+	entry, err := m.DB.GetWaitlistEntryByToken(r.Context(), helpers.HashToken(chi.URLParam(r, "token")))
+	if err == nil && entry.PropertyID != helpers.SiteFrom(r.Context()).Property.ID {
+		err = repository.ErrNotFound
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if entry.Status == models.WaitlistClaimed {
+		m.App.Session.Put(r.Context(), "error", "This room has already been booked with this link.")
+		http.Redirect(w, r, helpers.SitePath(r, "/"), http.StatusSeeOther)
+		return
+	}
+	if entry.Status != models.WaitlistOffered || !entry.ClaimExpiresAt.After(time.Now()) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this link has expired. Please search again.")
+		http.Redirect(w, r, helpers.SitePath(r, "/search-availability"), http.StatusSeeOther)
+		return
+	}
+
+	//the room has been held for the guest since the offer, the reservation form keeps that hold and booking
+	//converts it, without one the form tries to hold the room again
+	if entry.ClaimHoldID != 0 {
+		m.App.Session.Put(r.Context(), "hold", models.RoomRestriction{
+			ID:            entry.ClaimHoldID,
+			RoomID:        entry.ClaimRoomID,
+			StartDate:     entry.StartDate,
+			EndDate:       entry.EndDate,
+			RestrictionID: models.RestrictionHold,
+			ExpiresAt:     entry.ClaimExpiresAt,
+		})
+	}
+	m.App.Session.Put(r.Context(), "reservation", models.Reservation{
+		Email:     entry.Email,
+		RoomID:    entry.ClaimRoomID,
+		StartDate: entry.StartDate,
+		EndDate:   entry.EndDate,
+	})
+	http.Redirect(w, r, helpers.SitePath(r, "/reservation"), http.StatusSeeOther)
+}
+
+//OfferExpiredClaims releases the rooms held for claim links that ran out without being booked and passes them
+//to the next guests in line
+func (m *Repository) OfferExpiredClaims(ctx context.Context) error {
+	expired, err := m.DB.ExpireWaitlistOffers(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, entry := range expired {
+		if entry.ClaimHoldID != 0 {
+			if err := m.DB.ReleaseHold(ctx, entry.ClaimHoldID); err != nil {
+				return err
+			}
+		}
+		if err := m.offerWaitlist(ctx, entry.ClaimRoomID, entry.StartDate, entry.EndDate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//offerWaitlist holds the room for the first guest in line whose dates it is now free for and emails them a
+//claim link
+func (m *Repository) offerWaitlist(ctx context.Context, roomID int, start, end time.Time) error {
+	entries, err := m.DB.WaitlistEntriesForRoom(ctx, roomID, start, end)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+
+	//the link goes to the property's own site
+	room, err := m.DB.GetRoomByID(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	property, err := m.DB.GetPropertyByID(ctx, room.PropertyID)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.StartDate.Before(property.Today(time.Now())) {
+			//the stay has already begun where the property is
+			continue
+		}
+		token, hash, err := helpers.NewToken()
+		if err != nil {
+			return err
+		}
+		expires := time.Now().Add(m.App.WaitlistClaimDuration)
+
+		//the room is held for the guest for as long as the link works, a conflict means it is not free
+		//for their dates
+		holdID, err := m.DB.InsertHold(ctx, "", roomID, entry.StartDate, entry.EndDate, expires)
+		var conflict *repository.ConflictError
+		if errors.As(err, &conflict) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		err = m.DB.OfferWaitlistEntry(ctx, entry.ID, roomID, holdID, hash, expires)
+		if errors.Is(err, repository.ErrNotFound) {
+			//offered to the guest by another cancellation in the meantime
+			if err := m.DB.ReleaseHold(ctx, holdID); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		return m.Mailer.Send(ctx, mailer.Message{
+			To:      entry.Email,
+			Subject: "A room is available at " + property.Name,
+			Body: fmt.Sprintf("Good news! A room has become available at %s from %s to %s.\n\n"+
+				"It is yours to book until %s using this link:\n%s%s/waitlist/claim/%s\n",
+				property.Name, entry.StartDate.Format(dateLayout), entry.EndDate.Format(dateLayout),
+				property.Local(expires).Format("02-01-2006 15:04 MST"), m.App.BaseURL, propertyPrefix(property.Slug), token),
+		})
+	}
+	return nil
+}
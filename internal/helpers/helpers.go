@@ -1,11 +1,19 @@
 package helpers
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"runtime/debug"
 
 	"github.com/redblue-blur/bookings/internal/config"
+	"github.com/redblue-blur/bookings/internal/models"
+	"github.com/redblue-blur/bookings/internal/repository"
 )
 
 var app *config.AppConfig
@@ -23,5 +31,73 @@ func ClientError(w http.ResponseWriter, status int) {
 func ServerError(w http.ResponseWriter, err error) {
 	trace := fmt.Sprintf("%s/n%s", err.Error(), debug.Stack())
 	app.ErrorLog.Println(trace)
+
+	//a slow database is temporary, so tell the client to try again
+	var timeout *repository.TimeoutError
+	if errors.As(err, &timeout) {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
+
+//IsAuthenticated reports whether a user is logged in on the request's session
+func IsAuthenticated(r *http.Request) bool {
+	return app.Session.Exists(r.Context(), "user_id")
+}
+
+//NewToken returns a random token to put in a link and the hash of it to store
+func NewToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+//HashToken hashes a token the way NewToken does, so a token from a link can be looked up
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+//Site is the property a request is for and the path prefix its public pages are served under
+type Site struct {
+	Property models.Property
+	Prefix   string
+}
+
+type contextKey string
+
+const (
+	siteKey contextKey = "site"
+	userKey contextKey = "user"
+)
+
+//WithSite returns a copy of ctx carrying the site of the request
+func WithSite(ctx context.Context, site Site) context.Context {
+	return context.WithValue(ctx, siteKey, site)
+}
+
+//SiteFrom returns the site stored in ctx, the zero site when there is none
+func SiteFrom(ctx context.Context) Site {
+	site, _ := ctx.Value(siteKey).(Site)
+	return site
+}
+
+//WithUser returns a copy of ctx carrying the logged in user
+func WithUser(ctx context.Context, user models.User) context.Context {
+	return context.WithValue(ctx, userKey, user)
+}
+
+//UserFrom returns the user stored in ctx, the zero user, who can do nothing, when there is none
+func UserFrom(ctx context.Context) models.User {
+	user, _ := ctx.Value(userKey).(models.User)
+	return user
+}
+
+//SitePath turns a path on the public site into the url path it is served at for the request's property
+func SitePath(r *http.Request, path string) string {
+	return SiteFrom(r.Context()).Prefix + path
+}
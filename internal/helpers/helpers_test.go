@@ -0,0 +1,42 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/redblue-blur/bookings/internal/config"
+	"github.com/redblue-blur/bookings/internal/repository"
+)
+
+func TestServerError(t *testing.T) {
+	NewHelpers(&config.AppConfig{
+		InfoLog:  log.New(io.Discard, "", 0),
+		ErrorLog: log.New(io.Discard, "", 0),
+	})
+
+	timeout := &repository.TimeoutError{Err: context.DeadlineExceeded}
+	tests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"timeout", timeout, http.StatusServiceUnavailable},
+		{"wrapped-timeout", fmt.Errorf("cannot load the room: %w", timeout), http.StatusServiceUnavailable},
+		{"other", errors.New("something broke"), http.StatusInternalServerError},
+		{"cancelled", context.Canceled, http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		rr := httptest.NewRecorder()
+		ServerError(rr, e.err)
+		if rr.Code != e.status {
+			t.Errorf("for %s expected %d but got %d", e.name, e.status, rr.Code)
+		}
+	}
+}
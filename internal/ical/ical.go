@@ -0,0 +1,251 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+//dateLayout is the form of DATE values, stays and blocks are whole days
+const dateLayout = "20060102"
+
+//stampLayout is the form of UTC DATE-TIME values
+const stampLayout = "20060102T150405Z"
+
+//maxLineLength is the longest a content line may be in octets before it is folded
+const maxLineLength = 75
+
+//Event is an all day event, the way stays and blocks show up in a calendar
+type Event struct {
+	UID         string
+	Start       time.Time //the first day
+	End         time.Time //the day after the last day, like a departure date
+	Summary     string
+	Description string
+	Stamp       time.Time //when the event last changed
+}
+
+//Calendar is a published calendar of events
+type Calendar struct {
+	ProdID string
+	Name   string
+	Events []Event
+}
+
+//WriteTo writes the calendar as an iCalendar stream as described in RFC 5545
+func (c Calendar) WriteTo(w io.Writer) (int64, error) {
+	cw := &contentWriter{w: bufio.NewWriter(w)}
+
+	cw.line("BEGIN", "VCALENDAR")
+	cw.line("VERSION", "2.0")
+	cw.line("PRODID", c.ProdID)
+	cw.line("CALSCALE", "GREGORIAN")
+	cw.line("METHOD", "PUBLISH")
+	if c.Name != "" {
+		cw.line("X-WR-CALNAME", escape(c.Name))
+	}
+	for _, e := range c.Events {
+		cw.line("BEGIN", "VEVENT")
+		cw.line("UID", escape(e.UID))
+		cw.line("DTSTAMP", e.Stamp.UTC().Format(stampLayout))
+		cw.line("DTSTART;VALUE=DATE", e.Start.Format(dateLayout))
+		cw.line("DTEND;VALUE=DATE", e.End.Format(dateLayout))
+		cw.line("SUMMARY", escape(e.Summary))
+		if e.Description != "" {
+			cw.line("DESCRIPTION", escape(e.Description))
+		}
+		cw.line("TRANSP", "OPAQUE")
+		cw.line("END", "VEVENT")
+	}
+	cw.line("END", "VCALENDAR")
+
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+//contentWriter writes content lines ended with CRLF, folding the long ones
+type contentWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+//line writes a property, folding it into lines of at most maxLineLength octets without splitting characters
+func (cw *contentWriter) line(name, value string) {
+	s := name + ":" + value
+	for len(s) > maxLineLength {
+		cut := maxLineLength
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		cw.write(s[:cut] + "\r\n")
+		//continuation lines start with a space, which counts towards their length
+		s = " " + s[cut:]
+	}
+	cw.write(s + "\r\n")
+}
+
+//write writes s unless an earlier write failed
+func (cw *contentWriter) write(s string) {
+	if cw.err != nil {
+		return
+	}
+	n, err := cw.w.WriteString(s)
+	cw.n += int64(n)
+	cw.err = err
+}
+
+//escaper escapes the characters TEXT values cannot hold as they are
+var escaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+//escape makes s safe to use as a TEXT value
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+//unescaper reverses escape
+var unescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+//ErrNotCalendar is returned by Parse for input without a VCALENDAR
+var ErrNotCalendar = errors.New("not an iCalendar stream")
+
+//Parse reads the events of an iCalendar stream, dates and times are taken as days in the zone they are
+//written in and cancelled events are left out
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	var e *Event
+	var cancelled, calendar bool
+	for i, line := range lines {
+		name, params, value, ok := splitLine(line)
+		if !ok {
+			return nil, fmt.Errorf("line %d: missing colon", i+1)
+		}
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VCALENDAR"):
+			calendar = true
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			e = &Event{}
+			cancelled = false
+		case name == "END" && strings.EqualFold(value, "VEVENT") && e != nil:
+			if e.Start.IsZero() {
+				return nil, fmt.Errorf("event %q has no start", e.UID)
+			}
+			if !e.End.After(e.Start) {
+				e.End = e.Start.AddDate(0, 0, 1)
+			}
+			if !cancelled {
+				events = append(events, *e)
+			}
+			e = nil
+		case e == nil:
+			//properties of the calendar itself and of other components are not needed
+		case name == "UID":
+			e.UID = value
+		case name == "SUMMARY":
+			e.Summary = unescaper.Replace(value)
+		case name == "DESCRIPTION":
+			e.Description = unescaper.Replace(value)
+		case name == "STATUS":
+			cancelled = strings.EqualFold(value, "CANCELLED")
+		case name == "DTSTAMP":
+			e.Stamp, _ = parseTime(value, params)
+		case name == "DTSTART" || name == "DTEND":
+			day, err := parseTime(value, params)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s: %w", i+1, name, err)
+			}
+			day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+			if name == "DTSTART" {
+				e.Start = day
+			} else {
+				e.End = day
+			}
+		}
+	}
+	if !calendar {
+		return nil, ErrNotCalendar
+	}
+	return events, nil
+}
+
+//unfold reads content lines, joining folded lines back together and accepting bare LF line endings
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+//splitLine splits a content line into its upper cased name, its parameters and its value
+func splitLine(line string) (string, map[string]string, string, bool) {
+	//the value starts at the first colon outside a quoted parameter value
+	quoted := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon == -1 {
+		return "", nil, "", false
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	params := make(map[string]string)
+	for _, p := range parts[1:] {
+		if k, v, ok := cut(p, "="); ok {
+			params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+		}
+	}
+	return strings.ToUpper(parts[0]), params, line[colon+1:], true
+}
+
+//cut slices s around the first sep
+func cut(s, sep string) (string, string, bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+//parseTime reads a DATE or DATE-TIME value, in UTC, in its TZID zone or floating
+func parseTime(value string, params map[string]string) (time.Time, error) {
+	if params["VALUE"] == "DATE" || len(value) == len(dateLayout) {
+		return time.Parse(dateLayout, value)
+	}
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse(stampLayout, value)
+	}
+	loc := time.UTC
+	if tzid := params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	return time.ParseInLocation(strings.TrimSuffix(stampLayout, "Z"), value, loc)
+}
@@ -0,0 +1,177 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalendar_WriteTo(t *testing.T) {
+	cal := Calendar{
+		ProdID: "-//Bookings//Room calendar//EN",
+		Name:   "Fort Smythe, General's Quarters",
+		Events: []Event{
+			{
+				UID:     "restriction-7@localhost",
+				Start:   time.Date(2050, 3, 21, 0, 0, 0, 0, time.UTC),
+				End:     time.Date(2050, 3, 23, 0, 0, 0, 0, time.UTC),
+				Summary: "Reserved; J. S.",
+				Stamp:   time.Date(2022, 3, 1, 10, 30, 0, 0, time.FixedZone("CET", 3600)),
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	n, err := cal.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("expected %d bytes written but got %d", buf.Len(), n)
+	}
+
+	out := buf.String()
+	if !strings.HasSuffix(out, "END:VCALENDAR\r\n") || strings.Count(out, "\n") != strings.Count(out, "\r\n") {
+		t.Errorf("expected every line to end with CRLF but got %q", out)
+	}
+	for _, line := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"VERSION:2.0\r\n",
+		"X-WR-CALNAME:Fort Smythe\\, General's Quarters\r\n",
+		"UID:restriction-7@localhost\r\n",
+		"DTSTAMP:20220301T093000Z\r\n",
+		"DTSTART;VALUE=DATE:20500321\r\n",
+		"DTEND;VALUE=DATE:20500323\r\n",
+		"SUMMARY:Reserved\\; J. S.\r\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected %q in %q", line, out)
+		}
+	}
+	if strings.Contains(out, "DESCRIPTION") {
+		t.Error("expected no description for an event without one")
+	}
+}
+
+func TestCalendar_WriteToFoldsLongLines(t *testing.T) {
+	cal := Calendar{
+		ProdID: "-//Bookings//Room calendar//EN",
+		Events: []Event{
+			{Summary: strings.Repeat("é", 100), Description: "line one\nline two"},
+		},
+	}
+
+	var buf bytes.Buffer
+	if _, err := cal.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var summary string
+	for i, line := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		if len(line) > maxLineLength {
+			t.Errorf("line %d is %d octets long", i, len(line))
+		}
+		if strings.HasPrefix(line, "SUMMARY:") {
+			summary = line
+		} else if summary != "" && strings.HasPrefix(line, " ") {
+			summary += line[1:]
+		} else if summary != "" {
+			break
+		}
+	}
+	if summary != "SUMMARY:"+strings.Repeat("é", 100) {
+		t.Errorf("expected the folded summary to unfold to the original but got %q", summary)
+	}
+	if !strings.Contains(buf.String(), "DESCRIPTION:line one\\nline two\r\n") {
+		t.Errorf("expected the newline in the description to be escaped but got %q", buf.String())
+	}
+}
+
+func TestParse(t *testing.T) {
+	feed := "BEGIN:VCALENDAR\r\n" +
+		"VERSION:2.0\r\n" +
+		"PRODID:-//Example OTA//EN\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:abc@ota.example\r\n" +
+		"DTSTART;VALUE=DATE:20500321\r\n" +
+		"DTEND;VALUE=DATE:20500324\r\n" +
+		"SUMMARY:Reserved\\, via\r\n" +
+		"  the OTA\r\n" +
+		"END:VEVENT\n" +
+		"BEGIN:VEVENT\n" +
+		"UID:def@ota.example\n" +
+		"DTSTART;TZID=\"Pacific/Kiritimati\":20500401T230000\n" +
+		"END:VEVENT\n" +
+		"BEGIN:VEVENT\n" +
+		"UID:ghi@ota.example\n" +
+		"DTSTART:20500410T120000Z\n" +
+		"DTEND:20500412T100000Z\n" +
+		"STATUS:CANCELLED\n" +
+		"END:VEVENT\n" +
+		"END:VCALENDAR\n"
+
+	events, err := Parse(strings.NewReader(feed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected the two events that are not cancelled but got %d", len(events))
+	}
+
+	first := events[0]
+	if first.UID != "abc@ota.example" || first.Summary != "Reserved, via the OTA" {
+		t.Errorf("expected the uid and unfolded summary but got %q and %q", first.UID, first.Summary)
+	}
+	if !first.Start.Equal(time.Date(2050, 3, 21, 0, 0, 0, 0, time.UTC)) || !first.End.Equal(time.Date(2050, 3, 24, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected 21 to 24 march but got %v to %v", first.Start, first.End)
+	}
+
+	//an event without an end lasts a day, on the date it starts in its own zone
+	second := events[1]
+	if !second.Start.Equal(time.Date(2050, 4, 1, 0, 0, 0, 0, time.UTC)) || !second.End.Equal(time.Date(2050, 4, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected 1 to 2 april but got %v to %v", second.Start, second.End)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	var tests = []struct {
+		name string
+		feed string
+	}{
+		{"not a calendar", "<html>Not found</html>\n"},
+		{"no start", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:x\nEND:VEVENT\nEND:VCALENDAR\n"},
+		{"bad date", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nDTSTART;VALUE=DATE:2050-03-21\nEND:VEVENT\nEND:VCALENDAR\n"},
+	}
+	for _, e := range tests {
+		if _, err := Parse(strings.NewReader(e.feed)); err == nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+	}
+}
+
+func TestParse_WhatWriteToWrites(t *testing.T) {
+	cal := Calendar{
+		ProdID: "-//Bookings//Room calendar//EN",
+		Events: []Event{
+			{
+				UID:     "restriction-1@localhost",
+				Start:   time.Date(2050, 3, 21, 0, 0, 0, 0, time.UTC),
+				End:     time.Date(2050, 3, 23, 0, 0, 0, 0, time.UTC),
+				Summary: strings.Repeat("Reserved; by a guest, ", 5),
+			},
+		},
+	}
+	var buf bytes.Buffer
+	if _, err := cal.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Summary != cal.Events[0].Summary || !events[0].End.Equal(cal.Events[0].End) {
+		t.Errorf("expected the written event back but got %+v", events)
+	}
+}
@@ -0,0 +1,84 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+	"sync"
+)
+
+//Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+//Mailer sends email
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+//Log writes messages to a logger instead of sending them, for development
+type Log struct {
+	Logger *log.Logger
+}
+
+//Send logs the message
+func (m Log) Send(ctx context.Context, msg Message) error {
+	m.Logger.Printf("mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+//Memory keeps the messages it is given, for tests
+type Memory struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+//Send keeps the message
+func (m *Memory) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+//Sent returns the messages sent so far
+func (m *Memory) Sent() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message(nil), m.sent...)
+}
+
+//SMTP sends messages through a mail server
+type SMTP struct {
+	Addr string //host:port
+	From string
+	Auth smtp.Auth
+}
+
+//Send delivers the message to the mail server
+func (m SMTP) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{msg.To}, []byte(b.String()))
+}
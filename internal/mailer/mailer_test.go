@@ -0,0 +1,37 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestMemory_Send(t *testing.T) {
+	m := &Memory{}
+	err := m.Send(context.Background(), Message{To: "guest@mail.com", Subject: "Hello", Body: "Hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent := m.Sent(); len(sent) != 1 || sent[0].To != "guest@mail.com" {
+		t.Errorf("expected the message to be kept but got %v", sent)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.Send(ctx, Message{}); err == nil {
+		t.Error("expected a cancelled context to stop the send")
+	}
+}
+
+func TestLog_Send(t *testing.T) {
+	var buf bytes.Buffer
+	m := Log{Logger: log.New(&buf, "", 0)}
+	if err := m.Send(context.Background(), Message{To: "guest@mail.com", Subject: "Hello", Body: "Hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "guest@mail.com: Hello") {
+		t.Errorf("expected the message in the log but got %q", buf.String())
+	}
+}
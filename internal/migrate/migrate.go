@@ -0,0 +1,244 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//lockKey is the postgres advisory lock held while migrating, so migrations run by two deploys at once are applied
+//one after the other instead of twice
+const lockKey = 20220129
+
+//fileName matches <version>_<name>.up.sql and <version>_<name>.down.sql
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+//Migration is one versioned schema change
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+//Status is a migration and whether it has been applied
+type Status struct {
+	Migration
+	AppliedAt time.Time
+	Applied   bool
+}
+
+//Migrator applies migrations and tracks them in the schema_migrations table
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+}
+
+//New loads the migrations from fsys and returns a migrator for db
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		DB:         db,
+		Migrations: migrations,
+	}, nil
+}
+
+//Load reads the migrations in fsys sorted by version, other files are ignored
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+
+		contents, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+//Up applies every pending migration in order and returns the ones it applied
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range m.Migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		err := m.inTx(ctx, migration.Up,
+			`insert into schema_migrations (version, applied_at) values ($1, $2)`, migration.Version, time.Now())
+		if err != nil {
+			return done, fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+//Down reverts the last n applied migrations, newest first, and returns the ones it reverted
+func (m *Migrator) Down(ctx context.Context, n int) ([]Migration, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(m.Migrations) - 1; i >= 0 && len(done) < n; i-- {
+		migration := m.Migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if migration.Down == "" {
+			return done, fmt.Errorf("migration %d_%s has no down file", migration.Version, migration.Name)
+		}
+		err := m.inTx(ctx, migration.Down,
+			`delete from schema_migrations where version = $1`, migration.Version)
+		if err != nil {
+			return done, fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+//Status lists every migration and when it was applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.Migrations))
+	for _, migration := range m.Migrations {
+		appliedAt, ok := applied[migration.Version]
+		statuses = append(statuses, Status{
+			Migration: migration,
+			AppliedAt: appliedAt,
+			Applied:   ok,
+		})
+	}
+	return statuses, nil
+}
+
+//lock waits for the migration lock and returns the func that releases it, the lock belongs to the database
+//session so it is taken on a connection of its own that is kept until then
+func (m *Migrator) lock(ctx context.Context) (func(), error) {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, `select pg_advisory_lock($1)`, lockKey); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return func() {
+		conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, lockKey)
+		conn.Close()
+	}, nil
+}
+
+//applied creates the tracking table if needed and returns the applied versions
+func (m *Migrator) applied(ctx context.Context) (map[int64]time.Time, error) {
+	stmt := `create table if not exists schema_migrations (
+			version bigint primary key,
+			applied_at timestamp not null)`
+
+	if _, err := m.DB.ExecContext(ctx, stmt); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `select version, applied_at from schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+//inTx runs a migration and the matching schema_migrations change in one transaction
+func (m *Migrator) inTx(ctx context.Context, migration, track string, args ...interface{}) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, migration); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, track, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,62 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/redblue-blur/bookings/migrations"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"2_add_rooms.up.sql":      {Data: []byte("create table rooms ();")},
+		"2_add_rooms.down.sql":    {Data: []byte("drop table rooms;")},
+		"1_add_users.up.sql":      {Data: []byte("create table users ();")},
+		"1_add_users.down.sql":    {Data: []byte("drop table users;")},
+		"schema.sql":              {Data: []byte("")},
+		"3_seed_rooms.up.fizz":    {Data: []byte("")},
+		"10_add_indices.up.sql":   {Data: []byte("create index a on b (c);")},
+		"10_add_indices.down.sql": {Data: []byte("drop index a;")},
+	}
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 3 {
+		t.Fatalf("expected 3 migrations but got %d", len(migrations))
+	}
+	for i, expected := range []int64{1, 2, 10} {
+		if migrations[i].Version != expected {
+			t.Errorf("migration %d: expected version %d but got %d", i, expected, migrations[i].Version)
+		}
+	}
+	if migrations[0].Name != "add_users" || migrations[0].Down != "drop table users;" {
+		t.Errorf("migration 1 was not read correctly: %+v", migrations[0])
+	}
+}
+
+func TestLoad_MissingUp(t *testing.T) {
+	fsys := fstest.MapFS{
+		"1_add_users.down.sql": {Data: []byte("drop table users;")},
+	}
+
+	if _, err := Load(fsys); err == nil {
+		t.Error("loaded a migration without an up file")
+	}
+}
+
+func TestLoad_Embedded(t *testing.T) {
+	all, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) == 0 {
+		t.Fatal("no embedded migrations found")
+	}
+	for _, m := range all {
+		if m.Down == "" {
+			t.Errorf("migration %d_%s has no down file", m.Version, m.Name)
+		}
+	}
+}
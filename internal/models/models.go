@@ -1,6 +1,47 @@
 package models
 
-import "time"
+import (
+	"strings"
+	"time"
+)
+
+//ids of the rows seeded in the restrictions table
+const (
+	RestrictionReservation = 1
+	RestrictionOwnerBlock  = 2 //nights the owner keeps the room off sale
+	RestrictionHold        = 3
+	RestrictionExternal    = 4 //booked through another site, imported from a room calendar
+)
+
+//kinds of tax and fee rules
+const (
+	TaxOccupancy = "occupancy" //percent of the room charge
+	TaxCleaning  = "cleaning"  //flat fee per stay, in cents
+	TaxCity      = "city"      //in cents per guest per night
+)
+
+//penalties charged once a cancellation policy's free period is over
+const (
+	PenaltyPercent    = "percent"
+	PenaltyFirstNight = "first_night"
+)
+
+//how guest names show in room calendar feeds
+const (
+	CalendarNamesHidden   = "hidden"   //stays only show as reserved
+	CalendarNamesInitials = "initials" //stays show the guest's initials
+)
+
+//ClockLayout is how check-in and check-out times are written
+const ClockLayout = "15:04"
+
+//statuses of a waitlist entry
+const (
+	WaitlistWaiting = "waiting"
+	WaitlistOffered = "offered" //sent a claim link that has not been used yet
+	WaitlistClaimed = "claimed"
+	WaitlistExpired = "expired"
+)
 
 //User is the user model
 type User struct {
@@ -10,39 +51,164 @@ type User struct {
 	Email       string
 	Password    string
 	AccessLevel int
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	//SessionVersion moves on when the password is reset, sessions logged in with an older one are logged out
+	SessionVersion int
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
 }
 
-//Room is the room model
-type Room struct {
+//PasswordReset is a link sent to a user to choose a new password, only the hash of its token is kept
+type PasswordReset struct {
 	ID        int
-	RoomName  string
+	UserID    int
+	ExpiresAt time.Time
+	UsedAt    time.Time //zero until the link is used, a link works once
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+//Property is one of the inns run on the site, it owns its rooms, taxes, promo codes and waitlist
+type Property struct {
+	ID       int
+	Name     string
+	Slug     string //the site is served under /p/<slug>
+	Hostname string //the site is also served at the root of this host, optional
+	Email    string
+	Phone    string
+	Address  string
+	//TimeZone is the IANA name of the property's time zone, stay dates are calendar dates there
+	TimeZone      string
+	CheckInTime   string //from this time on the arrival date, as 15:04
+	CheckOutTime  string //by this time on the departure date, as 15:04
+	CalendarNames string //how guest names show in room calendar feeds, one of the CalendarNames constants
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+//Location is the property's time zone, UTC when it is not set or not known
+func (p Property) Location() *time.Location {
+	loc, err := time.LoadLocation(p.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+//Today is the calendar date at the property at the moment now
+func (p Property) Today(now time.Time) time.Time {
+	return Date(now.In(p.Location()))
+}
+
+//Local shows a moment on the property's clock
+func (p Property) Local(t time.Time) time.Time {
+	return t.In(p.Location())
+}
+
+//Date keeps the calendar date of t at midnight UTC, the way stay dates are parsed and stored
+func Date(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+//Room is the room model
+type Room struct {
+	ID               int
+	PropertyID       int
+	RoomName         string
+	Slug             string
+	RoomType         string
+	MaxAdults        int
+	MaxChildren      int
+	BedConfiguration string
+	Description      string
+	BasePrice        int //nightly price in cents
+	WeekendPercent   int //added to the nightly price on friday and saturday nights
+	Amenities        []string
+	//CancellationPolicyID is the policy for stays in the room unless a seasonal rate has its own
+	CancellationPolicyID int
+	//CalendarToken is the secret in the url of the room's calendar feed, the feed is off while it is empty
+	CalendarToken string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
 //Restriction is the restriction model
 type Restriction struct {
-	ID        int
-	RoomName  string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID              int
+	RestrictionName string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
 }
 
 //Reservation is the reservation model
 type Reservation struct {
+	ID            int
+	Code          string //given to the guest to look up the reservation
+	FirstName     string
+	LastName      string
+	Email         string
+	RoomID        int
+	Phone         string
+	StartDate     time.Time
+	EndDate       time.Time
+	Guests        int
+	TotalPrice    int //in cents
+	PromoCodeID   int
+	PromoDiscount int //in cents, recorded as the promo code redemption
+	//CancellationPolicyID is the policy in force when the reservation was booked
+	CancellationPolicyID int
+	//BookingID is the group booking the reservation was made in, 0 when it was booked on its own
+	BookingID int
+	//GuestID is the profile of the guest who booked, found by the reservation's email
+	GuestID         int
+	CancelledAt     time.Time
+	CancellationFee int //in cents
+	RefundAmount    int //in cents
+	//SettlementError is why the payments of a cancelled reservation could not all be settled, empty once they are
+	SettlementError string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Room            Room
+	LineItems       []LineItem
+	Payments        []Payment
+}
+
+//Guest is a guest's profile, reservations are linked to it by the normalised email they were booked with
+type Guest struct {
 	ID        int
+	Email     string //the normalised email the profile was made for
 	FirstName string
 	LastName  string
-	Email     string
-	RoomID    int
 	Phone     string
-	StartDate time.Time
-	EndDate   time.Time
+	Notes     string
+	Stays     int //the guest's reservations that were not cancelled
 	CreatedAt time.Time
 	UpdatedAt time.Time
-	Room      Room
+	//Emails are every normalised email that leads to the profile, merging adds the merged profiles' emails
+	Emails []string
+	//Reservations are the guest's stay history, the latest first
+	Reservations []Reservation
+}
+
+//NormalizeEmail is the form of an email guest profiles are found by
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+//Booking groups reservations of several rooms made together under one lead guest, they are paid for and
+//confirmed together
+type Booking struct {
+	ID         int
+	Code       string //the confirmation code of the whole booking
+	FirstName  string
+	LastName   string
+	Email      string
+	Phone      string
+	TotalPrice int //in cents, the sum of the reservations' totals
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	//Reservations have the lead guest's details, their own codes and their own deposits, so each room can
+	//still be cancelled on its own
+	Reservations []Reservation
 }
 
 //RoomRestriction is the roomRestriction model
@@ -53,9 +219,216 @@ type RoomRestriction struct {
 	EndDate       time.Time
 	ReservationID int
 	RestrictionID int
+	//RoomCalendarID and ExternalUID identify the event an external restriction was imported from
+	RoomCalendarID int
+	ExternalUID    string
+	//HoldOwner is the session that placed a hold, a session has one hold at a time
+	HoldOwner string
+	//ExpiresAt is when a hold is released if it has not been confirmed
+	ExpiresAt   time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Room        Room
+	Reservation Reservation
+	Restriction Restriction
+}
+
+//SeasonalRate overrides a room's base price for the nights in its date range
+type SeasonalRate struct {
+	ID           int
+	RoomID       int
+	Name         string
+	StartDate    time.Time
+	EndDate      time.Time
+	NightlyPrice int //in cents
+	//CancellationPolicyID overrides the room's policy for stays arriving in the season, 0 keeps the room's
+	CancellationPolicyID int
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+}
+
+//StayDiscount takes a percentage off stays of at least MinNights, RoomID 0 applies to every room
+type StayDiscount struct {
+	ID        int
+	RoomID    int
+	MinNights int
+	Percent   int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+//StayRule limits the stays that can be booked in a room for the dates from StartDate up to EndDate, it
+//takes no nights itself the way a room restriction does
+type StayRule struct {
+	ID        int
+	RoomID    int
+	Name      string
+	StartDate time.Time
+	EndDate   time.Time
+	//Weekdays has bit n set when the rule applies on time.Weekday n, 0 applies every day
+	Weekdays int
+	//MinNights and MaxNights bound stays that include a night the rule applies on, 0 for no bound
+	MinNights int
+	MaxNights int
+	//ClosedToArrival and ClosedToDeparture refuse stays that start or end on a day the rule applies on
+	ClosedToArrival   bool
+	ClosedToDeparture bool
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+	Room              Room
+}
+
+//AppliesOn reports whether the rule covers the day
+func (s StayRule) AppliesOn(day time.Time) bool {
+	if day.Before(s.StartDate) || !day.Before(s.EndDate) {
+		return false
+	}
+	return s.Weekdays == 0 || s.Weekdays&(1<<uint(day.Weekday())) != 0
+}
+
+//Days lists the days of the week the rule applies on, none when it applies every day
+func (s StayRule) Days() []time.Weekday {
+	var days []time.Weekday
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if s.Weekdays&(1<<uint(day)) != 0 {
+			days = append(days, day)
+		}
+	}
+	return days
+}
+
+//PromoCode is a discount code guests can enter when they book, no RoomIDs means every room
+type PromoCode struct {
+	ID         int
+	PropertyID int
+	Code       string
+	PercentOff int
+	AmountOff  int //in cents
+	ValidFrom  time.Time
+	ValidTo    time.Time
+	MinNights  int
+	MaxUses    int //0 for no limit
+	TimesUsed  int
+	RoomIDs    []int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+//TaxRule is a tax or fee charged on stays booked from EffectiveFrom until a newer rule of the same kind takes over
+type TaxRule struct {
+	ID            int
+	PropertyID    int
+	Kind          string
+	Name          string
+	Amount        int //percent for the occupancy tax, cents otherwise
+	EffectiveFrom time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+//LineItem is one line of a reservation's price as it was booked, Night is only set for nightly rates
+type LineItem struct {
+	ID            int
+	ReservationID int
+	Position      int
+	Kind          string
+	Description   string
+	Night         time.Time
+	Amount        int //in cents
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
-	Room          Room
-	Reservation   Reservation
-	Restriction   Restriction
+}
+
+//Payment is a payment taken through a payment provider for a reservation or a group booking
+type Payment struct {
+	ID            int
+	ReservationID int
+	BookingID     int //the group booking the reservation was made in, 0 when it was booked on its own
+	Provider      string
+	ProviderID    string
+	Status        string
+	Amount        int //in cents
+	CardLast4     string
+	//SettledAt is when cancelling the reservation took its fee from the payment and gave back the rest
+	SettledAt time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+//CancellationPolicy makes cancelling free until FreeDays before arrival, then charges the penalty
+type CancellationPolicy struct {
+	ID             int
+	Name           string
+	FreeDays       int
+	PenaltyType    string
+	PenaltyPercent int
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+//WaitlistEntry is a guest waiting for a room to free up for their dates, RoomID 0 means any room
+type WaitlistEntry struct {
+	ID             int
+	PropertyID     int
+	Email          string
+	RoomID         int
+	StartDate      time.Time
+	EndDate        time.Time
+	Status         string
+	ClaimRoomID    int
+	ClaimTokenHash string
+	ClaimExpiresAt time.Time
+	//ClaimHoldID is the hold keeping the offered room for the guest until the claim runs out
+	ClaimHoldID int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+//RoomCalendar is another site's calendar for the room, like an OTA's, whose events block the room here
+type RoomCalendar struct {
+	ID           int
+	RoomID       int
+	Name         string
+	URL          string //an http or https url, or the path of a local file
+	LastSyncedAt time.Time
+	LastError    string //why the last attempt to sync failed, empty when it worked
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Room         Room
+	Conflicts    []CalendarConflict
+}
+
+//ExternalEvent is a booking or block read from a room calendar
+type ExternalEvent struct {
+	UID       string
+	Summary   string
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+//CalendarConflict is an event of a room calendar that could not be imported because the room is taken
+type CalendarConflict struct {
+	ID             int
+	RoomCalendarID int
+	UID            string
+	Summary        string
+	StartDate      time.Time
+	EndDate        time.Time
+	ReservationID  int //the reservation here it collides with, 0 when it collides with a block
+	CreatedAt      time.Time
+}
+
+//CalendarSync counts what syncing a room calendar changed
+type CalendarSync struct {
+	Added     int
+	Updated   int //events whose dates moved
+	Removed   int //events that are no longer in the calendar
+	Conflicts []CalendarConflict
+}
+
+//OwnerBlockChange blocks or frees one night of a room for its owner
+type OwnerBlockChange struct {
+	RoomID int
+	Night  time.Time
+	Block  bool
 }
@@ -0,0 +1,59 @@
+package models
+
+//Permission is something in the admin pages a user's role lets them do
+type Permission string
+
+const (
+	PermViewRoomStatus     Permission = "rooms.status"        //see which rooms are taken on the reservation calendar
+	PermManageReservations Permission = "reservations.manage" //cancel reservations and block nights
+	PermViewPayments       Permission = "payments.view"       //see what guests paid and are owed
+	PermViewGuests         Permission = "guests.view"         //see and edit guest profiles
+	PermMergeGuests        Permission = "guests.merge"
+	PermManageProperty     Permission = "property.manage" //settings, taxes, stay rules and calendars of a property
+	PermAddProperties      Permission = "properties.add"
+)
+
+//access levels of users, each is a role with its own permissions
+const (
+	AccessHousekeeping = 1
+	AccessFrontDesk    = 2
+	AccessManager      = 3
+	AccessOwner        = 4
+)
+
+//role is the name and permissions of an access level
+type role struct {
+	Name        string
+	Permissions []Permission
+}
+
+//roles are the known access levels, a user with another level can do nothing
+var roles = map[int]role{
+	AccessHousekeeping: {"Housekeeping", []Permission{PermViewRoomStatus}},
+	AccessFrontDesk: {"Front desk", []Permission{
+		PermViewRoomStatus, PermManageReservations, PermViewPayments, PermViewGuests,
+	}},
+	AccessManager: {"Manager", []Permission{
+		PermViewRoomStatus, PermManageReservations, PermViewPayments, PermViewGuests, PermMergeGuests,
+		PermManageProperty,
+	}},
+	AccessOwner: {"Owner", []Permission{
+		PermViewRoomStatus, PermManageReservations, PermViewPayments, PermViewGuests, PermMergeGuests,
+		PermManageProperty, PermAddProperties,
+	}},
+}
+
+//Role is the name of the user's role
+func (u User) Role() string {
+	return roles[u.AccessLevel].Name
+}
+
+//Can reports whether the user's role has the permission
+func (u User) Can(p Permission) bool {
+	for _, granted := range roles[u.AccessLevel].Permissions {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
@@ -13,4 +13,11 @@ type TemplateData struct {
 	Warning   string
 	Error     string
 	Form      *forms.Form
+	//IsAuthenticated is true when a user is logged in
+	IsAuthenticated bool
+	//User is the logged in user on admin pages, templates check what they may see with can
+	User     User
+	Property Property
+	//BasePath is put in front of links to the property's public pages
+	BasePath string
 }
@@ -0,0 +1,231 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+//test card numbers understood by the fake gateway, any other number that passes the Luhn check is approved
+const (
+	CardApproved          = "4242424242424242"
+	CardDeclined          = "4000000000000002"
+	CardInsufficientFunds = "4000000000009995"
+	CardExpired           = "4000000000000069"
+	CardProcessingError   = "4000000000000119"
+)
+
+//declines maps the test cards to the decline they trigger
+var declines = map[string]DeclineError{
+	CardDeclined:          {Code: "card_declined", Message: "Your card was declined"},
+	CardInsufficientFunds: {Code: "insufficient_funds", Message: "Your card has insufficient funds"},
+	CardExpired:           {Code: "expired_card", Message: "Your card has expired"},
+	CardProcessingError:   {Code: "processing_error", Message: "An error occurred while processing your card, please try again"},
+}
+
+//Fake is an in-memory gateway that behaves like a card processor, for tests and running offline
+type Fake struct {
+	mu          sync.Mutex
+	payments    map[string]*Payment
+	lastID      int
+	subscribers []func(Event)
+	now         func() time.Time
+}
+
+//NewFake creates an empty fake gateway
+func NewFake() *Fake {
+	return &Fake{
+		payments: map[string]*Payment{},
+		now:      time.Now,
+	}
+}
+
+//Name identifies the fake in stored payments
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+//Subscribe registers fn to be called with every event, in the order they happen
+func (f *Fake) Subscribe(fn func(Event)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.subscribers = append(f.subscribers, fn)
+}
+
+//Authorize approves the card unless it is one of the declining test cards
+func (f *Fake) Authorize(ctx context.Context, req AuthorizeRequest) (Payment, error) {
+	if err := ctx.Err(); err != nil {
+		return Payment{}, err
+	}
+	if req.Amount <= 0 {
+		return Payment{}, ErrInvalidAmount
+	}
+
+	number := strings.ReplaceAll(strings.ReplaceAll(req.Card.Number, " ", ""), "-", "")
+	decline, declined := declines[number]
+	if !declined && !luhn(number) {
+		decline, declined = DeclineError{Code: "incorrect_number", Message: "Your card number is incorrect"}, true
+	}
+	if !declined && cardExpired(req.Card, f.now()) {
+		decline, declined = DeclineError{Code: "expired_card", Message: "Your card has expired"}, true
+	}
+
+	f.mu.Lock()
+	now := f.now()
+	f.lastID++
+	p := &Payment{
+		ID:        fmt.Sprintf("fake_%d", f.lastID),
+		Reference: req.Reference,
+		Status:    StatusAuthorized,
+		Amount:    req.Amount,
+		CardLast4: last4(number),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if declined {
+		p.Status = StatusDeclined
+	}
+	f.payments[p.ID] = p
+	payment := *p
+	f.mu.Unlock()
+
+	f.emit("payment."+string(payment.Status), payment)
+	if declined {
+		return payment, &decline
+	}
+	return payment, nil
+}
+
+//Capture takes an authorized amount, zero captures all of it
+func (f *Fake) Capture(ctx context.Context, id string, amount int) (Payment, error) {
+	return f.update(ctx, id, "payment.captured", func(p *Payment) error {
+		if p.Status != StatusAuthorized {
+			return ErrInvalidState
+		}
+		if amount == 0 {
+			amount = p.Amount
+		}
+		if amount < 0 || amount > p.Amount {
+			return ErrInvalidAmount
+		}
+		p.Captured = amount
+		p.Status = StatusCaptured
+		return nil
+	})
+}
+
+//Refund gives back a captured amount, zero refunds whatever has not been refunded yet
+func (f *Fake) Refund(ctx context.Context, id string, amount int) (Payment, error) {
+	return f.update(ctx, id, "payment.refunded", func(p *Payment) error {
+		if p.Status != StatusCaptured && p.Status != StatusPartiallyRefunded {
+			return ErrInvalidState
+		}
+		left := p.Captured - p.Refunded
+		if amount == 0 {
+			amount = left
+		}
+		if amount <= 0 || amount > left {
+			return ErrInvalidAmount
+		}
+		p.Refunded += amount
+		p.Status = StatusPartiallyRefunded
+		if p.Refunded == p.Captured {
+			p.Status = StatusRefunded
+		}
+		return nil
+	})
+}
+
+//Void cancels an authorization that has not been captured
+func (f *Fake) Void(ctx context.Context, id string) (Payment, error) {
+	return f.update(ctx, id, "payment.voided", func(p *Payment) error {
+		if p.Status != StatusAuthorized {
+			return ErrInvalidState
+		}
+		p.Status = StatusVoided
+		return nil
+	})
+}
+
+//update applies fn to the payment under the lock and emits the event when it succeeds
+func (f *Fake) update(ctx context.Context, id, event string, fn func(p *Payment) error) (Payment, error) {
+	if err := ctx.Err(); err != nil {
+		return Payment{}, err
+	}
+
+	f.mu.Lock()
+	p, ok := f.payments[id]
+	if !ok {
+		f.mu.Unlock()
+		return Payment{}, ErrNotFound
+	}
+	if err := fn(p); err != nil {
+		payment := *p
+		f.mu.Unlock()
+		return payment, err
+	}
+	p.UpdatedAt = f.now()
+	payment := *p
+	f.mu.Unlock()
+
+	f.emit(event, payment)
+	return payment, nil
+}
+
+//emit calls the subscribers outside the lock so they can call back into the gateway
+func (f *Fake) emit(eventType string, payment Payment) {
+	f.mu.Lock()
+	subscribers := make([]func(Event), len(f.subscribers))
+	copy(subscribers, f.subscribers)
+	f.mu.Unlock()
+
+	event := Event{Type: eventType, Payment: payment, Created: f.now()}
+	for _, fn := range subscribers {
+		fn(event)
+	}
+}
+
+//luhn checks the card number's check digit
+func luhn(number string) bool {
+	if len(number) < 12 || len(number) > 19 {
+		return false
+	}
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			return false
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+//cardExpired is true once the card's expiry month is over
+func cardExpired(card Card, now time.Time) bool {
+	year := card.ExpYear
+	if year < 100 {
+		year += 2000
+	}
+	expires := time.Date(year, time.Month(card.ExpMonth)+1, 1, 0, 0, 0, 0, time.UTC)
+	return card.ExpMonth < 1 || card.ExpMonth > 12 || !now.Before(expires)
+}
+
+func last4(number string) string {
+	if len(number) < 4 {
+		return number
+	}
+	return number[len(number)-4:]
+}
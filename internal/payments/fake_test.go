@@ -0,0 +1,103 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+var validCard = Card{Number: CardApproved, ExpMonth: 12, ExpYear: 2099, CVC: "123"}
+
+func TestFake_AuthorizeCaptureRefund(t *testing.T) {
+	f := NewFake()
+	var events []string
+	f.Subscribe(func(e Event) {
+		events = append(events, e.Type)
+	})
+	ctx := context.Background()
+
+	p, err := f.Authorize(ctx, AuthorizeRequest{Amount: 5000, Card: validCard, Reference: "res-1"})
+	if err != nil || p.Status != StatusAuthorized || p.CardLast4 != "4242" {
+		t.Fatalf("expected an authorized payment but got %v (%v)", p, err)
+	}
+
+	p, err = f.Capture(ctx, p.ID, 0)
+	if err != nil || p.Captured != 5000 {
+		t.Fatalf("expected 5000 captured but got %d (%v)", p.Captured, err)
+	}
+
+	p, err = f.Refund(ctx, p.ID, 2000)
+	if err != nil || p.Status != StatusPartiallyRefunded {
+		t.Fatalf("expected a partial refund but got %s (%v)", p.Status, err)
+	}
+	if _, err := f.Refund(ctx, p.ID, 4000); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("expected refunding more than was captured to fail but got %v", err)
+	}
+	p, err = f.Refund(ctx, p.ID, 0)
+	if err != nil || p.Status != StatusRefunded || p.Refunded != 5000 {
+		t.Errorf("expected the rest to be refunded but got %v (%v)", p, err)
+	}
+
+	expected := []string{"payment.authorized", "payment.captured", "payment.refunded", "payment.refunded"}
+	if len(events) != len(expected) {
+		t.Fatalf("expected events %v but got %v", expected, events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("event %d: expected %s but got %s", i, expected[i], events[i])
+		}
+	}
+}
+
+func TestFake_Declines(t *testing.T) {
+	f := NewFake()
+	ctx := context.Background()
+
+	var tests = []struct {
+		number string
+		code   string
+	}{
+		{CardDeclined, "card_declined"},
+		{CardInsufficientFunds, "insufficient_funds"},
+		{CardExpired, "expired_card"},
+		{CardProcessingError, "processing_error"},
+		{"4242424242424241", "incorrect_number"},
+	}
+	for _, e := range tests {
+		card := validCard
+		card.Number = e.number
+		p, err := f.Authorize(ctx, AuthorizeRequest{Amount: 5000, Card: card})
+
+		var decline *DeclineError
+		if !errors.As(err, &decline) || decline.Code != e.code {
+			t.Errorf("%s: expected a %s decline but got %v", e.number, e.code, err)
+		}
+		if p.Status != StatusDeclined {
+			t.Errorf("%s: expected a declined payment but got %s", e.number, p.Status)
+		}
+	}
+
+	card := validCard
+	card.ExpYear = 2001
+	var decline *DeclineError
+	if _, err := f.Authorize(ctx, AuthorizeRequest{Amount: 5000, Card: card}); !errors.As(err, &decline) {
+		t.Errorf("expected an expired card to be declined but got %v", err)
+	}
+}
+
+func TestFake_Void(t *testing.T) {
+	f := NewFake()
+	ctx := context.Background()
+
+	p, _ := f.Authorize(ctx, AuthorizeRequest{Amount: 5000, Card: validCard})
+	p, err := f.Void(ctx, p.ID)
+	if err != nil || p.Status != StatusVoided {
+		t.Fatalf("expected a voided payment but got %s (%v)", p.Status, err)
+	}
+	if _, err := f.Capture(ctx, p.ID, 0); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("expected capturing a voided payment to fail but got %v", err)
+	}
+	if _, err := f.Void(ctx, "fake_404"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected an unknown payment to be not found but got %v", err)
+	}
+}
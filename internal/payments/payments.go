@@ -0,0 +1,87 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+//Status is where a payment is in its life cycle
+type Status string
+
+const (
+	StatusAuthorized        Status = "authorized"
+	StatusCaptured          Status = "captured"
+	StatusPartiallyRefunded Status = "partially_refunded"
+	StatusRefunded          Status = "refunded"
+	StatusVoided            Status = "voided"
+	StatusDeclined          Status = "declined"
+)
+
+var (
+	//ErrNotFound is returned for a payment id the gateway does not know
+	ErrNotFound = errors.New("payments: payment not found")
+	//ErrInvalidState is returned when an operation does not fit the payment's status, like capturing a voided payment
+	ErrInvalidState = errors.New("payments: operation not allowed in the payment's current status")
+	//ErrInvalidAmount is returned for amounts that are not positive or more than the payment allows
+	ErrInvalidAmount = errors.New("payments: invalid amount")
+)
+
+//DeclineError is returned when the card is declined, Message can be shown to the guest
+type DeclineError struct {
+	Code    string
+	Message string
+}
+
+func (e *DeclineError) Error() string {
+	return e.Message
+}
+
+//Card is the card a payment is taken from
+type Card struct {
+	Number   string
+	ExpMonth int
+	ExpYear  int
+	CVC      string
+}
+
+//AuthorizeRequest asks the gateway to reserve an amount on a card, Reference is our own id for the payment
+type AuthorizeRequest struct {
+	Amount    int //in cents
+	Card      Card
+	Reference string
+}
+
+//Payment is the gateway's view of a payment, all amounts are in cents
+type Payment struct {
+	ID        string
+	Reference string
+	Status    Status
+	Amount    int
+	Captured  int
+	Refunded  int
+	CardLast4 string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+//Event is sent to subscribers whenever a payment changes, like a provider's webhook
+type Event struct {
+	Type    string
+	Payment Payment
+	Created time.Time
+}
+
+//Gateway is a card payment provider
+type Gateway interface {
+	//Name identifies the provider in stored payments
+	Name() string
+	//Authorize reserves the amount on the card without taking it
+	Authorize(ctx context.Context, req AuthorizeRequest) (Payment, error)
+	//Capture takes an authorized amount, zero captures all of it
+	Capture(ctx context.Context, id string, amount int) (Payment, error)
+	//Refund gives back a captured amount, zero refunds whatever has not been refunded yet
+	Refund(ctx context.Context, id string, amount int) (Payment, error)
+	//Void cancels an authorization that has not been captured
+	Void(ctx context.Context, id string) (Payment, error)
+}
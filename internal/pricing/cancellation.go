@@ -0,0 +1,64 @@
+package pricing
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/redblue-blur/bookings/internal/models"
+)
+
+//DescribePolicy explains a cancellation policy to guests
+func DescribePolicy(policy models.CancellationPolicy) string {
+	if policy.ID == 0 {
+		return "Free cancellation until arrival."
+	}
+
+	penalty := "the first night is charged"
+	if policy.PenaltyType == models.PenaltyPercent {
+		penalty = fmt.Sprintf("%d%% of the total is charged", policy.PenaltyPercent)
+	}
+	if policy.FreeDays == 0 {
+		return fmt.Sprintf("Free cancellation until the day of arrival, then %s.", penalty)
+	}
+	days := "days"
+	if policy.FreeDays == 1 {
+		days = "day"
+	}
+	return fmt.Sprintf("Free cancellation until %d %s before arrival, then %s.", policy.FreeDays, days, penalty)
+}
+
+//CancellationFee works out what cancelling the reservation on the given day costs under its policy
+func CancellationFee(policy models.CancellationPolicy, res models.Reservation, today time.Time) int {
+	if policy.ID == 0 {
+		return 0
+	}
+
+	day := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, res.StartDate.Location())
+	daysBefore := int(res.StartDate.Sub(day).Hours() / 24)
+	if daysBefore >= policy.FreeDays {
+		return 0
+	}
+
+	fee := percentOf(res.TotalPrice, policy.PenaltyPercent)
+	if policy.PenaltyType == models.PenaltyFirstNight {
+		fee = firstNight(res)
+	}
+	if fee > res.TotalPrice {
+		fee = res.TotalPrice
+	}
+	return fee
+}
+
+//firstNight is the price of the first night as booked, or an even share of the total for older reservations
+func firstNight(res models.Reservation) int {
+	for _, item := range res.LineItems {
+		if item.Kind == KindNight {
+			return item.Amount
+		}
+	}
+	nights := int(res.EndDate.Sub(res.StartDate).Hours() / 24)
+	if nights < 1 {
+		return res.TotalPrice
+	}
+	return res.TotalPrice / nights
+}
@@ -0,0 +1,133 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redblue-blur/bookings/internal/models"
+)
+
+//Night is the price of one night of a stay
+type Night struct {
+	Date  time.Time
+	Label string
+	Price int
+}
+
+//kinds of price lines, as stored in the reservation's line items
+const (
+	KindNight    = "night"
+	KindDiscount = "discount"
+	KindPromo    = "promo"
+	KindTax      = "tax"
+	KindFee      = "fee"
+)
+
+//Line is an adjustment to the nightly subtotal, negative for discounts
+type Line struct {
+	Kind        string
+	Description string
+	Amount      int
+}
+
+//Breakdown is the itemised price of a stay, all amounts are in cents
+type Breakdown struct {
+	Nights   []Night
+	Subtotal int
+	Lines    []Line
+	Total    int
+	//CancellationPolicyID is the policy of the arrival night's season, or the room's when it has none
+	CancellationPolicyID int
+}
+
+//Rules are the rates that apply to a room on top of its base price
+type Rules struct {
+	Seasons   []models.SeasonalRate
+	Discounts []models.StayDiscount
+}
+
+//Quote prices every night from start up to end for the room and applies the best length of stay discount
+func Quote(room models.Room, start, end time.Time, rules Rules) (Breakdown, error) {
+	var b Breakdown
+
+	if !end.After(start) {
+		return b, errors.New("departure must be after arrival")
+	}
+
+	b.CancellationPolicyID = room.CancellationPolicyID
+	if season, ok := seasonFor(start, rules.Seasons); ok && season.CancellationPolicyID != 0 {
+		b.CancellationPolicyID = season.CancellationPolicyID
+	}
+
+	for night := start; night.Before(end); night = night.AddDate(0, 0, 1) {
+		price, label := room.BasePrice, "Standard rate"
+		if season, ok := seasonFor(night, rules.Seasons); ok {
+			price, label = season.NightlyPrice, season.Name
+		}
+		if isWeekend(night) && room.WeekendPercent != 0 {
+			price += percentOf(price, room.WeekendPercent)
+			label += ", weekend"
+		}
+
+		b.Nights = append(b.Nights, Night{Date: night, Label: label, Price: price})
+		b.Subtotal += price
+	}
+
+	b.Total = b.Subtotal
+	if discount, ok := bestDiscount(len(b.Nights), rules.Discounts); ok {
+		amount := percentOf(b.Subtotal, discount.Percent)
+		b.Lines = append(b.Lines, Line{
+			Kind:        KindDiscount,
+			Description: fmt.Sprintf("%d%% off stays of %d nights or more", discount.Percent, discount.MinNights),
+			Amount:      -amount,
+		})
+		b.Total -= amount
+	}
+	return b, nil
+}
+
+//Deposit is the part of the total taken when the stay is booked
+func (b Breakdown) Deposit(percent int) int {
+	return percentOf(b.Total, percent)
+}
+
+//seasonFor finds the seasonal rate covering a night, the latest starting season wins when they overlap
+func seasonFor(night time.Time, seasons []models.SeasonalRate) (models.SeasonalRate, bool) {
+	var found models.SeasonalRate
+	ok := false
+	for _, season := range seasons {
+		if night.Before(season.StartDate) || !night.Before(season.EndDate) {
+			continue
+		}
+		if !ok || season.StartDate.After(found.StartDate) {
+			found, ok = season, true
+		}
+	}
+	return found, ok
+}
+
+//bestDiscount picks the discount with the highest minimum stay that the number of nights reaches
+func bestDiscount(nights int, discounts []models.StayDiscount) (models.StayDiscount, bool) {
+	var found models.StayDiscount
+	ok := false
+	for _, discount := range discounts {
+		if nights < discount.MinNights {
+			continue
+		}
+		if !ok || discount.MinNights > found.MinNights {
+			found, ok = discount, true
+		}
+	}
+	return found, ok
+}
+
+//isWeekend is true for friday and saturday nights
+func isWeekend(night time.Time) bool {
+	return night.Weekday() == time.Friday || night.Weekday() == time.Saturday
+}
+
+//percentOf returns percent of amount rounded to the nearest cent
+func percentOf(amount, percent int) int {
+	return (amount*percent + 50) / 100
+}
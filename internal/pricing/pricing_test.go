@@ -0,0 +1,288 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/redblue-blur/bookings/internal/models"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+var room = models.Room{ID: 1, BasePrice: 10000, WeekendPercent: 20}
+
+func TestQuote_BaseAndWeekend(t *testing.T) {
+	//wednesday to sunday: wed, thu at base, fri and sat with the weekend differential
+	b, err := Quote(room, date("2050-06-01"), date("2050-06-05"), Rules{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Nights) != 4 {
+		t.Fatalf("expected 4 nights but got %d", len(b.Nights))
+	}
+	expected := []int{10000, 10000, 12000, 12000}
+	for i, night := range b.Nights {
+		if night.Price != expected[i] {
+			t.Errorf("night %d: expected %d but got %d", i, expected[i], night.Price)
+		}
+	}
+	if b.Total != 44000 || b.Subtotal != 44000 {
+		t.Errorf("expected total 44000 but got %d", b.Total)
+	}
+}
+
+func TestQuote_Seasons(t *testing.T) {
+	rules := Rules{
+		Seasons: []models.SeasonalRate{
+			{Name: "Summer", StartDate: date("2050-06-01"), EndDate: date("2050-09-01"), NightlyPrice: 15000},
+			{Name: "Festival", StartDate: date("2050-06-02"), EndDate: date("2050-06-03"), NightlyPrice: 30000},
+		},
+	}
+
+	b, err := Quote(room, date("2050-05-31"), date("2050-06-03"), rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		price int
+		label string
+	}{
+		{10000, "Standard rate"},
+		{15000, "Summer"},
+		{30000, "Festival"},
+	}
+	for i, night := range b.Nights {
+		if night.Price != expected[i].price || night.Label != expected[i].label {
+			t.Errorf("night %d: expected %d %s but got %d %s", i, expected[i].price, expected[i].label, night.Price, night.Label)
+		}
+	}
+}
+
+func TestQuote_StayDiscount(t *testing.T) {
+	rules := Rules{
+		Discounts: []models.StayDiscount{
+			{MinNights: 3, Percent: 5},
+			{MinNights: 7, Percent: 10},
+			{MinNights: 14, Percent: 15},
+		},
+	}
+
+	b, err := Quote(models.Room{BasePrice: 10000}, date("2050-06-01"), date("2050-06-08"), rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Lines) != 1 || b.Lines[0].Amount != -7000 {
+		t.Fatalf("expected one 10%% discount of -7000 but got %+v", b.Lines)
+	}
+	if b.Total != 63000 {
+		t.Errorf("expected total 63000 but got %d", b.Total)
+	}
+}
+
+func TestQuote_BadDates(t *testing.T) {
+	if _, err := Quote(room, date("2050-06-02"), date("2050-06-02"), Rules{}); err == nil {
+		t.Error("quoted a stay with no nights")
+	}
+}
+
+func TestCheckPromo(t *testing.T) {
+	code := models.PromoCode{
+		Code:      "SPRING",
+		ValidFrom: date("2050-03-01"),
+		ValidTo:   date("2050-05-31"),
+		MinNights: 2,
+		MaxUses:   10,
+		TimesUsed: 3,
+		RoomIDs:   []int{1},
+	}
+
+	tests := []struct {
+		name   string
+		roomID int
+		start  string
+		end    string
+		today  string
+		ok     bool
+	}{
+		{"valid", 1, "2050-06-01", "2050-06-03", "2050-04-01", true},
+		{"last day", 1, "2050-06-01", "2050-06-03", "2050-05-31", true},
+		{"not yet", 1, "2050-06-01", "2050-06-03", "2050-02-28", false},
+		{"expired", 1, "2050-06-01", "2050-06-03", "2050-06-01", false},
+		{"too short", 1, "2050-06-01", "2050-06-02", "2050-04-01", false},
+		{"wrong room", 2, "2050-06-01", "2050-06-03", "2050-04-01", false},
+	}
+	for _, e := range tests {
+		err := CheckPromo(code, e.roomID, date(e.start), date(e.end), date(e.today))
+		if (err == nil) != e.ok {
+			t.Errorf("%s: expected ok %t but got %v", e.name, e.ok, err)
+		}
+	}
+
+	code.TimesUsed = 10
+	if CheckPromo(code, 1, date("2050-06-01"), date("2050-06-03"), date("2050-04-01")) == nil {
+		t.Error("accepted a fully redeemed code")
+	}
+}
+
+func TestCheckStay(t *testing.T) {
+	rules := []models.StayRule{
+		//friday and saturday nights need two nights all summer
+		{StartDate: date("2050-06-01"), EndDate: date("2050-09-01"), Weekdays: 1<<uint(time.Friday) | 1<<uint(time.Saturday), MinNights: 2},
+		{StartDate: date("2050-06-01"), EndDate: date("2050-09-01"), MaxNights: 14},
+		//no changeovers on the public holiday
+		{StartDate: date("2050-07-04"), EndDate: date("2050-07-05"), ClosedToArrival: true, ClosedToDeparture: true},
+	}
+
+	tests := []struct {
+		name      string
+		start     string
+		end       string
+		arrival   bool
+		departure bool
+	}{
+		{"weekday night", "2050-06-01", "2050-06-02", true, true},
+		{"weekend", "2050-06-03", "2050-06-05", true, true},
+		{"saturday night alone", "2050-06-04", "2050-06-05", true, false},
+		{"thursday to saturday", "2050-06-02", "2050-06-04", true, true},
+		{"too long", "2050-06-01", "2050-06-16", true, false},
+		{"before the rules", "2050-05-27", "2050-05-28", true, true},
+		{"arriving on the holiday", "2050-07-04", "2050-07-06", false, true},
+		{"leaving on the holiday", "2050-07-01", "2050-07-04", true, false},
+		{"staying over the holiday", "2050-07-03", "2050-07-06", true, true},
+	}
+	for _, e := range tests {
+		var stayErr StayError
+		if err := CheckStay(rules, date(e.start), date(e.end)); err != nil {
+			stayErr = *err.(*StayError)
+		}
+		if (stayErr.Arrival == "") != e.arrival || (stayErr.Departure == "") != e.departure {
+			t.Errorf("%s: expected arrival ok %t and departure ok %t but got %+v", e.name, e.arrival, e.departure, stayErr)
+		}
+	}
+}
+
+func TestBreakdown_ApplyPromo(t *testing.T) {
+	b := Breakdown{Subtotal: 20000, Total: 20000}
+	if amount := b.ApplyPromo(models.PromoCode{Code: "TEN", PercentOff: 10}); amount != 2000 {
+		t.Errorf("expected 2000 off but got %d", amount)
+	}
+	if b.Total != 18000 || len(b.Lines) != 1 {
+		t.Errorf("expected a total of 18000 with one line but got %d with %d", b.Total, len(b.Lines))
+	}
+
+	b = Breakdown{Subtotal: 5000, Total: 5000}
+	if amount := b.ApplyPromo(models.PromoCode{Code: "BIG", AmountOff: 8000}); amount != 5000 || b.Total != 0 {
+		t.Errorf("fixed amount was not capped at the total: took %d, left %d", amount, b.Total)
+	}
+}
+
+func TestTaxesInEffect(t *testing.T) {
+	rules := []models.TaxRule{
+		{ID: 1, Kind: models.TaxOccupancy, Amount: 10, EffectiveFrom: date("2050-01-01")},
+		{ID: 2, Kind: models.TaxOccupancy, Amount: 12, EffectiveFrom: date("2050-07-01")},
+		{ID: 3, Kind: models.TaxCleaning, Amount: 3000, EffectiveFrom: date("2050-01-01")},
+		{ID: 4, Kind: models.TaxCity, Amount: 200, EffectiveFrom: date("2051-01-01")},
+	}
+
+	inEffect := TaxesInEffect(rules, date("2050-06-30"))
+	if len(inEffect) != 2 || inEffect[0].ID != 1 || inEffect[1].ID != 3 {
+		t.Errorf("before the change: expected rules 1 and 3 but got %v", inEffect)
+	}
+
+	inEffect = TaxesInEffect(rules, date("2050-07-01"))
+	if len(inEffect) != 2 || inEffect[0].ID != 2 {
+		t.Errorf("after the change: expected the 12%% occupancy tax but got %v", inEffect)
+	}
+}
+
+func TestBreakdown_ApplyTaxes(t *testing.T) {
+	b, _ := Quote(models.Room{BasePrice: 10000}, date("2050-06-01"), date("2050-06-03"), Rules{})
+	b.ApplyPromo(models.PromoCode{Code: "TEN", PercentOff: 10})
+
+	b.ApplyTaxes([]models.TaxRule{
+		{Kind: models.TaxOccupancy, Name: "Occupancy tax", Amount: 10},
+		{Kind: models.TaxCleaning, Name: "Cleaning fee", Amount: 3000},
+		{Kind: models.TaxCity, Name: "City tax", Amount: 250},
+	}, 2)
+
+	//18000 after the promo, 1800 occupancy tax, 3000 cleaning and 4 guest nights of city tax
+	if b.Total != 18000+1800+3000+1000 {
+		t.Errorf("expected a total of %d but got %d", 18000+1800+3000+1000, b.Total)
+	}
+	if len(b.Lines) != 4 {
+		t.Errorf("expected 4 lines but got %d", len(b.Lines))
+	}
+}
+
+func TestFromLineItems(t *testing.T) {
+	b, _ := Quote(room, date("2050-06-01"), date("2050-06-10"), Rules{
+		Discounts: []models.StayDiscount{{MinNights: 7, Percent: 10}},
+	})
+	b.ApplyTaxes([]models.TaxRule{{Kind: models.TaxCleaning, Name: "Cleaning fee", Amount: 3000}}, 1)
+
+	rebuilt := FromLineItems(b.LineItems())
+	if rebuilt.Total != b.Total || rebuilt.Subtotal != b.Subtotal {
+		t.Errorf("expected %d/%d but rebuilt %d/%d", b.Subtotal, b.Total, rebuilt.Subtotal, rebuilt.Total)
+	}
+	if len(rebuilt.Nights) != len(b.Nights) || len(rebuilt.Lines) != len(b.Lines) {
+		t.Errorf("expected %d nights and %d lines but rebuilt %d and %d",
+			len(b.Nights), len(b.Lines), len(rebuilt.Nights), len(rebuilt.Lines))
+	}
+	if !rebuilt.Nights[4].Date.Equal(b.Nights[4].Date) || rebuilt.Lines[1].Kind != KindFee {
+		t.Error("rebuilt breakdown does not match the original lines")
+	}
+}
+
+func TestQuote_CancellationPolicy(t *testing.T) {
+	room := models.Room{BasePrice: 10000, CancellationPolicyID: 1}
+	rules := Rules{
+		Seasons: []models.SeasonalRate{
+			{Name: "Summer", StartDate: date("2050-06-01"), EndDate: date("2050-09-01"), NightlyPrice: 15000, CancellationPolicyID: 2},
+		},
+	}
+
+	b, _ := Quote(room, date("2050-05-30"), date("2050-06-02"), rules)
+	if b.CancellationPolicyID != 1 {
+		t.Errorf("arriving before the season: expected the room's policy but got %d", b.CancellationPolicyID)
+	}
+	b, _ = Quote(room, date("2050-06-01"), date("2050-06-03"), rules)
+	if b.CancellationPolicyID != 2 {
+		t.Errorf("arriving in the season: expected the season's policy but got %d", b.CancellationPolicyID)
+	}
+}
+
+func TestCancellationFee(t *testing.T) {
+	res := models.Reservation{
+		StartDate:  date("2050-06-10"),
+		EndDate:    date("2050-06-13"),
+		TotalPrice: 30000,
+		LineItems: []models.LineItem{
+			{Kind: KindNight, Amount: 9000},
+			{Kind: KindNight, Amount: 10000},
+			{Kind: KindNight, Amount: 11000},
+		},
+	}
+	firstNight := models.CancellationPolicy{ID: 1, FreeDays: 1, PenaltyType: models.PenaltyFirstNight}
+	half := models.CancellationPolicy{ID: 2, FreeDays: 7, PenaltyType: models.PenaltyPercent, PenaltyPercent: 50}
+
+	var tests = []struct {
+		name     string
+		policy   models.CancellationPolicy
+		today    time.Time
+		expected int
+	}{
+		{"no policy", models.CancellationPolicy{}, date("2050-06-10"), 0},
+		{"first night, in time", firstNight, date("2050-06-09"), 0},
+		{"first night, late", firstNight, time.Date(2050, 6, 10, 8, 0, 0, 0, time.UTC), 9000},
+		{"half, in time", half, date("2050-06-03"), 0},
+		{"half, late", half, date("2050-06-04"), 15000},
+	}
+	for _, e := range tests {
+		if fee := CancellationFee(e.policy, res, e.today); fee != e.expected {
+			t.Errorf("%s: expected a fee of %d but got %d", e.name, e.expected, fee)
+		}
+	}
+}
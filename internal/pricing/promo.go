@@ -0,0 +1,55 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redblue-blur/bookings/internal/models"
+)
+
+//CheckPromo returns why the promo code cannot be used for the stay, or nil when it can
+func CheckPromo(code models.PromoCode, roomID int, start, end, today time.Time) error {
+	if today.Before(code.ValidFrom) {
+		return errors.New("This promo code is not valid yet")
+	}
+	if today.After(code.ValidTo) {
+		return errors.New("This promo code has expired")
+	}
+	if nights := int(end.Sub(start).Hours() / 24); nights < code.MinNights {
+		return fmt.Errorf("This promo code needs a stay of at least %d nights", code.MinNights)
+	}
+	if len(code.RoomIDs) > 0 && !containsInt(code.RoomIDs, roomID) {
+		return errors.New("This promo code cannot be used for this room")
+	}
+	if code.MaxUses > 0 && code.TimesUsed >= code.MaxUses {
+		return errors.New("This promo code has been fully redeemed")
+	}
+	return nil
+}
+
+//ApplyPromo takes the promo code off the total and returns the amount it took off
+func (b *Breakdown) ApplyPromo(code models.PromoCode) int {
+	amount := code.AmountOff
+	description := fmt.Sprintf("Promo code %s", code.Code)
+	if code.PercentOff != 0 {
+		amount = percentOf(b.Total, code.PercentOff)
+		description = fmt.Sprintf("Promo code %s, %d%% off", code.Code, code.PercentOff)
+	}
+	if amount > b.Total {
+		amount = b.Total
+	}
+
+	b.Lines = append(b.Lines, Line{Kind: KindPromo, Description: description, Amount: -amount})
+	b.Total -= amount
+	return amount
+}
+
+func containsInt(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
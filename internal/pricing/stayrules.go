@@ -0,0 +1,62 @@
+package pricing
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/redblue-blur/bookings/internal/models"
+)
+
+//dayLayout is how stay rule errors write the day they are about
+const dayLayout = "Monday 02-01-2006"
+
+//StayError is why a stay breaks a room's stay rules, as a message about its arrival, its departure or both
+type StayError struct {
+	Arrival   string
+	Departure string
+}
+
+func (e *StayError) Error() string {
+	if e.Arrival != "" {
+		return e.Arrival
+	}
+	return e.Departure
+}
+
+//CheckStay returns a *StayError when the stay from start to end breaks one of the room's stay rules, or nil
+//when it can be booked
+func CheckStay(rules []models.StayRule, start, end time.Time) error {
+	var e StayError
+	nights := int(end.Sub(start).Hours() / 24)
+
+	for _, rule := range rules {
+		if e.Arrival == "" && rule.ClosedToArrival && rule.AppliesOn(start) {
+			e.Arrival = fmt.Sprintf("Arrivals aren't possible on %s", start.Format(dayLayout))
+		}
+		if e.Departure == "" && rule.ClosedToDeparture && rule.AppliesOn(end) {
+			e.Departure = fmt.Sprintf("Departures aren't possible on %s", end.Format(dayLayout))
+		}
+	}
+
+	//length of stay rules apply to every stay that includes one of their nights
+	for night := start; night.Before(end) && e.Departure == ""; night = night.AddDate(0, 0, 1) {
+		for _, rule := range rules {
+			if !rule.AppliesOn(night) {
+				continue
+			}
+			if rule.MinNights > 0 && nights < rule.MinNights {
+				e.Departure = fmt.Sprintf("Stays that include the night of %s must be at least %d nights", night.Format(dayLayout), rule.MinNights)
+				break
+			}
+			if rule.MaxNights > 0 && nights > rule.MaxNights {
+				e.Departure = fmt.Sprintf("Stays that include the night of %s can be at most %d nights", night.Format(dayLayout), rule.MaxNights)
+				break
+			}
+		}
+	}
+
+	if e.Arrival == "" && e.Departure == "" {
+		return nil
+	}
+	return &e
+}
@@ -0,0 +1,106 @@
+package pricing
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/redblue-blur/bookings/internal/models"
+)
+
+//TaxesInEffect picks the latest rule of each kind that took effect on or before the given day
+func TaxesInEffect(rules []models.TaxRule, on time.Time) []models.TaxRule {
+	var inEffect []models.TaxRule
+	byKind := map[string]int{}
+	for _, rule := range rules {
+		if rule.EffectiveFrom.After(on) {
+			continue
+		}
+		i, ok := byKind[rule.Kind]
+		if !ok {
+			byKind[rule.Kind] = len(inEffect)
+			inEffect = append(inEffect, rule)
+			continue
+		}
+		if rule.EffectiveFrom.After(inEffect[i].EffectiveFrom) {
+			inEffect[i] = rule
+		}
+	}
+	return inEffect
+}
+
+//ApplyTaxes adds the taxes and fees to the total, the occupancy tax is charged on the room after discounts
+func (b *Breakdown) ApplyTaxes(rules []models.TaxRule, guests int) {
+	if guests < 1 {
+		guests = 1
+	}
+	roomCharge := b.Total
+
+	for _, rule := range rules {
+		var line Line
+		switch rule.Kind {
+		case models.TaxOccupancy:
+			line = Line{
+				Kind:        KindTax,
+				Description: fmt.Sprintf("%s, %d%%", rule.Name, rule.Amount),
+				Amount:      percentOf(roomCharge, rule.Amount),
+			}
+		case models.TaxCleaning:
+			line = Line{
+				Kind:        KindFee,
+				Description: rule.Name,
+				Amount:      rule.Amount,
+			}
+		case models.TaxCity:
+			line = Line{
+				Kind:        KindTax,
+				Description: fmt.Sprintf("%s, %d guest nights", rule.Name, guests*len(b.Nights)),
+				Amount:      rule.Amount * guests * len(b.Nights),
+			}
+		default:
+			continue
+		}
+		if line.Amount == 0 {
+			continue
+		}
+		b.Lines = append(b.Lines, line)
+		b.Total += line.Amount
+	}
+}
+
+//LineItems turns the breakdown into the line items stored with a reservation
+func (b Breakdown) LineItems() []models.LineItem {
+	items := make([]models.LineItem, 0, len(b.Nights)+len(b.Lines))
+	for _, night := range b.Nights {
+		items = append(items, models.LineItem{
+			Position:    len(items) + 1,
+			Kind:        KindNight,
+			Description: night.Label,
+			Night:       night.Date,
+			Amount:      night.Price,
+		})
+	}
+	for _, line := range b.Lines {
+		items = append(items, models.LineItem{
+			Position:    len(items) + 1,
+			Kind:        line.Kind,
+			Description: line.Description,
+			Amount:      line.Amount,
+		})
+	}
+	return items
+}
+
+//FromLineItems rebuilds the breakdown a reservation was booked with from its stored line items
+func FromLineItems(items []models.LineItem) Breakdown {
+	var b Breakdown
+	for _, item := range items {
+		if item.Kind == KindNight {
+			b.Nights = append(b.Nights, Night{Date: item.Night, Label: item.Description, Price: item.Amount})
+			b.Subtotal += item.Amount
+		} else {
+			b.Lines = append(b.Lines, Line{Kind: item.Kind, Description: item.Description, Amount: item.Amount})
+		}
+		b.Total += item.Amount
+	}
+	return b
+}
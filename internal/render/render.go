@@ -8,13 +8,19 @@ import (
 	"log"
 	"net/http"
 	"path/filepath"
+	"time"
 
 	"github.com/justinas/nosurf"
 	"github.com/redblue-blur/bookings/internal/config"
+	"github.com/redblue-blur/bookings/internal/helpers"
 	"github.com/redblue-blur/bookings/internal/models"
 )
 
-var functions = template.FuncMap{}
+var functions = template.FuncMap{
+	"humanDate":   HumanDate,
+	"formatMoney": FormatMoney,
+	"can":         Can,
+}
 var app *config.AppConfig
 var pathToTemplates = "./templates"
 
@@ -23,14 +29,44 @@ func NewRenderer(a *config.AppConfig) {
 	app = a
 }
 
+//HumanDate formats a date the way the date pickers expect it
+func HumanDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("02-01-2006")
+}
+
+//FormatMoney formats an amount in cents for display
+func FormatMoney(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s$%d.%02d", sign, cents/100, cents%100)
+}
+
 func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateData {
 	td.Flash = app.Session.PopString(r.Context(), "flash")
 	td.Error = app.Session.PopString(r.Context(), "error")
 	td.Warning = app.Session.PopString(r.Context(), "warning")
 	td.CSRFToken = nosurf.Token(r)
+	td.IsAuthenticated = app.Session.Exists(r.Context(), "user_id")
+	td.User = helpers.UserFrom(r.Context())
+	//pages about another property's records, like admin pages, pass that property themselves
+	if site := helpers.SiteFrom(r.Context()); td.Property.ID == 0 {
+		td.Property = site.Property
+		td.BasePath = site.Prefix
+	}
 	return td
 }
 
+//Can reports whether the user's role has the permission named, for showing parts of a page by role
+func Can(user models.User, permission string) bool {
+	return user.Can(models.Permission(permission))
+}
+
 //RenderTemplate renders templates
 func Template(w http.ResponseWriter, r *http.Request, html string, td *models.TemplateData) error {
 	var tc map[string]*template.Template
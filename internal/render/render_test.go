@@ -62,3 +62,17 @@ func TestCreateTemplateCache(t *testing.T) {
 		t.Error(err)
 	}
 }
+func TestFormatMoney(t *testing.T) {
+	tests := map[int]string{
+		0:      "$0.00",
+		8900:   "$89.00",
+		12345:  "$123.45",
+		-1005:  "-$10.05",
+		100001: "$1000.01",
+	}
+	for cents, expected := range tests {
+		if got := FormatMoney(cents); got != expected {
+			t.Errorf("FormatMoney(%d): expected %s but got %s", cents, expected, got)
+		}
+	}
+}
@@ -1,9 +1,18 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"golang.org/x/crypto/bcrypt"
 
 	"github.com/redblue-blur/bookings/internal/config"
+	"github.com/redblue-blur/bookings/internal/models"
 	"github.com/redblue-blur/bookings/internal/repository"
 )
 
@@ -18,3 +27,181 @@ func NewPostgresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo
 		DB:  conn,
 	}
 }
+
+//defaultDBTimeout is used when the app config does not set one
+const defaultDBTimeout = 3 * time.Second
+
+//withTimeout bounds a query by the configured database timeout
+func (m *postgresDBRepo) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := m.App.DBTimeout
+	if timeout == 0 {
+		timeout = defaultDBTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+//timeoutError wraps err in a repository.TimeoutError when the query ran out of time
+func timeoutError(ctx context.Context, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &repository.TimeoutError{Err: err}
+	}
+	return err
+}
+
+//nullInt maps a zero id to NULL so optional foreign keys can be left empty
+func nullInt(i int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(i), Valid: i != 0}
+}
+
+//nullString maps an empty string to NULL for optional text columns
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+//nullTime maps a zero time to NULL for optional date columns
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+//exclusionViolation is the postgres error code raised by room_restrictions_no_overlap
+const exclusionViolation = "23P01"
+
+//conflictError turns a violation of the no overlap constraint into a repository.ConflictError
+func conflictError(err error, roomID int, start, end time.Time) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == exclusionViolation {
+		return &repository.ConflictError{
+			RoomID:    roomID,
+			StartDate: start,
+			EndDate:   end,
+		}
+	}
+	return err
+}
+
+//calendarChanges compares the restrictions imported from a room calendar with the events now in it, it
+//returns the ids of the restrictions to remove, the events that need a restriction and which of those
+//events only moved
+func calendarChanges(existing []models.RoomRestriction, events []models.ExternalEvent) ([]int, []models.ExternalEvent, map[string]bool) {
+	current := make(map[string]models.RoomRestriction, len(existing))
+	for _, rr := range existing {
+		current[rr.ExternalUID] = rr
+	}
+
+	var remove []int
+	var add []models.ExternalEvent
+	moved := make(map[string]bool)
+	seen := make(map[string]bool, len(events))
+	for _, e := range events {
+		//a uid repeated in the calendar is only imported once
+		if seen[e.UID] {
+			continue
+		}
+		seen[e.UID] = true
+
+		rr, ok := current[e.UID]
+		if !ok {
+			add = append(add, e)
+		} else if !rr.StartDate.Equal(e.StartDate) || !rr.EndDate.Equal(e.EndDate) {
+			remove = append(remove, rr.ID)
+			add = append(add, e)
+			moved[e.UID] = true
+		}
+	}
+	for _, rr := range existing {
+		if !seen[rr.ExternalUID] {
+			remove = append(remove, rr.ID)
+		}
+	}
+	return remove, add, moved
+}
+
+//ownerBlockRanges applies the changes to one room's owner blocks and returns the blocks that cover the
+//blocked nights afterwards, adjacent nights are merged into a single block
+func ownerBlockRanges(blocks []models.RoomRestriction, changes []models.OwnerBlockChange) []models.RoomRestriction {
+	//nights are keyed in UTC so equal dates are equal keys whatever location they came with
+	nights := make(map[time.Time]bool)
+	for _, rr := range blocks {
+		for night := rr.StartDate; night.Before(rr.EndDate); night = night.AddDate(0, 0, 1) {
+			nights[night.UTC()] = true
+		}
+	}
+	for _, c := range changes {
+		if c.Block {
+			nights[c.Night.UTC()] = true
+		} else {
+			delete(nights, c.Night.UTC())
+		}
+	}
+
+	sorted := make([]time.Time, 0, len(nights))
+	for night := range nights {
+		sorted = append(sorted, night)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Before(sorted[j])
+	})
+
+	var ranges []models.RoomRestriction
+	for _, night := range sorted {
+		if n := len(ranges); n > 0 && ranges[n-1].EndDate.Equal(night) {
+			ranges[n-1].EndDate = night.AddDate(0, 0, 1)
+			continue
+		}
+		ranges = append(ranges, models.RoomRestriction{
+			StartDate:     night,
+			EndDate:       night.AddDate(0, 0, 1),
+			RestrictionID: models.RestrictionOwnerBlock,
+		})
+	}
+	return ranges
+}
+
+//changesByRoom groups owner block changes by room, keeping the order the rooms first appear in
+func changesByRoom(changes []models.OwnerBlockChange) ([]int, map[int][]models.OwnerBlockChange) {
+	var rooms []int
+	byRoom := make(map[int][]models.OwnerBlockChange)
+	for _, c := range changes {
+		if _, ok := byRoom[c.RoomID]; !ok {
+			rooms = append(rooms, c.RoomID)
+		}
+		byRoom[c.RoomID] = append(byRoom[c.RoomID], c)
+	}
+	return rooms, byRoom
+}
+
+//nightSpan is the first night changed and the day after the last one, blocks touching this span are merged
+func nightSpan(changes []models.OwnerBlockChange) (time.Time, time.Time) {
+	first, last := changes[0].Night, changes[0].Night
+	for _, c := range changes {
+		if c.Night.Before(first) {
+			first = c.Night
+		}
+		if c.Night.After(last) {
+			last = c.Night
+		}
+	}
+	return first, last.AddDate(0, 0, 1)
+}
+
+//mergeGuest fills the blank name and phone of the kept profile from a duplicate and appends its notes
+func mergeGuest(keep *models.Guest, merged models.Guest) {
+	if keep.FirstName == "" && keep.LastName == "" {
+		keep.FirstName, keep.LastName = merged.FirstName, merged.LastName
+	}
+	if keep.Phone == "" {
+		keep.Phone = merged.Phone
+	}
+	if merged.Notes != "" {
+		keep.Notes = strings.TrimSpace(keep.Notes + "\n\n" + merged.Notes)
+	}
+}
+
+//checkPassword compares a password with the bcrypt hash stored for a user
+func checkPassword(hash, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return repository.ErrInvalidCredentials
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redblue-blur/bookings/internal/config"
+	"github.com/redblue-blur/bookings/internal/repository"
+)
+
+//stalledConnector opens connections to a database that stopped answering, its queries only end with their context
+type stalledConnector struct{}
+
+func (c stalledConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return stalledConn{}, nil
+}
+func (c stalledConnector) Driver() driver.Driver { return nil }
+
+type stalledConn struct{}
+
+func (stalledConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+func (stalledConn) Close() error { return nil }
+func (stalledConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+func (stalledConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (stalledConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestMemoryRepo_ContextDone(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err := repo.GetRoomByID(expired, 1)
+	var timeout *repository.TimeoutError
+	if !errors.As(err, &timeout) {
+		t.Errorf("expected a timeout error for an expired context but got %v", err)
+	}
+	if err := repo.UpdatePaymentStatus(expired, "fake", "pay_1", "captured"); !errors.As(err, &timeout) {
+		t.Errorf("expected a timeout error for an expired context but got %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = repo.GetRoomByID(cancelled, 1)
+	if !errors.Is(err, context.Canceled) || errors.As(err, &timeout) {
+		t.Errorf("expected the context's error for a cancelled context but got %v", err)
+	}
+}
+
+func TestPostgresRepo_ContextDone(t *testing.T) {
+	db := sql.OpenDB(stalledConnector{})
+	defer db.Close()
+	repo := NewPostgresRepo(db, &config.AppConfig{DBTimeout: 20 * time.Millisecond})
+
+	//the configured timeout ends a query the database does not answer
+	_, err := repo.GetRoomByID(context.Background(), 1)
+	var timeout *repository.TimeoutError
+	if !errors.As(err, &timeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a timeout error once the database timeout passed but got %v", err)
+	}
+	if err := repo.UpdatePaymentStatus(context.Background(), "fake", "pay_1", "captured"); !errors.As(err, &timeout) {
+		t.Errorf("expected a timeout error once the database timeout passed but got %v", err)
+	}
+
+	//so does a deadline of the caller's that is shorter
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if _, err := repo.GetRoomByID(expired, 1); !errors.As(err, &timeout) {
+		t.Errorf("expected a timeout error for an expired context but got %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = repo.GetRoomByID(cancelled, 1)
+	if !errors.Is(err, context.Canceled) || errors.As(err, &timeout) {
+		t.Errorf("expected the context's error for a cancelled context but got %v", err)
+	}
+}
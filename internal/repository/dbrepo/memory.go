@@ -0,0 +1,1970 @@
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redblue-blur/bookings/internal/config"
+	"github.com/redblue-blur/bookings/internal/models"
+	"github.com/redblue-blur/bookings/internal/repository"
+)
+
+//memoryDBRepo keeps everything in memory, for tests and running without postgres
+type memoryDBRepo struct {
+	App *config.AppConfig
+
+	mu               sync.Mutex
+	users            []models.User
+	passwordResets   []passwordReset
+	properties       []models.Property
+	rooms            []models.Room
+	restrictions     []models.Restriction
+	reservations     []models.Reservation
+	bookings         []models.Booking
+	roomRestrictions []models.RoomRestriction
+	seasonalRates    []models.SeasonalRate
+	stayDiscounts    []models.StayDiscount
+	stayRules        []models.StayRule
+	policies         []models.CancellationPolicy
+	promoCodes       []models.PromoCode
+	promoRedemptions []promoRedemption
+	taxRules         []models.TaxRule
+	lineItems        []models.LineItem
+	payments         []models.Payment
+	waitlist         []models.WaitlistEntry
+	roomCalendars    []models.RoomCalendar
+	conflicts        []models.CalendarConflict
+	guests           []models.Guest
+	guestEmails      map[string]int
+	lastIDs          map[string]int
+}
+
+//passwordReset is a password reset with the hash of its token
+type passwordReset struct {
+	models.PasswordReset
+	TokenHash string
+}
+
+//promoRedemption records a promo code used on a reservation
+type promoRedemption struct {
+	ID            int
+	PromoCodeID   int
+	ReservationID int
+	Amount        int
+}
+
+//NewMemoryRepo creates an in-memory repository seeded with the same property and rooms as the database
+func NewMemoryRepo(a *config.AppConfig) repository.DatabaseRepo {
+	now := time.Now()
+	return &memoryDBRepo{
+		App: a,
+		//a user of each role to log in with, the password is "password"
+		users: []models.User{
+			{
+				ID:             1,
+				FirstName:      "Admin",
+				LastName:       "Owner",
+				Email:          "admin@example.com",
+				Password:       "$2a$10$oMeqV.57W9T2UlvvW8BWYenL8lR67YIxk0WSiy2nxWZgeJQ5KIpG2",
+				SessionVersion: 1,
+				AccessLevel:    models.AccessOwner,
+				CreatedAt:      now,
+				UpdatedAt:      now,
+			},
+			{
+				ID:             2,
+				FirstName:      "Mary",
+				LastName:       "Manager",
+				Email:          "manager@example.com",
+				Password:       "$2a$10$oMeqV.57W9T2UlvvW8BWYenL8lR67YIxk0WSiy2nxWZgeJQ5KIpG2",
+				SessionVersion: 1,
+				AccessLevel:    models.AccessManager,
+				CreatedAt:      now,
+				UpdatedAt:      now,
+			},
+			{
+				ID:             3,
+				FirstName:      "Fran",
+				LastName:       "Frontdesk",
+				Email:          "frontdesk@example.com",
+				Password:       "$2a$10$oMeqV.57W9T2UlvvW8BWYenL8lR67YIxk0WSiy2nxWZgeJQ5KIpG2",
+				SessionVersion: 1,
+				AccessLevel:    models.AccessFrontDesk,
+				CreatedAt:      now,
+				UpdatedAt:      now,
+			},
+			{
+				ID:             4,
+				FirstName:      "Harry",
+				LastName:       "Housekeeping",
+				Email:          "housekeeping@example.com",
+				Password:       "$2a$10$oMeqV.57W9T2UlvvW8BWYenL8lR67YIxk0WSiy2nxWZgeJQ5KIpG2",
+				SessionVersion: 1,
+				AccessLevel:    models.AccessHousekeeping,
+				CreatedAt:      now,
+				UpdatedAt:      now,
+			},
+		},
+		properties: []models.Property{
+			{
+				ID:            1,
+				Name:          "Fort Smythe Bed and Breakfast",
+				Slug:          "fort-smythe",
+				Email:         "bookings@localhost",
+				TimeZone:      "UTC",
+				CheckInTime:   "15:00",
+				CheckOutTime:  "11:00",
+				CalendarNames: models.CalendarNamesHidden,
+				CreatedAt:     now,
+				UpdatedAt:     now,
+			},
+		},
+		rooms: []models.Room{
+			{
+				ID:                   1,
+				PropertyID:           1,
+				RoomName:             "General's Quarters",
+				Slug:                 "generals-quarters",
+				RoomType:             "Double",
+				MaxAdults:            2,
+				MaxChildren:          1,
+				BedConfiguration:     "1 queen bed",
+				Description:          "A quiet room overlooking the garden, with a writing desk and a deep bath.",
+				BasePrice:            8900,
+				WeekendPercent:       15,
+				Amenities:            []string{"Wi-Fi", "Bath tub", "Garden view"},
+				CancellationPolicyID: 1,
+				CreatedAt:            now,
+				UpdatedAt:            now,
+			},
+			{
+				ID:                   2,
+				PropertyID:           1,
+				RoomName:             "Major's Suite",
+				Slug:                 "majors-suite",
+				RoomType:             "Suite",
+				MaxAdults:            2,
+				MaxChildren:          2,
+				BedConfiguration:     "1 king bed, 1 sofa bed",
+				Description:          "Our largest suite, with a separate sitting room and a view of the bay.",
+				BasePrice:            12900,
+				WeekendPercent:       15,
+				Amenities:            []string{"Wi-Fi", "Sitting room", "Sea view", "Coffee machine"},
+				CancellationPolicyID: 1,
+				CreatedAt:            now,
+				UpdatedAt:            now,
+			},
+		},
+		restrictions: []models.Restriction{
+			{ID: models.RestrictionReservation, RestrictionName: "Reservation", CreatedAt: now, UpdatedAt: now},
+			{ID: models.RestrictionOwnerBlock, RestrictionName: "Owner block", CreatedAt: now, UpdatedAt: now},
+			{ID: models.RestrictionHold, RestrictionName: "Hold", CreatedAt: now, UpdatedAt: now},
+			{ID: models.RestrictionExternal, RestrictionName: "External", CreatedAt: now, UpdatedAt: now},
+		},
+		stayDiscounts: []models.StayDiscount{
+			{ID: 1, MinNights: 7, Percent: 10, CreatedAt: now, UpdatedAt: now},
+			{ID: 2, MinNights: 14, Percent: 15, CreatedAt: now, UpdatedAt: now},
+		},
+		policies: []models.CancellationPolicy{
+			{ID: 1, Name: "Flexible", FreeDays: 1, PenaltyType: models.PenaltyFirstNight, CreatedAt: now, UpdatedAt: now},
+			{ID: 2, Name: "Moderate", FreeDays: 7, PenaltyType: models.PenaltyPercent, PenaltyPercent: 50, CreatedAt: now, UpdatedAt: now},
+		},
+		promoCodes: []models.PromoCode{
+			{
+				ID:         1,
+				PropertyID: 1,
+				Code:       "WELCOME10",
+				PercentOff: 10,
+				ValidFrom:  time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+				ValidTo:    time.Date(2099, 12, 31, 0, 0, 0, 0, time.UTC),
+				CreatedAt:  now,
+				UpdatedAt:  now,
+			},
+		},
+		guestEmails: make(map[string]int),
+		lastIDs:     map[string]int{"users": 4, "properties": 1, "rooms": 2, "stay_discounts": 2, "cancellation_policies": 2, "promo_codes": 1},
+	}
+}
+
+func (m *memoryDBRepo) AllUsers(ctx context.Context) bool {
+	return true
+}
+
+//Authenticate checks an email and password against the users and returns the id of the user
+func (m *memoryDBRepo) Authenticate(ctx context.Context, email, password string) (int, error) {
+	if err := ctxError(ctx); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if models.NormalizeEmail(u.Email) == models.NormalizeEmail(email) {
+			if err := checkPassword(u.Password, password); err != nil {
+				return 0, err
+			}
+			return u.ID, nil
+		}
+	}
+	return 0, repository.ErrInvalidCredentials
+}
+
+//GetUserByID returns a user, without the password hash
+func (m *memoryDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	return m.findUser(ctx, func(u models.User) bool { return u.ID == id })
+}
+
+//GetUserByEmail returns the user with the email, without the password hash
+func (m *memoryDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	return m.findUser(ctx, func(u models.User) bool {
+		return models.NormalizeEmail(u.Email) == models.NormalizeEmail(email)
+	})
+}
+
+//findUser returns the first user matching fn
+func (m *memoryDBRepo) findUser(ctx context.Context, fn func(u models.User) bool) (models.User, error) {
+	if err := ctxError(ctx); err != nil {
+		return models.User{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if fn(u) {
+			u.Password = ""
+			return u, nil
+		}
+	}
+	return models.User{}, repository.ErrNotFound
+}
+
+//InsertPasswordReset stores the hash of a password reset token sent to a user
+func (m *memoryDBRepo) InsertPasswordReset(ctx context.Context, userID int, tokenHash string, expires time.Time) (int, error) {
+	if err := ctxError(ctx); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	pr := passwordReset{
+		PasswordReset: models.PasswordReset{
+			ID:        m.nextID("password_resets"),
+			UserID:    userID,
+			ExpiresAt: expires,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		TokenHash: tokenHash,
+	}
+	m.passwordResets = append(m.passwordResets, pr)
+	return pr.ID, nil
+}
+
+//GetPasswordResetByToken gets the password reset a link was sent for
+func (m *memoryDBRepo) GetPasswordResetByToken(ctx context.Context, tokenHash string) (models.PasswordReset, error) {
+	if err := ctxError(ctx); err != nil {
+		return models.PasswordReset{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, pr := range m.passwordResets {
+		if pr.TokenHash == tokenHash {
+			return pr.PasswordReset, nil
+		}
+	}
+	return models.PasswordReset{}, repository.ErrNotFound
+}
+
+//ResetPassword uses up a password reset to set the user's new password, moving their session version on so
+//their other sessions are logged out, it fails once the reset has run out or was used
+func (m *memoryDBRepo) ResetPassword(ctx context.Context, resetID int, passwordHash string) error {
+	if err := ctxError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	userID := 0
+	for _, pr := range m.passwordResets {
+		if pr.ID == resetID && pr.UsedAt.IsZero() && pr.ExpiresAt.After(now) {
+			userID = pr.UserID
+		}
+	}
+	if userID == 0 {
+		return repository.ErrResetExpired
+	}
+
+	for i := range m.users {
+		if m.users[i].ID == userID {
+			m.users[i].Password = passwordHash
+			m.users[i].SessionVersion++
+			m.users[i].UpdatedAt = now
+		}
+	}
+	//links sent before this one stop working with the password they were sent for
+	for i := range m.passwordResets {
+		if pr := &m.passwordResets[i]; pr.UserID == userID && pr.UsedAt.IsZero() {
+			pr.UsedAt = now
+			pr.UpdatedAt = now
+		}
+	}
+	return nil
+}
+
+//AllProperties returns every property
+func (m *memoryDBRepo) AllProperties(ctx context.Context) ([]models.Property, error) {
+	if err := ctxError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]models.Property(nil), m.properties...), nil
+}
+
+//GetPropertyByID gets a property by id
+func (m *memoryDBRepo) GetPropertyByID(ctx context.Context, id int) (models.Property, error) {
+	return m.findProperty(ctx, func(p models.Property) bool {
+		return p.ID == id
+	})
+}
+
+//GetPropertyBySlug gets a property by the slug its site is served under
+func (m *memoryDBRepo) GetPropertyBySlug(ctx context.Context, slug string) (models.Property, error) {
+	return m.findProperty(ctx, func(p models.Property) bool {
+		return p.Slug == slug
+	})
+}
+
+//GetPropertyByHostname gets the property served at the root of a host, hostnames are not case sensitive
+func (m *memoryDBRepo) GetPropertyByHostname(ctx context.Context, hostname string) (models.Property, error) {
+	return m.findProperty(ctx, func(p models.Property) bool {
+		return p.Hostname != "" && strings.EqualFold(p.Hostname, hostname)
+	})
+}
+
+//InsertProperty stores a property
+func (m *memoryDBRepo) InsertProperty(ctx context.Context, p models.Property) (int, error) {
+	if err := ctxError(ctx); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkPropertyUnique(p); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	p.ID = m.nextID("properties")
+	p.CreatedAt = now
+	p.UpdatedAt = now
+	m.properties = append(m.properties, p)
+	return p.ID, nil
+}
+
+//UpdateProperty updates a property's settings
+func (m *memoryDBRepo) UpdateProperty(ctx context.Context, p models.Property) error {
+	if err := ctxError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkPropertyUnique(p); err != nil {
+		return err
+	}
+
+	for i, existing := range m.properties {
+		if existing.ID == p.ID {
+			p.CreatedAt = existing.CreatedAt
+			p.UpdatedAt = time.Now()
+			m.properties[i] = p
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+//InsertReservation stores a reservation and the room restriction for its dates
+func (m *memoryDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+	if err := ctxError(ctx); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.findRoom(res.RoomID); !ok {
+		return 0, errors.New("room does not exist")
+	}
+	if !m.roomIsFree(res.RoomID, res.StartDate, res.EndDate) {
+		return 0, &repository.ConflictError{RoomID: res.RoomID, StartDate: res.StartDate, EndDate: res.EndDate}
+	}
+
+	now := time.Now()
+	newID, err := m.addReservation(res, now)
+	if err != nil {
+		return 0, err
+	}
+	res.ID = newID
+
+	m.roomRestrictions = append(m.roomRestrictions, models.RoomRestriction{
+		ID:            m.nextID("room_restrictions"),
+		RoomID:        res.RoomID,
+		StartDate:     res.StartDate,
+		EndDate:       res.EndDate,
+		ReservationID: res.ID,
+		RestrictionID: models.RestrictionReservation,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	})
+	return res.ID, nil
+}
+
+//InsertBooking books every room of a group booking at once, when one of the rooms is taken it fails with a
+//repository.ConflictError for that room and none of them are booked
+func (m *memoryDBRepo) InsertBooking(ctx context.Context, b models.Booking) (int, error) {
+	if err := ctxError(ctx); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, res := range b.Reservations {
+		if _, ok := m.findRoom(res.RoomID); !ok {
+			return 0, errors.New("room does not exist")
+		}
+		if res.PromoCodeID != 0 {
+			return 0, errors.New("promo codes cannot be used on group bookings")
+		}
+		taken := !m.roomIsFree(res.RoomID, res.StartDate, res.EndDate)
+		for _, other := range b.Reservations[:i] {
+			taken = taken || (other.RoomID == res.RoomID && res.StartDate.Before(other.EndDate) && res.EndDate.After(other.StartDate))
+		}
+		if taken {
+			return 0, &repository.ConflictError{RoomID: res.RoomID, StartDate: res.StartDate, EndDate: res.EndDate}
+		}
+	}
+
+	now := time.Now()
+	b.ID = m.nextID("bookings")
+	b.CreatedAt = now
+	b.UpdatedAt = now
+
+	for _, res := range b.Reservations {
+		res.BookingID = b.ID
+		reservationID, err := m.addReservation(res, now)
+		if err != nil {
+			return 0, err
+		}
+		m.roomRestrictions = append(m.roomRestrictions, models.RoomRestriction{
+			ID:            m.nextID("room_restrictions"),
+			RoomID:        res.RoomID,
+			StartDate:     res.StartDate,
+			EndDate:       res.EndDate,
+			ReservationID: reservationID,
+			RestrictionID: models.RestrictionReservation,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		})
+	}
+
+	b.Reservations = nil
+	m.bookings = append(m.bookings, b)
+	return b.ID, nil
+}
+
+//InsertRoomRestriction stores a room restriction
+func (m *memoryDBRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error {
+	if err := ctxError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.findRoom(r.RoomID); !ok {
+		return errors.New("room does not exist")
+	}
+	if !m.roomIsFree(r.RoomID, r.StartDate, r.EndDate) {
+		return &repository.ConflictError{RoomID: r.RoomID, StartDate: r.StartDate, EndDate: r.EndDate}
+	}
+
+	now := time.Now()
+	r.ID = m.nextID("room_restrictions")
+	r.CreatedAt = now
+	r.UpdatedAt = now
+	m.roomRestrictions = append(m.roomRestrictions, r)
+	return nil
+}
+
+//GetReservationByID gets a reservation by id
+func (m *memoryDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	if err := ctxError(ctx); err != nil {
+		return models.Reservation{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, res := range m.reservations {
+		if res.ID == id {
+			return res, nil
+		}
+	}
+	return models.Reservation{}, repository.ErrNotFound
+}
+
+//GetReservationByCode gets a reservation by the code given to the guest
+func (m *memoryDBRepo) GetReservationByCode(ctx context.Context, code string) (models.Reservation, error) {
+	if err := ctxError(ctx); err != nil {
+		return models.Reservation{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, res := range m.reservations {
+		if res.Code == code {
+			return res, nil
+		}
+	}
+	return models.Reservation{}, repository.ErrNotFound
+}
+
+//CancelReservation marks the reservation cancelled with its fee and frees its dates, only one caller can cancel
+//it so only that one goes on to settle its payments
+func (m *memoryDBRepo) CancelReservation(ctx context.Context, id, fee int) error {
+	if err := ctxError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, res := range m.reservations {
+		if res.ID != id {
+			continue
+		}
+		if !res.CancelledAt.IsZero() {
+			return repository.ErrAlreadyCancelled
+		}
+
+		now := time.Now()
+		m.reservations[i].CancelledAt = now
+		m.reservations[i].CancellationFee = fee
+		m.reservations[i].UpdatedAt = now
+
+		m.deleteRoomRestrictions(func(rr models.RoomRestriction) bool {
+			return rr.ReservationID == id
+		})
+		return nil
+	}
+	return repository.ErrNotFound
+}
+
+//SettleCancellation records what settling a cancelled reservation's payments refunded and the error that stopped
+//it, empty once every payment is settled
+func (m *memoryDBRepo) SettleCancellation(ctx context.Context, id, refund int, settlementError string) error {
+	if err := ctxError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, res := range m.reservations {
+		if res.ID == id && !res.CancelledAt.IsZero() {
+			m.reservations[i].RefundAmount = refund
+			m.reservations[i].SettlementError = settlementError
+			m.reservations[i].UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+//SearchAvailabilityByDatesByRoomID returns true if the room has no restriction overlapping the dates
+func (m *memoryDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
+	if err := ctxError(ctx); err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.roomIsFree(roomID, start, end), nil
+}
+
+//SearchAvailabilityForAllRooms returns the property's rooms that are free between start and end
+func (m *memoryDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, propertyID int, start, end time.Time) ([]models.Room, error) {
+	if err := ctxError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rooms []models.Room
+	for _, room := range m.rooms {
+		if room.PropertyID == propertyID && m.roomIsFree(room.ID, start, end) {
+			rooms = append(rooms, copyRoom(room))
+		}
+	}
+	return rooms, nil
+}
+
+//GetRoomByID gets a room by id
+func (m *memoryDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	if err := ctxError(ctx); err != nil {
+		return models.Room{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	room, ok := m.findRoom(id)
+	if !ok {
+		return room, repository.ErrNotFound
+	}
+	return copyRoom(room), nil
+}
+
+//GetRoomBySlug gets one of the property's rooms by the slug used in its page url
+func (m *memoryDBRepo) GetRoomBySlug(ctx context.Context, propertyID int, slug string) (models.Room, error) {
+	if err := ctxError(ctx); err != nil {
+		return models.Room{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, room := range m.rooms {
+		if room.PropertyID == propertyID && room.Slug == slug {
+			return copyRoom(room), nil
+		}
+	}
+	return models.Room{}, repository.ErrNotFound
+}
+
+//AllRooms returns every room of the property
+func (m *memoryDBRepo) AllRooms(ctx context.Context, propertyID int) ([]models.Room, error) {
+	if err := ctxError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rooms []models.Room
+	for _, room := range m.rooms {
+		if room.PropertyID == propertyID {
+			rooms = append(rooms, copyRoom(room))
+		}
+	}
+	return rooms, nil
+}
+
+//InsertRoom stores a room and its amenities
+func (m *memoryDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	if err := ctxError(ctx); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.rooms {
+		if existing.PropertyID == room.PropertyID && existing.Slug == room.Slug {
+			return 0, errors.New("room slug is already in use")
+		}
+	}
+
+	now := time.Now()
+	room = copyRoom(room)
+	room.ID = m.nextID("rooms")
+	room.CreatedAt = now
+	room.UpdatedAt = now
+	m.rooms = append(m.rooms, room)
+	return room.ID, nil
+}
+
+//UpdateRoom updates a room and replaces its amenities
+func (m *memoryDBRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	if err := ctxError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, existing := range m.rooms {
+		if existing.ID == room.ID {
+			room = copyRoom(room)
+			room.CalendarToken = existing.CalendarToken
+			room.CreatedAt = existing.CreatedAt
+			room.UpdatedAt = time.Now()
+			m.rooms[i] = room
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+//DeleteRoom deletes a room along with its reservations and restrictions
+func (m *memoryDBRepo) DeleteRoom(ctx context.Context, id int) error {
+	if err := ctxError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, room := range m.rooms {
+		if room.ID != id {
+			continue
+		}
+		m.rooms = append(m.rooms[:i], m.rooms[i+1:]...)
+
+		m.deleteRoomRestrictions(func(rr models.RoomRestriction) bool {
+			return rr.RoomID == id
+		})
+		kept := m.reservations[:0]
+		for _, res := range m.reservations {
+			if res.RoomID != id {
+				kept = append(kept, res)
+			}
+		}
+		m.reservations = kept
+
+		var calendars []int
+		for _, c := range m.roomCalendars {
+			if c.RoomID == id {
+				calendars = append(calendars, c.ID)
+			}
+		}
+		for _, calendarID := range calendars {
+			m.deleteRoomCalendar(calendarID)
+		}
+
+		rules := m.stayRules[:0]
+		for _, rule := range m.stayRules {
+			if rule.RoomID != id {
+				rules = append(rules, rule)
+			}
+		}
+		m.stayRules = rules
+		return nil
+	}
+	return repository.ErrNotFound
+}
+
+//SeasonalRatesForRoom returns the room's seasonal rates that overlap the dates
+func (m *memoryDBRepo) SeasonalRatesForRoom(ctx context.Context, roomID int, start, end time.Time) ([]models.SeasonalRate, error) {
+	if err := ctxError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rates []models.SeasonalRate
+	for _, rate := range m.seasonalRates {
+		if rate.RoomID == roomID && start.Before(rate.EndDate) && end.After(rate.StartDate) {
+			rates = append(rates, rate)
+		}
+	}
+	return rates, nil
+}
+
+//StayRulesForRoom returns the room's stay rules that can apply to a stay from start to end, that is on
+//its nights or the departure day
+func (m *memoryDBRepo) StayRulesForRoom(ctx context.Context, roomID int, start, end time.Time) ([]models.StayRule, error) {
+	if err := ctxError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rules []models.StayRule
+	for _, rule := range m.stayRules {
+		if rule.RoomID == roomID && start.Before(rule.EndDate) && !end.Before(rule.StartDate) {
+			rules = append(rules, m.withStayRuleRoom(rule))
+		}
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].StartDate.Before(rules[j].StartDate)
+	})
+	return rules, nil
+}
+
+//StayRulesForProperty returns the stay rules of every room of the property
+func (m *memoryDBRepo) StayRulesForProperty(ctx context.Context, propertyID int) ([]models.StayRule, error) {
+	if err := ctxError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rules []models.StayRule
+	for _, rule := range m.stayRules {
+		rule = m.withStayRuleRoom(rule)
+		if rule.Room.PropertyID == propertyID {
+			rules = append(rules, rule)
+		}
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].RoomID != rules[j].RoomID {
+			return rules[i].RoomID < rules[j].RoomID
+		}
+		return rules[i].StartDate.Before(rules[j].StartDate)
+	})
+	return rules, nil
+}
+
+//InsertStayRule adds a stay rule to a room
+func (m *memoryDBRepo) InsertStayRule(ctx context.Context, rule models.StayRule) (int, error) {
+	if err := ctxError(ctx); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	rule.ID = m.nextID("stay_rules")
+	rule.Room = models.Room{}
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+	m.stayRules = append(m.stayRules, rule)
+	return rule.ID, nil
+}
+
+//DeleteStayRule removes a stay rule
+func (m *memoryDBRepo) DeleteStayRule(ctx context.Context, id int) error {
+	if err := ctxError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, rule := range m.stayRules {
+		if rule.ID == id {
+			m.stayRules = append(m.stayRules[:i], m.stayRules[i+1:]...)
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+//withStayRuleRoom fills in the room a stay rule belongs to the way the postgres join does
+func (m *memoryDBRepo) withStayRuleRoom(rule models.StayRule) models.StayRule {
+	room, _ := m.findRoom(rule.RoomID)
+	rule.Room = models.Room{ID: room.ID, PropertyID: room.PropertyID, RoomName: room.RoomName}
+	return rule
+}
+
+//StayDiscountsForRoom returns the length of stay discounts for the room and those for every room
+func (m *memoryDBRepo) StayDiscountsForRoom(ctx context.Context, roomID int) ([]models.StayDiscount, error) {
+	if err := ctxError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var discounts []models.StayDiscount
+	for _, discount := range m.stayDiscounts {
+		if discount.RoomID == roomID || discount.RoomID == 0 {
+			discounts = append(discounts, discount)
+		}
+	}
+	return discounts, nil
+}
+
+//GetCancellationPolicy gets a cancellation policy by id
+func (m *memoryDBRepo) GetCancellationPolicy(ctx context.Context, id int) (models.CancellationPolicy, error) {
+	if err := ctxError(ctx); err != nil {
+		return models.CancellationPolicy{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, policy := range m.policies {
+		if policy.ID == id {
+			return policy, nil
+		}
+	}
+	return models.CancellationPolicy{}, repository.ErrNotFound
+}
+
+//GetPromoCodeByCode gets one of the property's promo codes, codes are not case sensitive
+func (m *memoryDBRepo) GetPromoCodeByCode(ctx context.Context, propertyID int, code string) (models.PromoCode, error) {
+	if err := ctxError(ctx); err != nil {
+		return models.PromoCode{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, promo := range m.promoCodes {
+		if promo.PropertyID == propertyID && strings.EqualFold(promo.Code, code) {
+			promo.RoomIDs = append([]int(nil), promo.RoomIDs...)
+			return promo, nil
+		}
+	}
+	return models.PromoCode{}, repository.ErrNotFound
+}
+
+//AllTaxRules returns every tax and fee rule of the property, including the ones that have been replaced
+func (m *memoryDBRepo) AllTaxRules(ctx context.Context, propertyID int) ([]models.TaxRule, error) {
+	if err := ctxError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rules []models.TaxRule
+	for _, rule := range m.taxRules {
+		if rule.PropertyID == propertyID {
+			rules = append(rules, rule)
+		}
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Kind != rules[j].Kind {
+			return rules[i].Kind < rules[j].Kind
+		}
+		return rules[i].EffectiveFrom.Before(rules[j].EffectiveFrom)
+	})
+	return rules, nil
+}
+
+//InsertTaxRule adds a tax or fee rule, rules are never updated so past reservations keep their rates
+func (m *memoryDBRepo) InsertTaxRule(ctx context.Context, rule models.TaxRule) (int, error) {
+	if err := ctxError(ctx); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	rule.ID = m.nextID("tax_rules")
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+	m.taxRules = append(m.taxRules, rule)
+	return rule.ID, nil
+}
+
+//LineItemsForReservation returns the price lines stored when the reservation was booked
+func (m *memoryDBRepo) LineItemsForReservation(ctx context.Context, reservationID int) ([]models.LineItem, error) {
+	if err := ctxError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var items []models.LineItem
+	for _, item := range m.lineItems {
+		if item.ReservationID == reservationID {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+//PaymentsForReservation returns the payments taken for the reservation
+func (m *memoryDBRepo) PaymentsForReservation(ctx context.Context, reservationID int) ([]models.Payment, error) {
+	if err := ctxError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var payments []models.Payment
+	for _, payment := range m.payments {
+		if payment.ReservationID == reservationID {
+			payments = append(payments, payment)
+		}
+	}
+	return payments, nil
+}
+
+//UpdatePaymentStatus records a status reported by the payment provider, unknown payments are ignored
+func (m *memoryDBRepo) UpdatePaymentStatus(ctx context.Context, provider, providerID, status string) error {
+	if err := ctxError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, payment := range m.payments {
+		if payment.Provider == provider && payment.ProviderID == providerID {
+			m.payments[i].Status = status
+			m.payments[i].UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+//ClaimPaymentSettlement marks a payment settled before a cancellation moves its money, so two requests
+//cancelling at once cannot both charge or refund it
+func (m *memoryDBRepo) ClaimPaymentSettlement(ctx context.Context, id int) error {
+	if err := ctxError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, payment := range m.payments {
+		if payment.ID != id {
+			continue
+		}
+		if !payment.SettledAt.IsZero() {
+			return repository.ErrAlreadySettled
+		}
+		now := time.Now()
+		m.payments[i].SettledAt = now
+		m.payments[i].UpdatedAt = now
+		return nil
+	}
+	return repository.ErrNotFound
+}
+
+//ReleasePaymentSettlement gives back a claim on a payment the provider did not settle, so it can be retried
+func (m *memoryDBRepo) ReleasePaymentSettlement(ctx context.Context, id int) error {
+	if err := ctxError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, payment := range m.payments {
+		if payment.ID == id {
+			m.payments[i].SettledAt = time.Time{}
+			m.payments[i].UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+//InsertWaitlistEntry adds a guest to the waitlist for their dates
+func (m *memoryDBRepo) InsertWaitlistEntry(ctx context.Context, e models.WaitlistEntry) (int, error) {
+	if err := ctxError(ctx); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	e.ID = m.nextID("waitlist_entries")
+	e.Status = models.WaitlistWaiting
+	e.CreatedAt = now
+	e.UpdatedAt = now
+	m.waitlist = append(m.waitlist, e)
+	return e.ID, nil
+}
+
+//WaitlistEntriesForRoom returns the entries of the room's property still waiting for dates overlapping start
+//to end that the room would suit, in the order the guests joined
+func (m *memoryDBRepo) WaitlistEntriesForRoom(ctx context.Context, roomID int, start, end time.Time) ([]models.WaitlistEntry, error) {
+	if err := ctxError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	room, _ := m.findRoom(roomID)
+
+	var entries []models.WaitlistEntry
+	for _, e := range m.waitlist {
+		if e.Status != models.WaitlistWaiting || e.PropertyID != room.PropertyID || (e.RoomID != roomID && e.RoomID != 0) {
+			continue
+		}
+		if start.Before(e.EndDate) && end.After(e.StartDate) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+//OfferWaitlistEntry gives a waiting entry a claim on the room until expires, with the hold keeping it
+func (m *memoryDBRepo) OfferWaitlistEntry(ctx context.Context, id, roomID, holdID int, tokenHash string, expires time.Time) error {
+	if err := ctxError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, e := range m.waitlist {
+		if e.ID == id && e.Status == models.WaitlistWaiting {
+			m.waitlist[i].Status = models.WaitlistOffered
+			m.waitlist[i].ClaimRoomID = roomID
+			m.waitlist[i].ClaimHoldID = holdID
+			m.waitlist[i].ClaimTokenHash = tokenHash
+			m.waitlist[i].ClaimExpiresAt = expires
+			m.waitlist[i].UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+//GetWaitlistEntryByToken gets the entry a claim link was sent to
+func (m *memoryDBRepo) GetWaitlistEntryByToken(ctx context.Context, tokenHash string) (models.WaitlistEntry, error) {
+	if err := ctxError(ctx); err != nil {
+		return models.WaitlistEntry{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range m.waitlist {
+		if e.ClaimTokenHash != "" && e.ClaimTokenHash == tokenHash {
+			return e, nil
+		}
+	}
+	return models.WaitlistEntry{}, repository.ErrNotFound
+}
+
+//ExpireWaitlistOffers marks offers that ran out by now as expired and returns them, along with claimed entries
+//whose hold ran out without being booked
+func (m *memoryDBRepo) ExpireWaitlistOffers(ctx context.Context, now time.Time) ([]models.WaitlistEntry, error) {
+	if err := ctxError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var expired []models.WaitlistEntry
+	for i, e := range m.waitlist {
+		if e.ClaimExpiresAt.After(now) {
+			continue
+		}
+		if e.Status == models.WaitlistOffered || (e.Status == models.WaitlistClaimed && m.isHold(e.ClaimHoldID)) {
+			m.waitlist[i].Status = models.WaitlistExpired
+			m.waitlist[i].UpdatedAt = now
+			expired = append(expired, m.waitlist[i])
+		}
+	}
+	return expired, nil
+}
+
+//InsertHold places a hold on the room for the dates until expires, replacing the other hold of its owner so a
+//session cannot hold more than one room at a time, holds without an owner are released on their own
+func (m *memoryDBRepo) InsertHold(ctx context.Context, owner string, roomID int, start, end, expires time.Time) (int, error) {
+	if err := ctxError(ctx); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.findRoom(roomID); !ok {
+		return 0, errors.New("room does not exist")
+	}
+	now := time.Now()
+	ownHold := func(rr models.RoomRestriction) bool {
+		return owner != "" && rr.RestrictionID == models.RestrictionHold && rr.HoldOwner == owner
+	}
+	for _, rr := range m.roomRestrictions {
+		if rr.RoomID == roomID && start.Before(rr.EndDate) && end.After(rr.StartDate) && !ownHold(rr) && !expiredHold(rr, now) {
+			return 0, &repository.ConflictError{RoomID: roomID, StartDate: start, EndDate: end}
+		}
+	}
+	m.deleteRoomRestrictions(func(rr models.RoomRestriction) bool {
+		return ownHold(rr) || (rr.RoomID == roomID && start.Before(rr.EndDate) && end.After(rr.StartDate) && expiredHold(rr, now))
+	})
+
+	hold := models.RoomRestriction{
+		ID:            m.nextID("room_restrictions"),
+		RoomID:        roomID,
+		StartDate:     start,
+		EndDate:       end,
+		RestrictionID: models.RestrictionHold,
+		HoldOwner:     owner,
+		ExpiresAt:     expires,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	m.roomRestrictions = append(m.roomRestrictions, hold)
+	return hold.ID, nil
+}
+
+//ConvertHold stores the reservation and turns its hold into the reservation's room restriction
+func (m *memoryDBRepo) ConvertHold(ctx context.Context, holdID int, res models.Reservation) (int, error) {
+	if err := ctxError(ctx); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, hold := range m.roomRestrictions {
+		if hold.ID != holdID || hold.RestrictionID != models.RestrictionHold {
+			continue
+		}
+		if !hold.ExpiresAt.After(time.Now()) || hold.RoomID != res.RoomID || !hold.StartDate.Equal(res.StartDate) || !hold.EndDate.Equal(res.EndDate) {
+			return 0, repository.ErrHoldExpired
+		}
+
+		now := time.Now()
+		newID, err := m.addReservation(res, now)
+		if err != nil {
+			return 0, err
+		}
+		res.ID = newID
+
+		m.roomRestrictions[i].RestrictionID = models.RestrictionReservation
+		m.roomRestrictions[i].ReservationID = res.ID
+		m.roomRestrictions[i].HoldOwner = ""
+		m.roomRestrictions[i].ExpiresAt = time.Time{}
+		m.roomRestrictions[i].UpdatedAt = now
+
+		//a hold offered to the waitlist is claimed by booking it
+		for j, e := range m.waitlist {
+			if e.ClaimHoldID == holdID && e.Status == models.WaitlistOffered {
+				m.waitlist[j].Status = models.WaitlistClaimed
+				m.waitlist[j].UpdatedAt = now
+			}
+		}
+		return res.ID, nil
+	}
+	return 0, repository.ErrHoldExpired
+}
+
+//ReleaseHold removes a hold that is no longer needed
+func (m *memoryDBRepo) ReleaseHold(ctx context.Context, holdID int) error {
+	if err := ctxError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deleteRoomRestrictions(func(rr models.RoomRestriction) bool {
+		return rr.ID == holdID && rr.RestrictionID == models.RestrictionHold
+	})
+	return nil
+}
+
+//DeleteExpiredHolds removes holds that ran out by now and returns how many were released
+func (m *memoryDBRepo) DeleteExpiredHolds(ctx context.Context, now time.Time) (int, error) {
+	if err := ctxError(ctx); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.deleteRoomRestrictions(func(rr models.RoomRestriction) bool {
+		return rr.RestrictionID == models.RestrictionHold && !rr.ExpiresAt.After(now)
+	}), nil
+}
+
+//SetRoomCalendarToken replaces the secret in the url of the room's calendar feed
+func (m *memoryDBRepo) SetRoomCalendarToken(ctx context.Context, roomID int, token string) error {
+	if err := ctxError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, room := range m.rooms {
+		if room.ID == roomID {
+			m.rooms[i].CalendarToken = token
+			m.rooms[i].UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+//RoomRestrictionsForRoom returns the room's restrictions that end after the given day, with the guest
+//names of the reservations among them
+func (m *memoryDBRepo) RoomRestrictionsForRoom(ctx context.Context, roomID int, from time.Time) ([]models.RoomRestriction, error) {
+	if err := ctxError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var restrictions []models.RoomRestriction
+	for _, rr := range m.roomRestrictions {
+		if rr.RoomID != roomID || !rr.EndDate.After(from) {
+			continue
+		}
+		for _, res := range m.reservations {
+			if res.ID == rr.ReservationID && rr.ReservationID != 0 {
+				rr.Reservation = models.Reservation{ID: res.ID, FirstName: res.FirstName, LastName: res.LastName}
+			}
+		}
+		restrictions = append(restrictions, rr)
+	}
+	sort.SliceStable(restrictions, func(i, j int) bool {
+		return restrictions[i].StartDate.Before(restrictions[j].StartDate)
+	})
+	return restrictions, nil
+}
+
+//AllRoomCalendars returns the calendars of every room, for syncing them
+func (m *memoryDBRepo) AllRoomCalendars(ctx context.Context) ([]models.RoomCalendar, error) {
+	if err := ctxError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var calendars []models.RoomCalendar
+	for _, c := range m.roomCalendars {
+		calendars = append(calendars, m.withRoom(c))
+	}
+	return calendars, nil
+}
+
+//RoomCalendarsForProperty returns the calendars of the property's rooms with the conflicts of their last sync
+func (m *memoryDBRepo) RoomCalendarsForProperty(ctx context.Context, propertyID int) ([]models.RoomCalendar, error) {
+	if err := ctxError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var calendars []models.RoomCalendar
+	for _, c := range m.roomCalendars {
+		c = m.withRoom(c)
+		if c.Room.PropertyID != propertyID {
+			continue
+		}
+		for _, conflict := range m.conflicts {
+			if conflict.RoomCalendarID == c.ID {
+				c.Conflicts = append(c.Conflicts, conflict)
+			}
+		}
+		calendars = append(calendars, c)
+	}
+	sort.SliceStable(calendars, func(i, j int) bool {
+		return calendars[i].RoomID < calendars[j].RoomID
+	})
+	return calendars, nil
+}
+
+//GetRoomCalendar gets a room calendar by id
+func (m *memoryDBRepo) GetRoomCalendar(ctx context.Context, id int) (models.RoomCalendar, error) {
+	if err := ctxError(ctx); err != nil {
+		return models.RoomCalendar{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, c := range m.roomCalendars {
+		if c.ID == id {
+			return m.withRoom(c), nil
+		}
+	}
+	return models.RoomCalendar{}, repository.ErrNotFound
+}
+
+//InsertRoomCalendar adds a calendar to import into a room
+func (m *memoryDBRepo) InsertRoomCalendar(ctx context.Context, c models.RoomCalendar) (int, error) {
+	if err := ctxError(ctx); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.findRoom(c.RoomID); !ok {
+		return 0, errors.New("room does not exist")
+	}
+
+	now := time.Now()
+	c = models.RoomCalendar{
+		ID:        m.nextID("room_calendars"),
+		RoomID:    c.RoomID,
+		Name:      c.Name,
+		URL:       c.URL,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	m.roomCalendars = append(m.roomCalendars, c)
+	return c.ID, nil
+}
+
+//DeleteRoomCalendar stops importing a calendar and frees the dates its events blocked
+func (m *memoryDBRepo) DeleteRoomCalendar(ctx context.Context, id int) error {
+	if err := ctxError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.deleteRoomCalendar(id) {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+//SyncRoomCalendar makes the calendar's external restrictions match the events now in it all at once,
+//events that overlap anything else on the room are kept as conflicts instead
+func (m *memoryDBRepo) SyncRoomCalendar(ctx context.Context, id int, events []models.ExternalEvent) (models.CalendarSync, error) {
+	var sync models.CalendarSync
+	if err := ctxError(ctx); err != nil {
+		return sync, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	index := -1
+	for i, c := range m.roomCalendars {
+		if c.ID == id {
+			index = i
+		}
+	}
+	if index == -1 {
+		return sync, repository.ErrNotFound
+	}
+	roomID := m.roomCalendars[index].RoomID
+
+	var existing []models.RoomRestriction
+	for _, rr := range m.roomRestrictions {
+		if rr.RoomCalendarID == id {
+			existing = append(existing, rr)
+		}
+	}
+
+	remove, add, moved := calendarChanges(existing, events)
+	sync.Removed = len(remove) - len(moved)
+	removed := make(map[int]bool, len(remove))
+	for _, rrID := range remove {
+		removed[rrID] = true
+	}
+	m.deleteRoomRestrictions(func(rr models.RoomRestriction) bool {
+		return removed[rr.ID]
+	})
+
+	now := time.Now()
+	for _, e := range add {
+		if taken, ok := m.restrictionOverlapping(roomID, e.StartDate, e.EndDate); ok {
+			sync.Conflicts = append(sync.Conflicts, models.CalendarConflict{
+				ID:             m.nextID("calendar_conflicts"),
+				RoomCalendarID: id,
+				UID:            e.UID,
+				Summary:        e.Summary,
+				StartDate:      e.StartDate,
+				EndDate:        e.EndDate,
+				ReservationID:  taken.ReservationID,
+				CreatedAt:      now,
+			})
+			if moved[e.UID] {
+				sync.Removed++
+			}
+			continue
+		}
+
+		m.roomRestrictions = append(m.roomRestrictions, models.RoomRestriction{
+			ID:             m.nextID("room_restrictions"),
+			RoomID:         roomID,
+			StartDate:      e.StartDate,
+			EndDate:        e.EndDate,
+			RestrictionID:  models.RestrictionExternal,
+			RoomCalendarID: id,
+			ExternalUID:    e.UID,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		})
+		if moved[e.UID] {
+			sync.Updated++
+		} else {
+			sync.Added++
+		}
+	}
+
+	kept := m.conflicts[:0]
+	for _, c := range m.conflicts {
+		if c.RoomCalendarID != id {
+			kept = append(kept, c)
+		}
+	}
+	m.conflicts = append(kept, sync.Conflicts...)
+
+	m.roomCalendars[index].LastSyncedAt = now
+	m.roomCalendars[index].LastError = ""
+	m.roomCalendars[index].UpdatedAt = now
+	return sync, nil
+}
+
+//SetRoomCalendarError records why a calendar could not be synced, its restrictions are left as they were
+func (m *memoryDBRepo) SetRoomCalendarError(ctx context.Context, id int, message string) error {
+	if err := ctxError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, c := range m.roomCalendars {
+		if c.ID == id {
+			m.roomCalendars[i].LastError = message
+			m.roomCalendars[i].UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+//UpdateOwnerBlocks blocks and frees nights of rooms for their owners all at once, it fails with a
+//repository.ConflictError without changing anything when a night to block is already taken
+func (m *memoryDBRepo) UpdateOwnerBlocks(ctx context.Context, changes []models.OwnerBlockChange) error {
+	if err := ctxError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	//the changes are worked out on a copy that replaces the room restrictions only when they all apply
+	restrictions := append([]models.RoomRestriction(nil), m.roomRestrictions...)
+	lastID := m.lastIDs["room_restrictions"]
+	now := time.Now()
+
+	rooms, byRoom := changesByRoom(changes)
+	for _, roomID := range rooms {
+		first, end := nightSpan(byRoom[roomID])
+
+		var blocks []models.RoomRestriction
+		kept := restrictions[:0]
+		for _, rr := range restrictions {
+			if rr.RoomID == roomID && rr.RestrictionID == models.RestrictionOwnerBlock &&
+				!rr.StartDate.After(end) && !rr.EndDate.Before(first) {
+				blocks = append(blocks, rr)
+				continue
+			}
+			kept = append(kept, rr)
+		}
+		restrictions = kept
+
+		for _, rr := range ownerBlockRanges(blocks, byRoom[roomID]) {
+			for _, other := range restrictions {
+				if other.RoomID == roomID && rr.StartDate.Before(other.EndDate) && rr.EndDate.After(other.StartDate) {
+					m.lastIDs["room_restrictions"] = lastID
+					return &repository.ConflictError{RoomID: roomID, StartDate: rr.StartDate, EndDate: rr.EndDate}
+				}
+			}
+			rr.ID = m.nextID("room_restrictions")
+			rr.RoomID = roomID
+			rr.CreatedAt = now
+			rr.UpdatedAt = now
+			restrictions = append(restrictions, rr)
+		}
+	}
+
+	m.roomRestrictions = restrictions
+	return nil
+}
+
+//AllGuests returns the guest profiles with search in their name or one of their emails, all of them when
+//search is empty
+func (m *memoryDBRepo) AllGuests(ctx context.Context, search string) ([]models.Guest, error) {
+	if err := ctxError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	search = strings.ToLower(strings.TrimSpace(search))
+
+	var guests []models.Guest
+	for _, g := range m.guests {
+		match := search == "" || strings.Contains(strings.ToLower(g.FirstName+" "+g.LastName), search)
+		for email, id := range m.guestEmails {
+			if id == g.ID && strings.Contains(email, search) {
+				match = true
+			}
+		}
+		if match {
+			g.Stays = m.guestStays(g.ID)
+			guests = append(guests, g)
+		}
+	}
+	sort.SliceStable(guests, func(i, j int) bool {
+		if guests[i].LastName != guests[j].LastName {
+			return guests[i].LastName < guests[j].LastName
+		}
+		return guests[i].FirstName < guests[j].FirstName
+	})
+	return guests, nil
+}
+
+//GetGuest returns a guest profile with its emails and its stay history
+func (m *memoryDBRepo) GetGuest(ctx context.Context, id int) (models.Guest, error) {
+	if err := ctxError(ctx); err != nil {
+		return models.Guest{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.guestIndex(id)
+	if i == -1 {
+		return models.Guest{}, repository.ErrNotFound
+	}
+
+	g := m.guests[i]
+	g.Stays = m.guestStays(id)
+	for email, guestID := range m.guestEmails {
+		if guestID == id {
+			g.Emails = append(g.Emails, email)
+		}
+	}
+	sort.Strings(g.Emails)
+	for _, res := range m.reservations {
+		if res.GuestID == id {
+			res.Room, _ = m.findRoom(res.RoomID)
+			g.Reservations = append(g.Reservations, res)
+		}
+	}
+	sort.SliceStable(g.Reservations, func(i, j int) bool {
+		return g.Reservations[i].StartDate.After(g.Reservations[j].StartDate)
+	})
+	return g, nil
+}
+
+//UpdateGuest saves the name, phone and notes of a guest profile
+func (m *memoryDBRepo) UpdateGuest(ctx context.Context, g models.Guest) error {
+	if err := ctxError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.guestIndex(g.ID)
+	if i == -1 {
+		return repository.ErrNotFound
+	}
+	m.guests[i].FirstName = g.FirstName
+	m.guests[i].LastName = g.LastName
+	m.guests[i].Phone = g.Phone
+	m.guests[i].Notes = g.Notes
+	m.guests[i].UpdatedAt = time.Now()
+	return nil
+}
+
+//MergeGuests combines duplicate guest profiles into the one kept, the kept profile takes over their
+//reservations, emails and notes and the duplicates are deleted
+func (m *memoryDBRepo) MergeGuests(ctx context.Context, keepID int, mergeIDs []int) error {
+	if err := ctxError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	//check every profile first so nothing changes when one is missing
+	for _, id := range append([]int{keepID}, mergeIDs...) {
+		if m.guestIndex(id) == -1 {
+			return repository.ErrNotFound
+		}
+	}
+
+	keep := m.guests[m.guestIndex(keepID)]
+	for _, id := range mergeIDs {
+		if id == keepID {
+			continue
+		}
+		i := m.guestIndex(id)
+		if i == -1 {
+			//listed twice and already merged
+			continue
+		}
+		mergeGuest(&keep, m.guests[i])
+
+		for j := range m.reservations {
+			if m.reservations[j].GuestID == id {
+				m.reservations[j].GuestID = keepID
+			}
+		}
+		for email, guestID := range m.guestEmails {
+			if guestID == id {
+				m.guestEmails[email] = keepID
+			}
+		}
+		m.guests = append(m.guests[:i], m.guests[i+1:]...)
+	}
+
+	keep.UpdatedAt = time.Now()
+	m.guests[m.guestIndex(keepID)] = keep
+	return nil
+}
+
+//guestFor returns the profile for the reservation's email, creating it for a new guest, the caller holds the lock
+func (m *memoryDBRepo) guestFor(res models.Reservation, now time.Time) int {
+	email := models.NormalizeEmail(res.Email)
+	if email == "" {
+		return 0
+	}
+	if id, ok := m.guestEmails[email]; ok {
+		return id
+	}
+
+	g := models.Guest{
+		ID:        m.nextID("guests"),
+		Email:     email,
+		FirstName: res.FirstName,
+		LastName:  res.LastName,
+		Phone:     res.Phone,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	m.guests = append(m.guests, g)
+	m.guestEmails[email] = g.ID
+	return g.ID
+}
+
+//guestIndex returns the index of the guest profile with the id, or -1, the caller holds the lock
+func (m *memoryDBRepo) guestIndex(id int) int {
+	for i, g := range m.guests {
+		if g.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+//guestStays counts the reservations of a guest that were not cancelled, the caller holds the lock
+func (m *memoryDBRepo) guestStays(id int) int {
+	stays := 0
+	for _, res := range m.reservations {
+		if res.GuestID == id && res.CancelledAt.IsZero() {
+			stays++
+		}
+	}
+	return stays
+}
+
+//addReservation appends a reservation row with its line items and payments, redeeming its promo code, and returns its id, the caller holds the lock
+func (m *memoryDBRepo) addReservation(res models.Reservation, now time.Time) (int, error) {
+	promo := -1
+	if res.PromoCodeID != 0 {
+		for i, code := range m.promoCodes {
+			if code.ID == res.PromoCodeID {
+				promo = i
+			}
+		}
+		if promo == -1 {
+			return 0, errors.New("promo code does not exist")
+		}
+		if code := m.promoCodes[promo]; code.MaxUses > 0 && code.TimesUsed >= code.MaxUses {
+			return 0, repository.ErrPromoCodeUsedUp
+		}
+	}
+
+	res.ID = m.nextID("reservations")
+	res.GuestID = m.guestFor(res, now)
+	res.CreatedAt = now
+	res.UpdatedAt = now
+	for _, item := range res.LineItems {
+		item.ID = m.nextID("reservation_line_items")
+		item.ReservationID = res.ID
+		item.CreatedAt = now
+		item.UpdatedAt = now
+		m.lineItems = append(m.lineItems, item)
+	}
+	for _, payment := range res.Payments {
+		payment.ID = m.nextID("payments")
+		payment.ReservationID = res.ID
+		payment.BookingID = res.BookingID
+		payment.CreatedAt = now
+		payment.UpdatedAt = now
+		m.payments = append(m.payments, payment)
+	}
+	res.LineItems = nil
+	res.Payments = nil
+	m.reservations = append(m.reservations, res)
+
+	if promo != -1 {
+		m.promoCodes[promo].TimesUsed++
+		m.promoCodes[promo].UpdatedAt = now
+		m.promoRedemptions = append(m.promoRedemptions, promoRedemption{
+			ID:            m.nextID("promo_redemptions"),
+			PromoCodeID:   res.PromoCodeID,
+			ReservationID: res.ID,
+			Amount:        res.PromoDiscount,
+		})
+	}
+	return res.ID, nil
+}
+
+//deleteRoomRestrictions removes the room restrictions matching fn and returns how many it removed
+func (m *memoryDBRepo) deleteRoomRestrictions(fn func(rr models.RoomRestriction) bool) int {
+	kept := m.roomRestrictions[:0]
+	for _, rr := range m.roomRestrictions {
+		if !fn(rr) {
+			kept = append(kept, rr)
+		}
+	}
+	removed := len(m.roomRestrictions) - len(kept)
+	m.roomRestrictions = kept
+	return removed
+}
+
+//isHold reports whether the room restriction with the id is still a hold, the caller holds the lock
+func (m *memoryDBRepo) isHold(id int) bool {
+	for _, rr := range m.roomRestrictions {
+		if rr.ID == id {
+			return rr.RestrictionID == models.RestrictionHold
+		}
+	}
+	return false
+}
+
+//deleteRoomCalendar removes a room calendar with its restrictions and conflicts, the caller holds the lock
+func (m *memoryDBRepo) deleteRoomCalendar(id int) bool {
+	for i, c := range m.roomCalendars {
+		if c.ID != id {
+			continue
+		}
+		m.roomCalendars = append(m.roomCalendars[:i], m.roomCalendars[i+1:]...)
+		m.deleteRoomRestrictions(func(rr models.RoomRestriction) bool {
+			return rr.RoomCalendarID == id
+		})
+		kept := m.conflicts[:0]
+		for _, conflict := range m.conflicts {
+			if conflict.RoomCalendarID != id {
+				kept = append(kept, conflict)
+			}
+		}
+		m.conflicts = kept
+		return true
+	}
+	return false
+}
+
+//withRoom fills in the room a calendar belongs to, the caller holds the lock
+func (m *memoryDBRepo) withRoom(c models.RoomCalendar) models.RoomCalendar {
+	room, _ := m.findRoom(c.RoomID)
+	c.Room = models.Room{ID: room.ID, PropertyID: room.PropertyID, RoomName: room.RoomName}
+	return c
+}
+
+//ctxError reports a cancelled or expired context the same way the postgres repo does
+func ctxError(ctx context.Context) error {
+	return timeoutError(ctx, ctx.Err())
+}
+
+//nextID hands out ids per table the way a serial column would
+func (m *memoryDBRepo) nextID(table string) int {
+	m.lastIDs[table]++
+	return m.lastIDs[table]
+}
+
+//findProperty returns the first property matching fn
+func (m *memoryDBRepo) findProperty(ctx context.Context, fn func(p models.Property) bool) (models.Property, error) {
+	if err := ctxError(ctx); err != nil {
+		return models.Property{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, p := range m.properties {
+		if fn(p) {
+			return p, nil
+		}
+	}
+	return models.Property{}, repository.ErrNotFound
+}
+
+//checkPropertyUnique fails when another property already uses the slug or hostname, the caller holds the lock
+func (m *memoryDBRepo) checkPropertyUnique(p models.Property) error {
+	for _, existing := range m.properties {
+		if existing.ID == p.ID {
+			continue
+		}
+		if existing.Slug == p.Slug {
+			return errors.New("property slug is already in use")
+		}
+		if p.Hostname != "" && strings.EqualFold(existing.Hostname, p.Hostname) {
+			return errors.New("property hostname is already in use")
+		}
+	}
+	return nil
+}
+
+func (m *memoryDBRepo) findRoom(id int) (models.Room, bool) {
+	for _, room := range m.rooms {
+		if room.ID == id {
+			return room, true
+		}
+	}
+	return models.Room{}, false
+}
+
+//copyRoom copies a room so callers never share its amenities slice with the store
+func copyRoom(room models.Room) models.Room {
+	room.Amenities = append([]string(nil), room.Amenities...)
+	return room
+}
+
+//roomIsFree uses the same overlap test as the postgres queries: start < end_date and end > start_date
+func (m *memoryDBRepo) roomIsFree(roomID int, start, end time.Time) bool {
+	_, taken := m.restrictionOverlapping(roomID, start, end)
+	return !taken
+}
+
+//restrictionOverlapping returns a restriction on the room that overlaps the dates, holds that ran out do not count
+//even before they are swept
+func (m *memoryDBRepo) restrictionOverlapping(roomID int, start, end time.Time) (models.RoomRestriction, bool) {
+	now := time.Now()
+	for _, rr := range m.roomRestrictions {
+		if rr.RoomID == roomID && start.Before(rr.EndDate) && end.After(rr.StartDate) && !expiredHold(rr, now) {
+			return rr, true
+		}
+	}
+	return models.RoomRestriction{}, false
+}
+
+//expiredHold reports whether the restriction is a hold that ran out by now
+func expiredHold(rr models.RoomRestriction, now time.Time) bool {
+	return rr.RestrictionID == models.RestrictionHold && !rr.ExpiresAt.After(now)
+}
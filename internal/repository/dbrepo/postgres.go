@@ -1,5 +1,2438 @@
 package dbrepo
 
-func (m *postgresDBRepo) AllUsers() bool {
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/redblue-blur/bookings/internal/models"
+	"github.com/redblue-blur/bookings/internal/repository"
+)
+
+func (m *postgresDBRepo) AllUsers(ctx context.Context) bool {
 	return true
 }
+
+//Authenticate checks an email and password against the users table and returns the id of the user
+func (m *postgresDBRepo) Authenticate(ctx context.Context, email, password string) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var id int
+	var hash string
+
+	query := `select id, password from users where lower(email) = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, models.NormalizeEmail(email)).Scan(&id, &hash)
+	if err == sql.ErrNoRows {
+		return 0, repository.ErrInvalidCredentials
+	}
+	if err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+
+	if err := checkPassword(hash, password); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+//GetUserByID returns a user, without the password hash
+func (m *postgresDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	return m.getUser(ctx, `where u.id = $1`, id)
+}
+
+//GetUserByEmail returns the user with the email, without the password hash
+func (m *postgresDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	return m.getUser(ctx, `where lower(u.email) = $1`, models.NormalizeEmail(email))
+}
+
+//getUser returns the user matching the where clause
+func (m *postgresDBRepo) getUser(ctx context.Context, where string, args ...interface{}) (models.User, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var u models.User
+
+	query := `select u.id, u.first_name, u.last_name, u.email, u.access_level, u.session_version,
+			u.created_at, u.updated_at
+			from users u ` + where
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.AccessLevel,
+		&u.SessionVersion,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return u, repository.ErrNotFound
+	}
+	return u, timeoutError(ctx, err)
+}
+
+//InsertPasswordReset stores the hash of a password reset token sent to a user
+func (m *postgresDBRepo) InsertPasswordReset(ctx context.Context, userID int, tokenHash string, expires time.Time) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+	now := time.Now()
+
+	stmt := `insert into password_resets (user_id, token_hash, expires_at, created_at, updated_at)
+			values ($1, $2, $3, $4, $5) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, userID, tokenHash, expires, now, now).Scan(&newID)
+	if err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+	return newID, nil
+}
+
+//GetPasswordResetByToken gets the password reset a link was sent for
+func (m *postgresDBRepo) GetPasswordResetByToken(ctx context.Context, tokenHash string) (models.PasswordReset, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var pr models.PasswordReset
+	var usedAt sql.NullTime
+
+	query := `select id, user_id, expires_at, used_at, created_at, updated_at
+			from password_resets where token_hash = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash).Scan(
+		&pr.ID,
+		&pr.UserID,
+		&pr.ExpiresAt,
+		&usedAt,
+		&pr.CreatedAt,
+		&pr.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return pr, repository.ErrNotFound
+	}
+	pr.UsedAt = usedAt.Time
+	return pr, timeoutError(ctx, err)
+}
+
+//ResetPassword uses up a password reset to set the user's new password in one transaction, moving their
+//session version on so their other sessions are logged out, it fails once the reset has run out or was used
+func (m *postgresDBRepo) ResetPassword(ctx context.Context, resetID int, passwordHash string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return timeoutError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var userID int
+
+	stmt := `update password_resets set used_at = $1, updated_at = $1
+			where id = $2 and used_at is null and expires_at > $1
+			returning user_id`
+
+	err = tx.QueryRowContext(ctx, stmt, now, resetID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return repository.ErrResetExpired
+	}
+	if err != nil {
+		return timeoutError(ctx, err)
+	}
+
+	stmt = `update users set password = $1, session_version = session_version + 1, updated_at = $2
+			where id = $3`
+
+	if _, err := tx.ExecContext(ctx, stmt, passwordHash, now, userID); err != nil {
+		return timeoutError(ctx, err)
+	}
+
+	//links sent before this one stop working with the password they were sent for
+	stmt = `update password_resets set used_at = $1, updated_at = $1 where user_id = $2 and used_at is null`
+
+	if _, err := tx.ExecContext(ctx, stmt, now, userID); err != nil {
+		return timeoutError(ctx, err)
+	}
+	return timeoutError(ctx, tx.Commit())
+}
+
+//AllProperties returns every property
+func (m *postgresDBRepo) AllProperties(ctx context.Context) ([]models.Property, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var properties []models.Property
+
+	query := `select ` + propertyColumns + ` from properties p order by p.id`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return properties, timeoutError(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.Property
+		if err := scanProperty(rows, &p); err != nil {
+			return properties, timeoutError(ctx, err)
+		}
+		properties = append(properties, p)
+	}
+	return properties, timeoutError(ctx, rows.Err())
+}
+
+//GetPropertyByID gets a property by id
+func (m *postgresDBRepo) GetPropertyByID(ctx context.Context, id int) (models.Property, error) {
+	return m.queryProperty(ctx, `select `+propertyColumns+` from properties p where p.id = $1`, id)
+}
+
+//GetPropertyBySlug gets a property by the slug its site is served under
+func (m *postgresDBRepo) GetPropertyBySlug(ctx context.Context, slug string) (models.Property, error) {
+	return m.queryProperty(ctx, `select `+propertyColumns+` from properties p where p.slug = $1`, slug)
+}
+
+//GetPropertyByHostname gets the property served at the root of a host, hostnames are not case sensitive
+func (m *postgresDBRepo) GetPropertyByHostname(ctx context.Context, hostname string) (models.Property, error) {
+	return m.queryProperty(ctx, `select `+propertyColumns+` from properties p
+			where p.hostname <> '' and lower(p.hostname) = lower($1)`, hostname)
+}
+
+//InsertProperty inserts a property
+func (m *postgresDBRepo) InsertProperty(ctx context.Context, p models.Property) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+	now := time.Now()
+
+	stmt := `insert into properties (name, slug, hostname, email, phone, address, time_zone,
+			check_in_time, check_out_time, calendar_names, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		p.Name,
+		p.Slug,
+		p.Hostname,
+		p.Email,
+		p.Phone,
+		p.Address,
+		p.TimeZone,
+		p.CheckInTime,
+		p.CheckOutTime,
+		p.CalendarNames,
+		now,
+		now,
+	).Scan(&newID)
+	if err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+	return newID, nil
+}
+
+//UpdateProperty updates a property's settings
+func (m *postgresDBRepo) UpdateProperty(ctx context.Context, p models.Property) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update properties set name = $1, slug = $2, hostname = $3, email = $4, phone = $5,
+			address = $6, time_zone = $7, check_in_time = $8, check_out_time = $9, calendar_names = $10,
+			updated_at = $11
+			where id = $12`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		p.Name,
+		p.Slug,
+		p.Hostname,
+		p.Email,
+		p.Phone,
+		p.Address,
+		p.TimeZone,
+		p.CheckInTime,
+		p.CheckOutTime,
+		p.CalendarNames,
+		time.Now(),
+		p.ID,
+	)
+	if err != nil {
+		return timeoutError(ctx, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+//InsertReservation inserts a reservation and the room restriction for its dates in a single transaction
+func (m *postgresDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	newID, err := insertReservation(ctx, tx, res, now)
+	if err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+
+	err = deleteExpiredHolds(ctx, tx, res.RoomID, res.StartDate, res.EndDate, now)
+	if err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+
+	stmt := `insert into room_restrictions (start_date, end_date, room_id, reservation_id,
+			restriction_id, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		newID,
+		models.RestrictionReservation,
+		now,
+		now,
+	)
+	if err != nil {
+		return 0, conflictError(timeoutError(ctx, err), res.RoomID, res.StartDate, res.EndDate)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+	return newID, nil
+}
+
+//InsertBooking books every room of a group booking in one transaction, when one of the rooms is taken it
+//fails with a repository.ConflictError for that room and none of them are booked
+func (m *postgresDBRepo) InsertBooking(ctx context.Context, b models.Booking) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	var bookingID int
+	now := time.Now()
+
+	stmt := `insert into bookings (code, first_name, last_name, email, phone, total_price, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		b.Code,
+		b.FirstName,
+		b.LastName,
+		b.Email,
+		b.Phone,
+		b.TotalPrice,
+		now,
+		now,
+	).Scan(&bookingID)
+	if err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+
+	stmt = `insert into room_restrictions (start_date, end_date, room_id, reservation_id,
+			restriction_id, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7)`
+
+	for _, res := range b.Reservations {
+		res.BookingID = bookingID
+		reservationID, err := insertReservation(ctx, tx, res, now)
+		if err != nil {
+			return 0, timeoutError(ctx, err)
+		}
+
+		err = deleteExpiredHolds(ctx, tx, res.RoomID, res.StartDate, res.EndDate, now)
+		if err != nil {
+			return 0, timeoutError(ctx, err)
+		}
+
+		_, err = tx.ExecContext(ctx, stmt,
+			res.StartDate,
+			res.EndDate,
+			res.RoomID,
+			reservationID,
+			models.RestrictionReservation,
+			now,
+			now,
+		)
+		if err != nil {
+			return 0, conflictError(timeoutError(ctx, err), res.RoomID, res.StartDate, res.EndDate)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+	return bookingID, nil
+}
+
+//InsertRoomRestriction inserts a room restriction into the database
+func (m *postgresDBRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now()
+
+	stmt := `insert into room_restrictions (start_date, end_date, room_id, reservation_id,
+			restriction_id, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		r.StartDate,
+		r.EndDate,
+		r.RoomID,
+		nullInt(r.ReservationID),
+		r.RestrictionID,
+		now,
+		now,
+	)
+	if err != nil {
+		return conflictError(timeoutError(ctx, err), r.RoomID, r.StartDate, r.EndDate)
+	}
+	return nil
+}
+
+//GetReservationByID gets a reservation by id
+func (m *postgresDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select ` + reservationColumns + ` from reservations r where r.id = $1`
+
+	var res models.Reservation
+	err := scanReservation(m.DB.QueryRowContext(ctx, query, id), &res)
+	if err == sql.ErrNoRows {
+		return res, repository.ErrNotFound
+	}
+	return res, timeoutError(ctx, err)
+}
+
+//GetReservationByCode gets a reservation by the code given to the guest
+func (m *postgresDBRepo) GetReservationByCode(ctx context.Context, code string) (models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select ` + reservationColumns + ` from reservations r where r.code = $1`
+
+	var res models.Reservation
+	err := scanReservation(m.DB.QueryRowContext(ctx, query, code), &res)
+	if err == sql.ErrNoRows {
+		return res, repository.ErrNotFound
+	}
+	return res, timeoutError(ctx, err)
+}
+
+//CancelReservation marks the reservation cancelled with its fee and frees its dates, only one caller can cancel
+//it so only that one goes on to settle its payments
+func (m *postgresDBRepo) CancelReservation(ctx context.Context, id, fee int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return timeoutError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	stmt := `update reservations set cancelled_at = $1, cancellation_fee = $2, updated_at = $1
+			where id = $3 and cancelled_at is null`
+
+	result, err := tx.ExecContext(ctx, stmt, now, fee, id)
+	if err != nil {
+		return timeoutError(ctx, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		var exists bool
+		err := tx.QueryRowContext(ctx, `select exists(select 1 from reservations where id = $1)`, id).Scan(&exists)
+		if err != nil {
+			return timeoutError(ctx, err)
+		}
+		if !exists {
+			return repository.ErrNotFound
+		}
+		return repository.ErrAlreadyCancelled
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, id)
+	if err != nil {
+		return timeoutError(ctx, err)
+	}
+
+	return timeoutError(ctx, tx.Commit())
+}
+
+//SettleCancellation records what settling a cancelled reservation's payments refunded and the error that stopped
+//it, empty once every payment is settled
+func (m *postgresDBRepo) SettleCancellation(ctx context.Context, id, refund int, settlementError string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update reservations set refund_amount = $1, settlement_error = $2, updated_at = $3
+			where id = $4 and cancelled_at is not null`
+
+	result, err := m.DB.ExecContext(ctx, stmt, refund, settlementError, time.Now(), id)
+	if err != nil {
+		return timeoutError(ctx, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+//SearchAvailabilityByDatesByRoomID returns true if the room has no restriction overlapping the dates
+func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var numRows int
+
+	query := `select count(id) from room_restrictions
+			where room_id = $1 and $2 < end_date and $3 > start_date
+			and (restriction_id <> $4 or expires_at > $5)`
+
+	err := m.DB.QueryRowContext(ctx, query, roomID, start, end, models.RestrictionHold, time.Now()).Scan(&numRows)
+	if err != nil {
+		return false, timeoutError(ctx, err)
+	}
+	return numRows == 0, nil
+}
+
+//SearchAvailabilityForAllRooms returns the property's rooms that are free between start and end
+func (m *postgresDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, propertyID int, start, end time.Time) ([]models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select ` + roomColumns + ` from rooms r
+			where r.property_id = $1 and r.id not in (select rr.room_id from room_restrictions rr
+			where $2 < rr.end_date and $3 > rr.start_date and (rr.restriction_id <> $4 or rr.expires_at > $5))
+			order by r.id`
+
+	rooms, err := m.queryRooms(ctx, query, propertyID, start, end, models.RestrictionHold, time.Now())
+	return rooms, timeoutError(ctx, err)
+}
+
+//GetRoomByID gets a room by id
+func (m *postgresDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select ` + roomColumns + ` from rooms r where r.id = $1`
+
+	room, err := m.queryRoom(ctx, query, id)
+	return room, timeoutError(ctx, err)
+}
+
+//GetRoomBySlug gets one of the property's rooms by the slug used in its page url
+func (m *postgresDBRepo) GetRoomBySlug(ctx context.Context, propertyID int, slug string) (models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select ` + roomColumns + ` from rooms r where r.property_id = $1 and r.slug = $2`
+
+	room, err := m.queryRoom(ctx, query, propertyID, slug)
+	return room, timeoutError(ctx, err)
+}
+
+//AllRooms returns every room of the property
+func (m *postgresDBRepo) AllRooms(ctx context.Context, propertyID int) ([]models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select ` + roomColumns + ` from rooms r where r.property_id = $1 order by r.id`
+
+	rooms, err := m.queryRooms(ctx, query, propertyID)
+	return rooms, timeoutError(ctx, err)
+}
+
+//InsertRoom inserts a room and its amenities
+func (m *postgresDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	var newID int
+	now := time.Now()
+
+	stmt := `insert into rooms (property_id, room_name, slug, room_type, max_adults, max_children,
+			bed_configuration, description, base_price, weekend_percent, cancellation_policy_id,
+			created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		room.PropertyID,
+		room.RoomName,
+		room.Slug,
+		room.RoomType,
+		room.MaxAdults,
+		room.MaxChildren,
+		room.BedConfiguration,
+		room.Description,
+		room.BasePrice,
+		room.WeekendPercent,
+		nullInt(room.CancellationPolicyID),
+		now,
+		now,
+	).Scan(&newID)
+	if err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+
+	if err = replaceAmenities(ctx, tx, newID, room.Amenities, now); err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+	return newID, nil
+}
+
+//UpdateRoom updates a room and replaces its amenities
+func (m *postgresDBRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return timeoutError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	stmt := `update rooms set property_id = $1, room_name = $2, slug = $3, room_type = $4,
+			max_adults = $5, max_children = $6, bed_configuration = $7, description = $8,
+			base_price = $9, weekend_percent = $10, cancellation_policy_id = $11, updated_at = $12
+			where id = $13`
+
+	result, err := tx.ExecContext(ctx, stmt,
+		room.PropertyID,
+		room.RoomName,
+		room.Slug,
+		room.RoomType,
+		room.MaxAdults,
+		room.MaxChildren,
+		room.BedConfiguration,
+		room.Description,
+		room.BasePrice,
+		room.WeekendPercent,
+		nullInt(room.CancellationPolicyID),
+		now,
+		room.ID,
+	)
+	if err != nil {
+		return timeoutError(ctx, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return repository.ErrNotFound
+	}
+
+	if err = replaceAmenities(ctx, tx, room.ID, room.Amenities, now); err != nil {
+		return timeoutError(ctx, err)
+	}
+
+	return timeoutError(ctx, tx.Commit())
+}
+
+//DeleteRoom deletes a room, its amenities, reservations and restrictions go with it
+func (m *postgresDBRepo) DeleteRoom(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from rooms where id = $1`, id)
+	if err != nil {
+		return timeoutError(ctx, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+//SeasonalRatesForRoom returns the room's seasonal rates that overlap the dates
+func (m *postgresDBRepo) SeasonalRatesForRoom(ctx context.Context, roomID int, start, end time.Time) ([]models.SeasonalRate, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var rates []models.SeasonalRate
+
+	query := `select id, room_id, name, start_date, end_date, nightly_price,
+			coalesce(cancellation_policy_id, 0), created_at, updated_at
+			from seasonal_rates
+			where room_id = $1 and $2 < end_date and $3 > start_date
+			order by start_date`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID, start, end)
+	if err != nil {
+		return rates, timeoutError(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rate models.SeasonalRate
+		err := rows.Scan(
+			&rate.ID,
+			&rate.RoomID,
+			&rate.Name,
+			&rate.StartDate,
+			&rate.EndDate,
+			&rate.NightlyPrice,
+			&rate.CancellationPolicyID,
+			&rate.CreatedAt,
+			&rate.UpdatedAt,
+		)
+		if err != nil {
+			return rates, timeoutError(ctx, err)
+		}
+		rates = append(rates, rate)
+	}
+	return rates, timeoutError(ctx, rows.Err())
+}
+
+//stayRuleColumns are the columns scanned by queryStayRules, from stay_rules s joined with rooms r
+const stayRuleColumns = `s.id, s.room_id, s.name, s.start_date, s.end_date, s.weekdays, s.min_nights, s.max_nights,
+		s.closed_to_arrival, s.closed_to_departure, s.created_at, s.updated_at, r.property_id, r.room_name`
+
+//StayRulesForRoom returns the room's stay rules that can apply to a stay from start to end, that is on
+//its nights or the departure day
+func (m *postgresDBRepo) StayRulesForRoom(ctx context.Context, roomID int, start, end time.Time) ([]models.StayRule, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select ` + stayRuleColumns + ` from stay_rules s join rooms r on r.id = s.room_id
+			where s.room_id = $1 and $2 < s.end_date and $3 >= s.start_date
+			order by s.start_date, s.id`
+
+	rules, err := m.queryStayRules(ctx, query, roomID, start, end)
+	return rules, timeoutError(ctx, err)
+}
+
+//StayRulesForProperty returns the stay rules of every room of the property
+func (m *postgresDBRepo) StayRulesForProperty(ctx context.Context, propertyID int) ([]models.StayRule, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select ` + stayRuleColumns + ` from stay_rules s join rooms r on r.id = s.room_id
+			where r.property_id = $1
+			order by r.id, s.start_date, s.id`
+
+	rules, err := m.queryStayRules(ctx, query, propertyID)
+	return rules, timeoutError(ctx, err)
+}
+
+//queryStayRules runs a query selecting stayRuleColumns
+func (m *postgresDBRepo) queryStayRules(ctx context.Context, query string, args ...interface{}) ([]models.StayRule, error) {
+	var rules []models.StayRule
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rule models.StayRule
+		err := rows.Scan(
+			&rule.ID,
+			&rule.RoomID,
+			&rule.Name,
+			&rule.StartDate,
+			&rule.EndDate,
+			&rule.Weekdays,
+			&rule.MinNights,
+			&rule.MaxNights,
+			&rule.ClosedToArrival,
+			&rule.ClosedToDeparture,
+			&rule.CreatedAt,
+			&rule.UpdatedAt,
+			&rule.Room.PropertyID,
+			&rule.Room.RoomName,
+		)
+		if err != nil {
+			return rules, err
+		}
+		rule.Room.ID = rule.RoomID
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+//InsertStayRule adds a stay rule to a room
+func (m *postgresDBRepo) InsertStayRule(ctx context.Context, rule models.StayRule) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+	now := time.Now()
+
+	stmt := `insert into stay_rules (room_id, name, start_date, end_date, weekdays, min_nights, max_nights,
+			closed_to_arrival, closed_to_departure, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		rule.RoomID,
+		rule.Name,
+		rule.StartDate,
+		rule.EndDate,
+		rule.Weekdays,
+		rule.MinNights,
+		rule.MaxNights,
+		rule.ClosedToArrival,
+		rule.ClosedToDeparture,
+		now,
+		now,
+	).Scan(&newID)
+	if err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+	return newID, nil
+}
+
+//DeleteStayRule removes a stay rule
+func (m *postgresDBRepo) DeleteStayRule(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from stay_rules where id = $1`, id)
+	if err != nil {
+		return timeoutError(ctx, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+//StayDiscountsForRoom returns the length of stay discounts for the room and those for every room
+func (m *postgresDBRepo) StayDiscountsForRoom(ctx context.Context, roomID int) ([]models.StayDiscount, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var discounts []models.StayDiscount
+
+	query := `select id, coalesce(room_id, 0), min_nights, percent, created_at, updated_at
+			from stay_discounts
+			where room_id = $1 or room_id is null
+			order by min_nights`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return discounts, timeoutError(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var discount models.StayDiscount
+		err := rows.Scan(
+			&discount.ID,
+			&discount.RoomID,
+			&discount.MinNights,
+			&discount.Percent,
+			&discount.CreatedAt,
+			&discount.UpdatedAt,
+		)
+		if err != nil {
+			return discounts, timeoutError(ctx, err)
+		}
+		discounts = append(discounts, discount)
+	}
+	return discounts, timeoutError(ctx, rows.Err())
+}
+
+//GetCancellationPolicy gets a cancellation policy by id
+func (m *postgresDBRepo) GetCancellationPolicy(ctx context.Context, id int) (models.CancellationPolicy, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var policy models.CancellationPolicy
+
+	query := `select id, name, free_days, penalty_type, penalty_percent, created_at, updated_at
+			from cancellation_policies where id = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&policy.ID,
+		&policy.Name,
+		&policy.FreeDays,
+		&policy.PenaltyType,
+		&policy.PenaltyPercent,
+		&policy.CreatedAt,
+		&policy.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return policy, repository.ErrNotFound
+	}
+	return policy, timeoutError(ctx, err)
+}
+
+//GetPromoCodeByCode gets one of the property's promo codes and the rooms it is limited to, codes are not
+//case sensitive
+func (m *postgresDBRepo) GetPromoCodeByCode(ctx context.Context, propertyID int, code string) (models.PromoCode, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var promo models.PromoCode
+
+	query := `select id, property_id, code, percent_off, amount_off, valid_from, valid_to, min_nights,
+			max_uses, times_used, created_at, updated_at
+			from promo_codes where property_id = $1 and upper(code) = upper($2)`
+
+	err := m.DB.QueryRowContext(ctx, query, propertyID, code).Scan(
+		&promo.ID,
+		&promo.PropertyID,
+		&promo.Code,
+		&promo.PercentOff,
+		&promo.AmountOff,
+		&promo.ValidFrom,
+		&promo.ValidTo,
+		&promo.MinNights,
+		&promo.MaxUses,
+		&promo.TimesUsed,
+		&promo.CreatedAt,
+		&promo.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return promo, repository.ErrNotFound
+	}
+	if err != nil {
+		return promo, timeoutError(ctx, err)
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `select room_id from promo_code_rooms where promo_code_id = $1`, promo.ID)
+	if err != nil {
+		return promo, timeoutError(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var roomID int
+		if err := rows.Scan(&roomID); err != nil {
+			return promo, timeoutError(ctx, err)
+		}
+		promo.RoomIDs = append(promo.RoomIDs, roomID)
+	}
+	return promo, timeoutError(ctx, rows.Err())
+}
+
+//AllTaxRules returns every tax and fee rule of the property, including the ones that have been replaced
+func (m *postgresDBRepo) AllTaxRules(ctx context.Context, propertyID int) ([]models.TaxRule, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var rules []models.TaxRule
+
+	query := `select id, property_id, kind, name, amount, effective_from, created_at, updated_at
+			from tax_rules where property_id = $1 order by kind, effective_from`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyID)
+	if err != nil {
+		return rules, timeoutError(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rule models.TaxRule
+		err := rows.Scan(
+			&rule.ID,
+			&rule.PropertyID,
+			&rule.Kind,
+			&rule.Name,
+			&rule.Amount,
+			&rule.EffectiveFrom,
+			&rule.CreatedAt,
+			&rule.UpdatedAt,
+		)
+		if err != nil {
+			return rules, timeoutError(ctx, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, timeoutError(ctx, rows.Err())
+}
+
+//InsertTaxRule adds a tax or fee rule, rules are never updated so past reservations keep their rates
+func (m *postgresDBRepo) InsertTaxRule(ctx context.Context, rule models.TaxRule) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+	now := time.Now()
+
+	stmt := `insert into tax_rules (property_id, kind, name, amount, effective_from, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		rule.PropertyID,
+		rule.Kind,
+		rule.Name,
+		rule.Amount,
+		rule.EffectiveFrom,
+		now,
+		now,
+	).Scan(&newID)
+	if err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+	return newID, nil
+}
+
+//LineItemsForReservation returns the price lines stored when the reservation was booked
+func (m *postgresDBRepo) LineItemsForReservation(ctx context.Context, reservationID int) ([]models.LineItem, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var items []models.LineItem
+
+	query := `select id, reservation_id, position, kind, description, night, amount, created_at, updated_at
+			from reservation_line_items where reservation_id = $1 order by position`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return items, timeoutError(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item models.LineItem
+		var night sql.NullTime
+		err := rows.Scan(
+			&item.ID,
+			&item.ReservationID,
+			&item.Position,
+			&item.Kind,
+			&item.Description,
+			&night,
+			&item.Amount,
+			&item.CreatedAt,
+			&item.UpdatedAt,
+		)
+		if err != nil {
+			return items, timeoutError(ctx, err)
+		}
+		item.Night = night.Time
+		items = append(items, item)
+	}
+	return items, timeoutError(ctx, rows.Err())
+}
+
+//PaymentsForReservation returns the payments taken for the reservation
+func (m *postgresDBRepo) PaymentsForReservation(ctx context.Context, reservationID int) ([]models.Payment, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var payments []models.Payment
+
+	query := `select id, reservation_id, coalesce(booking_id, 0), provider, provider_id, status, amount,
+			card_last4, settled_at, created_at, updated_at
+			from payments where reservation_id = $1 order by id`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return payments, timeoutError(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var payment models.Payment
+		var settledAt sql.NullTime
+		err := rows.Scan(
+			&payment.ID,
+			&payment.ReservationID,
+			&payment.BookingID,
+			&payment.Provider,
+			&payment.ProviderID,
+			&payment.Status,
+			&payment.Amount,
+			&payment.CardLast4,
+			&settledAt,
+			&payment.CreatedAt,
+			&payment.UpdatedAt,
+		)
+		if err != nil {
+			return payments, timeoutError(ctx, err)
+		}
+		payment.SettledAt = settledAt.Time
+		payments = append(payments, payment)
+	}
+	return payments, timeoutError(ctx, rows.Err())
+}
+
+//UpdatePaymentStatus records a status reported by the payment provider, unknown payments are ignored
+func (m *postgresDBRepo) UpdatePaymentStatus(ctx context.Context, provider, providerID, status string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update payments set status = $1, updated_at = $2 where provider = $3 and provider_id = $4`
+
+	_, err := m.DB.ExecContext(ctx, stmt, status, time.Now(), provider, providerID)
+	return timeoutError(ctx, err)
+}
+
+//ClaimPaymentSettlement marks a payment settled before a cancellation moves its money, so two requests
+//cancelling at once cannot both charge or refund it
+func (m *postgresDBRepo) ClaimPaymentSettlement(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update payments set settled_at = $1, updated_at = $1 where id = $2 and settled_at is null`
+
+	result, err := m.DB.ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return timeoutError(ctx, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		var exists bool
+		err := m.DB.QueryRowContext(ctx, `select exists(select 1 from payments where id = $1)`, id).Scan(&exists)
+		if err != nil {
+			return timeoutError(ctx, err)
+		}
+		if !exists {
+			return repository.ErrNotFound
+		}
+		return repository.ErrAlreadySettled
+	}
+	return nil
+}
+
+//ReleasePaymentSettlement gives back a claim on a payment the provider did not settle, so it can be retried
+func (m *postgresDBRepo) ReleasePaymentSettlement(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update payments set settled_at = null, updated_at = $1 where id = $2`
+
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), id)
+	return timeoutError(ctx, err)
+}
+
+//InsertWaitlistEntry adds a guest to the waitlist for their dates
+func (m *postgresDBRepo) InsertWaitlistEntry(ctx context.Context, e models.WaitlistEntry) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+	now := time.Now()
+
+	stmt := `insert into waitlist_entries (property_id, email, room_id, start_date, end_date, status,
+			created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		e.PropertyID,
+		e.Email,
+		nullInt(e.RoomID),
+		e.StartDate,
+		e.EndDate,
+		models.WaitlistWaiting,
+		now,
+		now,
+	).Scan(&newID)
+	if err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+	return newID, nil
+}
+
+//WaitlistEntriesForRoom returns the entries of the room's property still waiting for dates overlapping start
+//to end that the room would suit, in the order the guests joined
+func (m *postgresDBRepo) WaitlistEntriesForRoom(ctx context.Context, roomID int, start, end time.Time) ([]models.WaitlistEntry, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var entries []models.WaitlistEntry
+
+	query := `select ` + waitlistColumns + ` from waitlist_entries w
+			where w.status = $1 and (w.room_id = $2 or w.room_id is null)
+			and w.property_id = (select property_id from rooms where id = $2)
+			and $3 < w.end_date and $4 > w.start_date
+			order by w.created_at, w.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, models.WaitlistWaiting, roomID, start, end)
+	if err != nil {
+		return entries, timeoutError(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.WaitlistEntry
+		if err := scanWaitlistEntry(rows, &e); err != nil {
+			return entries, timeoutError(ctx, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, timeoutError(ctx, rows.Err())
+}
+
+//OfferWaitlistEntry gives a waiting entry a claim on the room until expires, with the hold keeping it
+func (m *postgresDBRepo) OfferWaitlistEntry(ctx context.Context, id, roomID, holdID int, tokenHash string, expires time.Time) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update waitlist_entries set status = $1, claim_room_id = $2, claim_hold_id = $3,
+			claim_token_hash = $4, claim_expires_at = $5, updated_at = $6
+			where id = $7 and status = $8`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		models.WaitlistOffered,
+		roomID,
+		nullInt(holdID),
+		tokenHash,
+		expires,
+		time.Now(),
+		id,
+		models.WaitlistWaiting,
+	)
+	if err != nil {
+		return timeoutError(ctx, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+//GetWaitlistEntryByToken gets the entry a claim link was sent to
+func (m *postgresDBRepo) GetWaitlistEntryByToken(ctx context.Context, tokenHash string) (models.WaitlistEntry, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select ` + waitlistColumns + ` from waitlist_entries w where w.claim_token_hash = $1`
+
+	var e models.WaitlistEntry
+	err := scanWaitlistEntry(m.DB.QueryRowContext(ctx, query, tokenHash), &e)
+	if err == sql.ErrNoRows {
+		return e, repository.ErrNotFound
+	}
+	return e, timeoutError(ctx, err)
+}
+
+//ExpireWaitlistOffers marks offers that ran out by now as expired and returns them, along with claimed entries
+//whose hold ran out without being booked
+func (m *postgresDBRepo) ExpireWaitlistOffers(ctx context.Context, now time.Time) ([]models.WaitlistEntry, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var entries []models.WaitlistEntry
+
+	query := `update waitlist_entries w set status = $1, updated_at = $2
+			where w.claim_expires_at <= $2 and (w.status = $3 or (w.status = $4 and exists (
+				select 1 from room_restrictions rr where rr.id = w.claim_hold_id and rr.restriction_id = $5)))
+			returning ` + waitlistColumns
+
+	rows, err := m.DB.QueryContext(ctx, query, models.WaitlistExpired, now, models.WaitlistOffered,
+		models.WaitlistClaimed, models.RestrictionHold)
+	if err != nil {
+		return entries, timeoutError(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.WaitlistEntry
+		if err := scanWaitlistEntry(rows, &e); err != nil {
+			return entries, timeoutError(ctx, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, timeoutError(ctx, rows.Err())
+}
+
+//InsertHold places a hold on the room for the dates until expires, replacing the other hold of its owner so a
+//session cannot hold more than one room at a time, holds without an owner are released on their own
+func (m *postgresDBRepo) InsertHold(ctx context.Context, owner string, roomID int, start, end, expires time.Time) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	var newID int
+	now := time.Now()
+
+	if owner != "" {
+		stmt := `delete from room_restrictions where hold_owner = $1 and restriction_id = $2`
+
+		_, err = tx.ExecContext(ctx, stmt, owner, models.RestrictionHold)
+		if err != nil {
+			return 0, timeoutError(ctx, err)
+		}
+	}
+
+	err = deleteExpiredHolds(ctx, tx, roomID, start, end, now)
+	if err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+
+	stmt := `insert into room_restrictions (start_date, end_date, room_id, restriction_id, hold_owner,
+			expires_at, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		start,
+		end,
+		roomID,
+		models.RestrictionHold,
+		nullString(owner),
+		expires,
+		now,
+		now,
+	).Scan(&newID)
+	if err != nil {
+		return 0, conflictError(timeoutError(ctx, err), roomID, start, end)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+	return newID, nil
+}
+
+//ConvertHold inserts the reservation and turns its hold into the reservation's room restriction
+func (m *postgresDBRepo) ConvertHold(ctx context.Context, holdID int, res models.Reservation) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	var hold models.RoomRestriction
+
+	query := `select room_id, start_date, end_date from room_restrictions
+			where id = $1 and restriction_id = $2 and expires_at > $3 for update`
+
+	err = tx.QueryRowContext(ctx, query, holdID, models.RestrictionHold, time.Now()).Scan(
+		&hold.RoomID,
+		&hold.StartDate,
+		&hold.EndDate,
+	)
+	if err == sql.ErrNoRows {
+		return 0, repository.ErrHoldExpired
+	}
+	if err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+	if hold.RoomID != res.RoomID || !hold.StartDate.Equal(res.StartDate) || !hold.EndDate.Equal(res.EndDate) {
+		return 0, repository.ErrHoldExpired
+	}
+
+	now := time.Now()
+
+	newID, err := insertReservation(ctx, tx, res, now)
+	if err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+
+	stmt := `update room_restrictions set restriction_id = $1, reservation_id = $2, hold_owner = null,
+			expires_at = null, updated_at = $3
+			where id = $4`
+
+	_, err = tx.ExecContext(ctx, stmt, models.RestrictionReservation, newID, now, holdID)
+	if err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+
+	//a hold offered to the waitlist is claimed by booking it
+	stmt = `update waitlist_entries set status = $1, updated_at = $2 where claim_hold_id = $3 and status = $4`
+
+	_, err = tx.ExecContext(ctx, stmt, models.WaitlistClaimed, now, holdID, models.WaitlistOffered)
+	if err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+	return newID, nil
+}
+
+//ReleaseHold removes a hold that is no longer needed
+func (m *postgresDBRepo) ReleaseHold(ctx context.Context, holdID int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `delete from room_restrictions where id = $1 and restriction_id = $2`
+
+	_, err := m.DB.ExecContext(ctx, stmt, holdID, models.RestrictionHold)
+	return timeoutError(ctx, err)
+}
+
+//DeleteExpiredHolds removes holds that ran out by now and returns how many were released
+func (m *postgresDBRepo) DeleteExpiredHolds(ctx context.Context, now time.Time) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `delete from room_restrictions where restriction_id = $1 and expires_at <= $2`
+
+	result, err := m.DB.ExecContext(ctx, stmt, models.RestrictionHold, now)
+	if err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+//SetRoomCalendarToken replaces the secret in the url of the room's calendar feed
+func (m *postgresDBRepo) SetRoomCalendarToken(ctx context.Context, roomID int, token string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update rooms set calendar_token = $1, updated_at = $2 where id = $3`
+
+	result, err := m.DB.ExecContext(ctx, stmt, token, time.Now(), roomID)
+	if err != nil {
+		return timeoutError(ctx, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+//RoomRestrictionsForRoom returns the room's restrictions that end after the given day, with the guest
+//names of the reservations among them
+func (m *postgresDBRepo) RoomRestrictionsForRoom(ctx context.Context, roomID int, from time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select rr.id, rr.room_id, rr.start_date, rr.end_date, coalesce(rr.reservation_id, 0),
+			rr.restriction_id, rr.created_at, rr.updated_at, coalesce(r.first_name, ''),
+			coalesce(r.last_name, '')
+			from room_restrictions rr left join reservations r on r.id = rr.reservation_id
+			where rr.room_id = $1 and rr.end_date > $2
+			order by rr.start_date`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID, from)
+	if err != nil {
+		return nil, timeoutError(ctx, err)
+	}
+	defer rows.Close()
+
+	var restrictions []models.RoomRestriction
+	for rows.Next() {
+		var rr models.RoomRestriction
+		err := rows.Scan(
+			&rr.ID,
+			&rr.RoomID,
+			&rr.StartDate,
+			&rr.EndDate,
+			&rr.ReservationID,
+			&rr.RestrictionID,
+			&rr.CreatedAt,
+			&rr.UpdatedAt,
+			&rr.Reservation.FirstName,
+			&rr.Reservation.LastName,
+		)
+		if err != nil {
+			return restrictions, timeoutError(ctx, err)
+		}
+		rr.Reservation.ID = rr.ReservationID
+		restrictions = append(restrictions, rr)
+	}
+	return restrictions, timeoutError(ctx, rows.Err())
+}
+
+//AllRoomCalendars returns the calendars of every room, for syncing them
+func (m *postgresDBRepo) AllRoomCalendars(ctx context.Context) ([]models.RoomCalendar, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select ` + roomCalendarColumns + ` from room_calendars c join rooms r on r.id = c.room_id
+			order by c.id`
+
+	calendars, err := m.queryRoomCalendars(ctx, query)
+	return calendars, timeoutError(ctx, err)
+}
+
+//RoomCalendarsForProperty returns the calendars of the property's rooms with the conflicts of their last sync
+func (m *postgresDBRepo) RoomCalendarsForProperty(ctx context.Context, propertyID int) ([]models.RoomCalendar, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select ` + roomCalendarColumns + ` from room_calendars c join rooms r on r.id = c.room_id
+			where r.property_id = $1
+			order by r.id, c.id`
+
+	calendars, err := m.queryRoomCalendars(ctx, query, propertyID)
+	if err != nil || len(calendars) == 0 {
+		return calendars, timeoutError(ctx, err)
+	}
+
+	byID := make(map[int]*models.RoomCalendar, len(calendars))
+	for i := range calendars {
+		byID[calendars[i].ID] = &calendars[i]
+	}
+
+	query = `select cc.id, cc.room_calendar_id, cc.external_uid, cc.summary, cc.start_date, cc.end_date,
+			coalesce(cc.reservation_id, 0), cc.created_at
+			from calendar_conflicts cc
+			join room_calendars c on c.id = cc.room_calendar_id
+			join rooms r on r.id = c.room_id
+			where r.property_id = $1
+			order by cc.start_date, cc.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyID)
+	if err != nil {
+		return calendars, timeoutError(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.CalendarConflict
+		err := rows.Scan(
+			&c.ID,
+			&c.RoomCalendarID,
+			&c.UID,
+			&c.Summary,
+			&c.StartDate,
+			&c.EndDate,
+			&c.ReservationID,
+			&c.CreatedAt,
+		)
+		if err != nil {
+			return calendars, timeoutError(ctx, err)
+		}
+		if cal, ok := byID[c.RoomCalendarID]; ok {
+			cal.Conflicts = append(cal.Conflicts, c)
+		}
+	}
+	return calendars, timeoutError(ctx, rows.Err())
+}
+
+//GetRoomCalendar gets a room calendar by id
+func (m *postgresDBRepo) GetRoomCalendar(ctx context.Context, id int) (models.RoomCalendar, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select ` + roomCalendarColumns + ` from room_calendars c join rooms r on r.id = c.room_id
+			where c.id = $1`
+
+	var c models.RoomCalendar
+	err := scanRoomCalendar(m.DB.QueryRowContext(ctx, query, id), &c)
+	if err == sql.ErrNoRows {
+		return c, repository.ErrNotFound
+	}
+	return c, timeoutError(ctx, err)
+}
+
+//InsertRoomCalendar adds a calendar to import into a room
+func (m *postgresDBRepo) InsertRoomCalendar(ctx context.Context, c models.RoomCalendar) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+	now := time.Now()
+
+	stmt := `insert into room_calendars (room_id, name, url, created_at, updated_at)
+			values ($1, $2, $3, $4, $5) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, c.RoomID, c.Name, c.URL, now, now).Scan(&newID)
+	if err != nil {
+		return 0, timeoutError(ctx, err)
+	}
+	return newID, nil
+}
+
+//DeleteRoomCalendar stops importing a calendar and frees the dates its events blocked
+func (m *postgresDBRepo) DeleteRoomCalendar(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	//the calendar's restrictions and conflicts go with it
+	result, err := m.DB.ExecContext(ctx, `delete from room_calendars where id = $1`, id)
+	if err != nil {
+		return timeoutError(ctx, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+//SyncRoomCalendar makes the calendar's external restrictions match the events now in it in one
+//transaction, events that overlap anything else on the room are kept as conflicts instead
+func (m *postgresDBRepo) SyncRoomCalendar(ctx context.Context, id int, events []models.ExternalEvent) (models.CalendarSync, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var sync models.CalendarSync
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return sync, timeoutError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	//locking the calendar keeps two syncs of it from running at once
+	var roomID int
+	err = tx.QueryRowContext(ctx, `select room_id from room_calendars where id = $1 for update`, id).Scan(&roomID)
+	if err == sql.ErrNoRows {
+		return sync, repository.ErrNotFound
+	}
+	if err != nil {
+		return sync, timeoutError(ctx, err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `select id, external_uid, start_date, end_date from room_restrictions
+			where room_calendar_id = $1`, id)
+	if err != nil {
+		return sync, timeoutError(ctx, err)
+	}
+	var existing []models.RoomRestriction
+	for rows.Next() {
+		var rr models.RoomRestriction
+		if err := rows.Scan(&rr.ID, &rr.ExternalUID, &rr.StartDate, &rr.EndDate); err != nil {
+			rows.Close()
+			return sync, timeoutError(ctx, err)
+		}
+		existing = append(existing, rr)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return sync, timeoutError(ctx, err)
+	}
+
+	remove, add, moved := calendarChanges(existing, events)
+	sync.Removed = len(remove) - len(moved)
+	for _, rrID := range remove {
+		if _, err := tx.ExecContext(ctx, `delete from room_restrictions where id = $1`, rrID); err != nil {
+			return sync, timeoutError(ctx, err)
+		}
+	}
+
+	now := time.Now()
+	takenBy := func(e models.ExternalEvent) (int, bool, error) {
+		var reservationID int
+		query := `select coalesce(reservation_id, 0) from room_restrictions
+				where room_id = $1 and $2 < end_date and $3 > start_date
+				and (restriction_id <> $4 or expires_at > $5)
+				limit 1`
+		err := tx.QueryRowContext(ctx, query, roomID, e.StartDate, e.EndDate, models.RestrictionHold, now).Scan(&reservationID)
+		if err == sql.ErrNoRows {
+			return 0, false, nil
+		}
+		return reservationID, err == nil, err
+	}
+	for _, e := range add {
+		err := deleteExpiredHolds(ctx, tx, roomID, e.StartDate, e.EndDate, now)
+		if err != nil {
+			return sync, timeoutError(ctx, err)
+		}
+
+		reservationID, taken, err := takenBy(e)
+		if err != nil {
+			return sync, timeoutError(ctx, err)
+		}
+
+		if !taken {
+			//a booking committed after the check still makes the insert fail, the event is then a conflict
+			//like one found by the check
+			inserted, err := insertCalendarEvent(ctx, tx, id, roomID, e, now)
+			if err != nil {
+				return sync, timeoutError(ctx, err)
+			}
+			if !inserted {
+				if reservationID, _, err = takenBy(e); err != nil {
+					return sync, timeoutError(ctx, err)
+				}
+				taken = true
+			}
+		}
+
+		if taken {
+			sync.Conflicts = append(sync.Conflicts, models.CalendarConflict{
+				RoomCalendarID: id,
+				UID:            e.UID,
+				Summary:        e.Summary,
+				StartDate:      e.StartDate,
+				EndDate:        e.EndDate,
+				ReservationID:  reservationID,
+				CreatedAt:      now,
+			})
+			if moved[e.UID] {
+				sync.Removed++
+			}
+			continue
+		}
+		if moved[e.UID] {
+			sync.Updated++
+		} else {
+			sync.Added++
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `delete from calendar_conflicts where room_calendar_id = $1`, id); err != nil {
+		return sync, timeoutError(ctx, err)
+	}
+	for i, c := range sync.Conflicts {
+		stmt := `insert into calendar_conflicts (room_calendar_id, external_uid, summary, start_date, end_date,
+				reservation_id, created_at)
+				values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+		err := tx.QueryRowContext(ctx, stmt, id, c.UID, c.Summary, c.StartDate, c.EndDate,
+			nullInt(c.ReservationID), now).Scan(&sync.Conflicts[i].ID)
+		if err != nil {
+			return sync, timeoutError(ctx, err)
+		}
+	}
+
+	stmt := `update room_calendars set last_synced_at = $1, last_error = '', updated_at = $1 where id = $2`
+	if _, err := tx.ExecContext(ctx, stmt, now, id); err != nil {
+		return sync, timeoutError(ctx, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return sync, timeoutError(ctx, err)
+	}
+	return sync, nil
+}
+
+//SetRoomCalendarError records why a calendar could not be synced, its restrictions are left as they were
+func (m *postgresDBRepo) SetRoomCalendarError(ctx context.Context, id int, message string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update room_calendars set last_error = $1, updated_at = $2 where id = $3`
+
+	result, err := m.DB.ExecContext(ctx, stmt, message, time.Now(), id)
+	if err != nil {
+		return timeoutError(ctx, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+//UpdateOwnerBlocks blocks and frees nights of rooms for their owners in one transaction, it fails with a
+//repository.ConflictError without changing anything when a night to block is already taken
+func (m *postgresDBRepo) UpdateOwnerBlocks(ctx context.Context, changes []models.OwnerBlockChange) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return timeoutError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	rooms, byRoom := changesByRoom(changes)
+	for _, roomID := range rooms {
+		first, end := nightSpan(byRoom[roomID])
+
+		//the blocks that touch the changed nights are replaced with merged ones
+		query := `delete from room_restrictions
+				where room_id = $1 and restriction_id = $2 and start_date <= $3 and end_date >= $4
+				returning start_date, end_date`
+
+		rows, err := tx.QueryContext(ctx, query, roomID, models.RestrictionOwnerBlock, end, first)
+		if err != nil {
+			return timeoutError(ctx, err)
+		}
+		var blocks []models.RoomRestriction
+		for rows.Next() {
+			var rr models.RoomRestriction
+			if err := rows.Scan(&rr.StartDate, &rr.EndDate); err != nil {
+				rows.Close()
+				return timeoutError(ctx, err)
+			}
+			blocks = append(blocks, rr)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return timeoutError(ctx, err)
+		}
+
+		for _, rr := range ownerBlockRanges(blocks, byRoom[roomID]) {
+			stmt := `insert into room_restrictions (start_date, end_date, room_id, restriction_id,
+					created_at, updated_at)
+					values ($1, $2, $3, $4, $5, $6)`
+
+			_, err := tx.ExecContext(ctx, stmt, rr.StartDate, rr.EndDate, roomID, models.RestrictionOwnerBlock, now, now)
+			if err != nil {
+				return conflictError(timeoutError(ctx, err), roomID, rr.StartDate, rr.EndDate)
+			}
+		}
+	}
+
+	return timeoutError(ctx, tx.Commit())
+}
+
+//guestColumns is the column list scanned by scanGuest
+const guestColumns = `g.id, g.email, g.first_name, g.last_name, g.phone, g.notes,
+		(select count(*) from reservations r where r.guest_id = g.id and r.cancelled_at is null),
+		g.created_at, g.updated_at`
+
+//scanGuest scans a row selected with guestColumns
+func scanGuest(row interface{ Scan(...interface{}) error }, g *models.Guest) error {
+	return row.Scan(
+		&g.ID,
+		&g.Email,
+		&g.FirstName,
+		&g.LastName,
+		&g.Phone,
+		&g.Notes,
+		&g.Stays,
+		&g.CreatedAt,
+		&g.UpdatedAt,
+	)
+}
+
+//AllGuests returns the guest profiles with search in their name or one of their emails, all of them when
+//search is empty
+func (m *postgresDBRepo) AllGuests(ctx context.Context, search string) ([]models.Guest, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var guests []models.Guest
+
+	query := `select ` + guestColumns + ` from guests g
+			where $1 = ''
+				or strpos(lower(g.first_name || ' ' || g.last_name), lower($1)) > 0
+				or exists (select 1 from guest_emails e where e.guest_id = g.id and strpos(e.email, lower($1)) > 0)
+			order by g.last_name, g.first_name, g.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, strings.TrimSpace(search))
+	if err != nil {
+		return guests, timeoutError(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var g models.Guest
+		if err := scanGuest(rows, &g); err != nil {
+			return guests, timeoutError(ctx, err)
+		}
+		guests = append(guests, g)
+	}
+	return guests, timeoutError(ctx, rows.Err())
+}
+
+//GetGuest returns a guest profile with its emails and its stay history
+func (m *postgresDBRepo) GetGuest(ctx context.Context, id int) (models.Guest, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var g models.Guest
+
+	query := `select ` + guestColumns + ` from guests g where g.id = $1`
+
+	err := scanGuest(m.DB.QueryRowContext(ctx, query, id), &g)
+	if err == sql.ErrNoRows {
+		return g, repository.ErrNotFound
+	}
+	if err != nil {
+		return g, timeoutError(ctx, err)
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `select email from guest_emails where guest_id = $1 order by email`, id)
+	if err != nil {
+		return g, timeoutError(ctx, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return g, timeoutError(ctx, err)
+		}
+		g.Emails = append(g.Emails, email)
+	}
+	if err := rows.Err(); err != nil {
+		return g, timeoutError(ctx, err)
+	}
+
+	query = `select ` + reservationColumns + `, rm.room_name, rm.property_id
+			from reservations r join rooms rm on rm.id = r.room_id
+			where r.guest_id = $1
+			order by r.start_date desc, r.id desc`
+
+	rows, err = m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return g, timeoutError(ctx, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var res models.Reservation
+		err := scanReservation(scanWith(rows, &res.Room.RoomName, &res.Room.PropertyID), &res)
+		if err != nil {
+			return g, timeoutError(ctx, err)
+		}
+		res.Room.ID = res.RoomID
+		g.Reservations = append(g.Reservations, res)
+	}
+	return g, timeoutError(ctx, rows.Err())
+}
+
+//UpdateGuest saves the name, phone and notes of a guest profile
+func (m *postgresDBRepo) UpdateGuest(ctx context.Context, g models.Guest) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update guests set first_name = $1, last_name = $2, phone = $3, notes = $4, updated_at = $5
+			where id = $6`
+
+	result, err := m.DB.ExecContext(ctx, stmt, g.FirstName, g.LastName, g.Phone, g.Notes, time.Now(), g.ID)
+	if err != nil {
+		return timeoutError(ctx, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+//MergeGuests combines duplicate guest profiles into the one kept in one transaction, the kept profile takes
+//over their reservations, emails and notes and the duplicates are deleted
+func (m *postgresDBRepo) MergeGuests(ctx context.Context, keepID int, mergeIDs []int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return timeoutError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	lock := func(id int) (models.Guest, error) {
+		g := models.Guest{ID: id}
+		err := tx.QueryRowContext(ctx, `select first_name, last_name, phone, notes from guests where id = $1 for update`, id).
+			Scan(&g.FirstName, &g.LastName, &g.Phone, &g.Notes)
+		if err == sql.ErrNoRows {
+			return g, repository.ErrNotFound
+		}
+		return g, err
+	}
+
+	keep, err := lock(keepID)
+	if err != nil {
+		return timeoutError(ctx, err)
+	}
+
+	for _, id := range mergeIDs {
+		if id == keepID {
+			continue
+		}
+		merged, err := lock(id)
+		if err != nil {
+			return timeoutError(ctx, err)
+		}
+		mergeGuest(&keep, merged)
+
+		for _, stmt := range []string{
+			`update reservations set guest_id = $1 where guest_id = $2`,
+			`update guest_emails set guest_id = $1 where guest_id = $2`,
+		} {
+			if _, err := tx.ExecContext(ctx, stmt, keepID, id); err != nil {
+				return timeoutError(ctx, err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `delete from guests where id = $1`, id); err != nil {
+			return timeoutError(ctx, err)
+		}
+	}
+
+	stmt := `update guests set first_name = $1, last_name = $2, phone = $3, notes = $4, updated_at = $5
+			where id = $6`
+
+	_, err = tx.ExecContext(ctx, stmt, keep.FirstName, keep.LastName, keep.Phone, keep.Notes, time.Now(), keepID)
+	if err != nil {
+		return timeoutError(ctx, err)
+	}
+	return timeoutError(ctx, tx.Commit())
+}
+
+//deleteExpiredHolds removes the room's holds overlapping the dates that ran out but were not swept yet, so they
+//do not block the restriction inserted after it in the transaction
+func deleteExpiredHolds(ctx context.Context, tx *sql.Tx, roomID int, start, end, now time.Time) error {
+	stmt := `delete from room_restrictions
+			where room_id = $1 and $2 < end_date and $3 > start_date and restriction_id = $4 and expires_at <= $5`
+
+	_, err := tx.ExecContext(ctx, stmt, roomID, start, end, models.RestrictionHold, now)
+	return err
+}
+
+//insertCalendarEvent inserts the external restriction for a calendar's event, returning false when the room is
+//taken for its dates, the savepoint keeps that failure from aborting the rest of the sync's transaction
+func insertCalendarEvent(ctx context.Context, tx *sql.Tx, calendarID, roomID int, e models.ExternalEvent, now time.Time) (bool, error) {
+	if _, err := tx.ExecContext(ctx, `savepoint calendar_event`); err != nil {
+		return false, err
+	}
+
+	stmt := `insert into room_restrictions (start_date, end_date, room_id, restriction_id,
+			room_calendar_id, external_uid, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err := tx.ExecContext(ctx, stmt, e.StartDate, e.EndDate, roomID, models.RestrictionExternal, calendarID, e.UID, now, now)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == exclusionViolation {
+		_, err := tx.ExecContext(ctx, `rollback to savepoint calendar_event`)
+		return false, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `release savepoint calendar_event`)
+	return err == nil, err
+}
+
+//insertReservation inserts the reservation row as part of a booking transaction
+func insertReservation(ctx context.Context, tx *sql.Tx, res models.Reservation, now time.Time) (int, error) {
+	var newID int
+
+	guestID, err := guestForReservation(ctx, tx, res, now)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `insert into reservations (code, first_name, last_name, email, phone, start_date,
+			end_date, room_id, guests, total_price, cancellation_policy_id, booking_id, guest_id,
+			created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		res.Code,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		res.Guests,
+		res.TotalPrice,
+		nullInt(res.CancellationPolicyID),
+		nullInt(res.BookingID),
+		nullInt(guestID),
+		now,
+		now,
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	if res.PromoCodeID != 0 {
+		if err := redeemPromoCode(ctx, tx, res, newID, now); err != nil {
+			return 0, err
+		}
+	}
+
+	stmt = `insert into reservation_line_items (reservation_id, position, kind, description, night,
+			amount, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	for _, item := range res.LineItems {
+		_, err := tx.ExecContext(ctx, stmt,
+			newID,
+			item.Position,
+			item.Kind,
+			item.Description,
+			nullTime(item.Night),
+			item.Amount,
+			now,
+			now,
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for _, payment := range res.Payments {
+		payment.ReservationID = newID
+		payment.BookingID = res.BookingID
+		if err := insertPayment(ctx, tx, payment, now); err != nil {
+			return 0, err
+		}
+	}
+	return newID, nil
+}
+
+//guestForReservation finds the profile of the guest booking the reservation by its email, making one for a
+//new guest, it returns 0 for a reservation without an email
+func guestForReservation(ctx context.Context, tx *sql.Tx, res models.Reservation, now time.Time) (int, error) {
+	email := models.NormalizeEmail(res.Email)
+	if email == "" {
+		return 0, nil
+	}
+
+	var guestID int
+	err := tx.QueryRowContext(ctx, `select guest_id from guest_emails where email = $1`, email).Scan(&guestID)
+	if err != sql.ErrNoRows {
+		return guestID, err
+	}
+
+	stmt := `insert into guests (email, first_name, last_name, phone, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt, email, res.FirstName, res.LastName, res.Phone, now, now).Scan(&guestID)
+	if err != nil {
+		return 0, err
+	}
+
+	//a guest booking twice at the same time gets one profile, the email is taken by whichever commits first
+	result, err := tx.ExecContext(ctx, `insert into guest_emails (email, guest_id, created_at) values ($1, $2, $3)
+			on conflict (email) do nothing`, email, guestID, now)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := result.RowsAffected(); n == 1 {
+		return guestID, nil
+	}
+	if _, err := tx.ExecContext(ctx, `delete from guests where id = $1`, guestID); err != nil {
+		return 0, err
+	}
+	err = tx.QueryRowContext(ctx, `select guest_id from guest_emails where email = $1`, email).Scan(&guestID)
+	return guestID, err
+}
+
+//insertPayment records a payment of a reservation or a group booking as part of a booking transaction
+func insertPayment(ctx context.Context, tx *sql.Tx, payment models.Payment, now time.Time) error {
+	stmt := `insert into payments (reservation_id, booking_id, provider, provider_id, status, amount,
+			card_last4, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+	_, err := tx.ExecContext(ctx, stmt,
+		nullInt(payment.ReservationID),
+		nullInt(payment.BookingID),
+		payment.Provider,
+		payment.ProviderID,
+		payment.Status,
+		payment.Amount,
+		payment.CardLast4,
+		now,
+		now,
+	)
+	return err
+}
+
+//redeemPromoCode counts a use of the reservation's promo code and records the redemption
+func redeemPromoCode(ctx context.Context, tx *sql.Tx, res models.Reservation, reservationID int, now time.Time) error {
+	//the usage limit is checked while the promo code row is locked, so concurrent redemptions cannot overshoot it
+	stmt := `update promo_codes set times_used = times_used + 1, updated_at = $1
+			where id = $2 and (max_uses = 0 or times_used < max_uses)`
+
+	result, err := tx.ExecContext(ctx, stmt, now, res.PromoCodeID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return repository.ErrPromoCodeUsedUp
+	}
+
+	stmt = `insert into promo_redemptions (promo_code_id, reservation_id, amount, created_at, updated_at)
+			values ($1, $2, $3, $4, $5)`
+
+	_, err = tx.ExecContext(ctx, stmt, res.PromoCodeID, reservationID, res.PromoDiscount, now, now)
+	return err
+}
+
+//reservationColumns is the column list scanned by scanReservation
+const reservationColumns = `r.id, r.code, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+		r.end_date, r.room_id, r.guests, r.total_price, coalesce(r.cancellation_policy_id, 0),
+		coalesce(r.booking_id, 0), coalesce(r.guest_id, 0), r.cancelled_at, r.cancellation_fee, r.refund_amount,
+		r.settlement_error, r.created_at, r.updated_at`
+
+//scanReservation scans a row selected with reservationColumns
+func scanReservation(row interface{ Scan(...interface{}) error }, res *models.Reservation) error {
+	var cancelledAt sql.NullTime
+	err := row.Scan(
+		&res.ID,
+		&res.Code,
+		&res.FirstName,
+		&res.LastName,
+		&res.Email,
+		&res.Phone,
+		&res.StartDate,
+		&res.EndDate,
+		&res.RoomID,
+		&res.Guests,
+		&res.TotalPrice,
+		&res.CancellationPolicyID,
+		&res.BookingID,
+		&res.GuestID,
+		&cancelledAt,
+		&res.CancellationFee,
+		&res.RefundAmount,
+		&res.SettlementError,
+		&res.CreatedAt,
+		&res.UpdatedAt,
+	)
+	res.CancelledAt = cancelledAt.Time
+	return err
+}
+
+//extraColumns scans columns selected after those a scan function knows about
+type extraColumns struct {
+	row  interface{ Scan(...interface{}) error }
+	dest []interface{}
+}
+
+//Scan scans into dest followed by the extra destinations
+func (e extraColumns) Scan(dest ...interface{}) error {
+	return e.row.Scan(append(dest, e.dest...)...)
+}
+
+//scanWith lets a scan function read a row that has more columns after its own
+func scanWith(row interface{ Scan(...interface{}) error }, dest ...interface{}) extraColumns {
+	return extraColumns{row: row, dest: dest}
+}
+
+//waitlistColumns is the column list scanned by scanWaitlistEntry
+const waitlistColumns = `w.id, w.property_id, w.email, coalesce(w.room_id, 0), w.start_date, w.end_date, w.status,
+		coalesce(w.claim_room_id, 0), coalesce(w.claim_token_hash, ''), w.claim_expires_at,
+		coalesce(w.claim_hold_id, 0), w.created_at, w.updated_at`
+
+//scanWaitlistEntry scans a row selected with waitlistColumns
+func scanWaitlistEntry(row interface{ Scan(...interface{}) error }, e *models.WaitlistEntry) error {
+	var expires sql.NullTime
+	err := row.Scan(
+		&e.ID,
+		&e.PropertyID,
+		&e.Email,
+		&e.RoomID,
+		&e.StartDate,
+		&e.EndDate,
+		&e.Status,
+		&e.ClaimRoomID,
+		&e.ClaimTokenHash,
+		&expires,
+		&e.ClaimHoldID,
+		&e.CreatedAt,
+		&e.UpdatedAt,
+	)
+	e.ClaimExpiresAt = expires.Time
+	return err
+}
+
+//roomColumns is the column list scanned by scanRoom
+const roomColumns = `r.id, r.property_id, r.room_name, r.slug, r.room_type, r.max_adults, r.max_children,
+		r.bed_configuration, r.description, r.base_price, r.weekend_percent,
+		coalesce(r.cancellation_policy_id, 0), r.calendar_token, r.created_at, r.updated_at`
+
+//scanRoom scans a row selected with roomColumns
+func scanRoom(row interface{ Scan(...interface{}) error }, room *models.Room) error {
+	return row.Scan(
+		&room.ID,
+		&room.PropertyID,
+		&room.RoomName,
+		&room.Slug,
+		&room.RoomType,
+		&room.MaxAdults,
+		&room.MaxChildren,
+		&room.BedConfiguration,
+		&room.Description,
+		&room.BasePrice,
+		&room.WeekendPercent,
+		&room.CancellationPolicyID,
+		&room.CalendarToken,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+	)
+}
+
+//roomCalendarColumns is the column list scanned by scanRoomCalendar, from room_calendars c joined to rooms r
+const roomCalendarColumns = `c.id, c.room_id, c.name, c.url, c.last_synced_at, c.last_error, c.created_at,
+		c.updated_at, r.property_id, r.room_name`
+
+//scanRoomCalendar scans a row selected with roomCalendarColumns
+func scanRoomCalendar(row interface{ Scan(...interface{}) error }, c *models.RoomCalendar) error {
+	var synced sql.NullTime
+	err := row.Scan(
+		&c.ID,
+		&c.RoomID,
+		&c.Name,
+		&c.URL,
+		&synced,
+		&c.LastError,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+		&c.Room.PropertyID,
+		&c.Room.RoomName,
+	)
+	c.Room.ID = c.RoomID
+	c.LastSyncedAt = synced.Time
+	return err
+}
+
+//queryRoomCalendars selects several room calendars
+func (m *postgresDBRepo) queryRoomCalendars(ctx context.Context, query string, args ...interface{}) ([]models.RoomCalendar, error) {
+	var calendars []models.RoomCalendar
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return calendars, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.RoomCalendar
+		if err := scanRoomCalendar(rows, &c); err != nil {
+			return calendars, err
+		}
+		calendars = append(calendars, c)
+	}
+	return calendars, rows.Err()
+}
+
+//propertyColumns is the column list scanned by scanProperty
+const propertyColumns = `p.id, p.name, p.slug, p.hostname, p.email, p.phone, p.address,
+		p.time_zone, p.check_in_time, p.check_out_time, p.calendar_names, p.created_at, p.updated_at`
+
+//scanProperty scans a row selected with propertyColumns
+func scanProperty(row interface{ Scan(...interface{}) error }, p *models.Property) error {
+	return row.Scan(
+		&p.ID,
+		&p.Name,
+		&p.Slug,
+		&p.Hostname,
+		&p.Email,
+		&p.Phone,
+		&p.Address,
+		&p.TimeZone,
+		&p.CheckInTime,
+		&p.CheckOutTime,
+		&p.CalendarNames,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+}
+
+//queryProperty selects a single property
+func (m *postgresDBRepo) queryProperty(ctx context.Context, query string, args ...interface{}) (models.Property, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var p models.Property
+	err := scanProperty(m.DB.QueryRowContext(ctx, query, args...), &p)
+	if err == sql.ErrNoRows {
+		return p, repository.ErrNotFound
+	}
+	return p, timeoutError(ctx, err)
+}
+
+//queryRoom selects a single room and its amenities
+func (m *postgresDBRepo) queryRoom(ctx context.Context, query string, args ...interface{}) (models.Room, error) {
+	var room models.Room
+
+	err := scanRoom(m.DB.QueryRowContext(ctx, query, args...), &room)
+	if err == sql.ErrNoRows {
+		return room, repository.ErrNotFound
+	}
+	if err != nil {
+		return room, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `select name from room_amenities where room_id = $1 order by id`, room.ID)
+	if err != nil {
+		return room, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return room, err
+		}
+		room.Amenities = append(room.Amenities, name)
+	}
+	return room, rows.Err()
+}
+
+//queryRooms selects rooms and fills in their amenities
+func (m *postgresDBRepo) queryRooms(ctx context.Context, query string, args ...interface{}) ([]models.Room, error) {
+	var rooms []models.Room
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var room models.Room
+		if err := scanRoom(rows, &room); err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, room)
+	}
+	if err := rows.Err(); err != nil {
+		return rooms, err
+	}
+
+	if len(rooms) == 0 {
+		return rooms, nil
+	}
+	ids := make([]int, len(rooms))
+	for i, room := range rooms {
+		ids[i] = room.ID
+	}
+
+	//only the amenities of the rooms found are read
+	amenities, err := m.DB.QueryContext(ctx, `select room_id, name from room_amenities where room_id = any($1) order by id`, ids)
+	if err != nil {
+		return rooms, err
+	}
+	defer amenities.Close()
+
+	byRoom := map[int][]string{}
+	for amenities.Next() {
+		var roomID int
+		var name string
+		if err := amenities.Scan(&roomID, &name); err != nil {
+			return rooms, err
+		}
+		byRoom[roomID] = append(byRoom[roomID], name)
+	}
+	for i := range rooms {
+		rooms[i].Amenities = byRoom[rooms[i].ID]
+	}
+	return rooms, amenities.Err()
+}
+
+//replaceAmenities sets a room's amenities to the given list
+func replaceAmenities(ctx context.Context, tx *sql.Tx, roomID int, amenities []string, now time.Time) error {
+	_, err := tx.ExecContext(ctx, `delete from room_amenities where room_id = $1`, roomID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into room_amenities (room_id, name, created_at, updated_at)
+			values ($1, $2, $3, $4)`
+
+	for _, name := range amenities {
+		if _, err := tx.ExecContext(ctx, stmt, roomID, name, now, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+//ConflictError is returned when a room restriction would overlap one already held for the room
+type ConflictError struct {
+	RoomID    int
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("room %d is already taken between %s and %s",
+		e.RoomID, e.StartDate.Format("2006-01-02"), e.EndDate.Format("2006-01-02"))
+}
+
+//TimeoutError is returned when a query runs past the configured database timeout
+type TimeoutError struct {
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("database query timed out: %s", e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+//ErrHoldExpired is returned when a hold has been released before it could be confirmed
+var ErrHoldExpired = errors.New("reservation hold has expired")
+
+//ErrNotFound is returned when the row asked for does not exist
+var ErrNotFound = errors.New("not found")
+
+//ErrPromoCodeUsedUp is returned when a promo code reached its usage limit before it could be redeemed
+var ErrPromoCodeUsedUp = errors.New("promo code has been fully redeemed")
+
+//ErrAlreadyCancelled is returned when cancelling a reservation that has been cancelled before
+var ErrAlreadyCancelled = errors.New("reservation is already cancelled")
+
+//ErrAlreadySettled is returned when claiming a payment that a cancellation has settled or is settling
+var ErrAlreadySettled = errors.New("payment is already settled")
+
+//ErrResetExpired is returned when a password reset link is used after it ran out or was used before
+var ErrResetExpired = errors.New("password reset link has expired")
+
+//ErrInvalidCredentials is returned when logging in with an email or password that does not match a user
+var ErrInvalidCredentials = errors.New("invalid login credentials")
@@ -1,9 +1,77 @@
 package repository
 
-import "github.com/redblue-blur/bookings/internal/models"
+import (
+	"context"
+	"time"
+
+	"github.com/redblue-blur/bookings/internal/models"
+)
 
 type DatabaseRepo interface {
-	AllUsers() bool
-	InsertReservation(res models.Reservation) (int, error)
-	InsertRoomRestriction(r models.RoomRestriction) error
+	AllUsers(ctx context.Context) bool
+	Authenticate(ctx context.Context, email, password string) (int, error)
+	GetUserByID(ctx context.Context, id int) (models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (models.User, error)
+	InsertPasswordReset(ctx context.Context, userID int, tokenHash string, expires time.Time) (int, error)
+	GetPasswordResetByToken(ctx context.Context, tokenHash string) (models.PasswordReset, error)
+	ResetPassword(ctx context.Context, resetID int, passwordHash string) error
+	AllProperties(ctx context.Context) ([]models.Property, error)
+	GetPropertyByID(ctx context.Context, id int) (models.Property, error)
+	GetPropertyBySlug(ctx context.Context, slug string) (models.Property, error)
+	GetPropertyByHostname(ctx context.Context, hostname string) (models.Property, error)
+	InsertProperty(ctx context.Context, p models.Property) (int, error)
+	UpdateProperty(ctx context.Context, p models.Property) error
+	InsertReservation(ctx context.Context, res models.Reservation) (int, error)
+	InsertBooking(ctx context.Context, b models.Booking) (int, error)
+	InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error
+	GetReservationByID(ctx context.Context, id int) (models.Reservation, error)
+	GetReservationByCode(ctx context.Context, code string) (models.Reservation, error)
+	CancelReservation(ctx context.Context, id, fee int) error
+	SettleCancellation(ctx context.Context, id, refund int, settlementError string) error
+	SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error)
+	SearchAvailabilityForAllRooms(ctx context.Context, propertyID int, start, end time.Time) ([]models.Room, error)
+	GetRoomByID(ctx context.Context, id int) (models.Room, error)
+	GetRoomBySlug(ctx context.Context, propertyID int, slug string) (models.Room, error)
+	AllRooms(ctx context.Context, propertyID int) ([]models.Room, error)
+	InsertRoom(ctx context.Context, room models.Room) (int, error)
+	UpdateRoom(ctx context.Context, room models.Room) error
+	DeleteRoom(ctx context.Context, id int) error
+	SeasonalRatesForRoom(ctx context.Context, roomID int, start, end time.Time) ([]models.SeasonalRate, error)
+	StayDiscountsForRoom(ctx context.Context, roomID int) ([]models.StayDiscount, error)
+	StayRulesForRoom(ctx context.Context, roomID int, start, end time.Time) ([]models.StayRule, error)
+	StayRulesForProperty(ctx context.Context, propertyID int) ([]models.StayRule, error)
+	InsertStayRule(ctx context.Context, rule models.StayRule) (int, error)
+	DeleteStayRule(ctx context.Context, id int) error
+	GetCancellationPolicy(ctx context.Context, id int) (models.CancellationPolicy, error)
+	GetPromoCodeByCode(ctx context.Context, propertyID int, code string) (models.PromoCode, error)
+	AllTaxRules(ctx context.Context, propertyID int) ([]models.TaxRule, error)
+	InsertTaxRule(ctx context.Context, rule models.TaxRule) (int, error)
+	LineItemsForReservation(ctx context.Context, reservationID int) ([]models.LineItem, error)
+	PaymentsForReservation(ctx context.Context, reservationID int) ([]models.Payment, error)
+	UpdatePaymentStatus(ctx context.Context, provider, providerID, status string) error
+	ClaimPaymentSettlement(ctx context.Context, id int) error
+	ReleasePaymentSettlement(ctx context.Context, id int) error
+	InsertWaitlistEntry(ctx context.Context, e models.WaitlistEntry) (int, error)
+	WaitlistEntriesForRoom(ctx context.Context, roomID int, start, end time.Time) ([]models.WaitlistEntry, error)
+	OfferWaitlistEntry(ctx context.Context, id, roomID, holdID int, tokenHash string, expires time.Time) error
+	GetWaitlistEntryByToken(ctx context.Context, tokenHash string) (models.WaitlistEntry, error)
+	ExpireWaitlistOffers(ctx context.Context, now time.Time) ([]models.WaitlistEntry, error)
+	InsertHold(ctx context.Context, owner string, roomID int, start, end, expires time.Time) (int, error)
+	ConvertHold(ctx context.Context, holdID int, res models.Reservation) (int, error)
+	ReleaseHold(ctx context.Context, holdID int) error
+	DeleteExpiredHolds(ctx context.Context, now time.Time) (int, error)
+	SetRoomCalendarToken(ctx context.Context, roomID int, token string) error
+	RoomRestrictionsForRoom(ctx context.Context, roomID int, from time.Time) ([]models.RoomRestriction, error)
+	AllRoomCalendars(ctx context.Context) ([]models.RoomCalendar, error)
+	RoomCalendarsForProperty(ctx context.Context, propertyID int) ([]models.RoomCalendar, error)
+	GetRoomCalendar(ctx context.Context, id int) (models.RoomCalendar, error)
+	InsertRoomCalendar(ctx context.Context, c models.RoomCalendar) (int, error)
+	DeleteRoomCalendar(ctx context.Context, id int) error
+	SyncRoomCalendar(ctx context.Context, id int, events []models.ExternalEvent) (models.CalendarSync, error)
+	SetRoomCalendarError(ctx context.Context, id int, message string) error
+	UpdateOwnerBlocks(ctx context.Context, changes []models.OwnerBlockChange) error
+	AllGuests(ctx context.Context, search string) ([]models.Guest, error)
+	GetGuest(ctx context.Context, id int) (models.Guest, error)
+	UpdateGuest(ctx context.Context, g models.Guest) error
+	MergeGuests(ctx context.Context, keepID int, mergeIDs []int) error
 }
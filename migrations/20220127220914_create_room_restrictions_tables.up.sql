@@ -0,0 +1,11 @@
+create table room_restrictions (
+    id serial primary key,
+    restriction_name varchar(255) not null default '',
+    start_date date not null,
+    end_date date not null,
+    room_id integer not null,
+    reservation_id integer not null,
+    restriction_id integer not null,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
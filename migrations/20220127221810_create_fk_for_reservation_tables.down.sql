@@ -0,0 +1 @@
+alter table reservations drop constraint if exists reservations_rooms_id_fk;
@@ -0,0 +1,3 @@
+alter table room_restrictions drop constraint if exists room_restrictions_reservations_id_fk;
+alter table room_restrictions drop constraint if exists room_restrictions_restrictions_id_fk;
+alter table room_restrictions drop constraint if exists room_restrictions_rooms_id_fk;
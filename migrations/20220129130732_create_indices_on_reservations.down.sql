@@ -0,0 +1,2 @@
+drop index if exists reservations_last_name_idx;
+drop index if exists reservations_email_idx;
@@ -0,0 +1,2 @@
+delete from restrictions where id = 1;
+delete from rooms where id in (1, 2);
@@ -0,0 +1,6 @@
+insert into rooms (id, room_name, created_at, updated_at)
+    values (1, 'General''s Quarters', now(), now()), (2, 'Major''s Suite', now(), now());
+insert into restrictions (id, restriction_name, created_at, updated_at)
+    values (1, 'Reservation', now(), now());
+select setval('rooms_id_seq', (select max(id) from rooms));
+select setval('restrictions_id_seq', (select max(id) from restrictions));
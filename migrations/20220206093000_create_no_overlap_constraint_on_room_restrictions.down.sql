@@ -0,0 +1 @@
+alter table room_restrictions drop constraint if exists room_restrictions_no_overlap;
@@ -0,0 +1,3 @@
+drop index if exists room_restrictions_restriction_id_created_at_idx;
+delete from room_restrictions where restriction_id = 3;
+delete from restrictions where id = 3;
@@ -0,0 +1,4 @@
+insert into restrictions (id, restriction_name, created_at, updated_at)
+    values (3, 'Hold', now(), now());
+select setval('restrictions_id_seq', (select max(id) from restrictions));
+create index room_restrictions_restriction_id_created_at_idx on room_restrictions (restriction_id, created_at);
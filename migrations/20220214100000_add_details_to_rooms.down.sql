@@ -0,0 +1,10 @@
+drop table if exists room_amenities;
+drop index if exists rooms_slug_idx;
+alter table rooms
+    drop column slug,
+    drop column room_type,
+    drop column max_adults,
+    drop column max_children,
+    drop column bed_configuration,
+    drop column description,
+    drop column base_price;
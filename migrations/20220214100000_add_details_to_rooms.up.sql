@@ -0,0 +1,37 @@
+alter table rooms
+    add column slug varchar(255) not null default '',
+    add column room_type varchar(255) not null default '',
+    add column max_adults integer not null default 2,
+    add column max_children integer not null default 0,
+    add column bed_configuration varchar(255) not null default '',
+    add column description text not null default '',
+    add column base_price integer not null default 0;
+
+update rooms set slug = 'generals-quarters', room_type = 'Double', max_adults = 2, max_children = 1,
+    bed_configuration = '1 queen bed', base_price = 8900,
+    description = 'A quiet room overlooking the garden, with a writing desk and a deep bath.'
+    where id = 1;
+update rooms set slug = 'majors-suite', room_type = 'Suite', max_adults = 2, max_children = 2,
+    bed_configuration = '1 king bed, 1 sofa bed', base_price = 12900,
+    description = 'Our largest suite, with a separate sitting room and a view of the bay.'
+    where id = 2;
+
+create unique index rooms_slug_idx on rooms (slug);
+
+create table room_amenities (
+    id serial primary key,
+    room_id integer not null references rooms (id) on delete cascade on update cascade,
+    name varchar(255) not null,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+create index room_amenities_room_id_idx on room_amenities (room_id);
+
+insert into room_amenities (room_id, name, created_at, updated_at) values
+    (1, 'Wi-Fi', now(), now()),
+    (1, 'Bath tub', now(), now()),
+    (1, 'Garden view', now(), now()),
+    (2, 'Wi-Fi', now(), now()),
+    (2, 'Sitting room', now(), now()),
+    (2, 'Sea view', now(), now()),
+    (2, 'Coffee machine', now(), now());
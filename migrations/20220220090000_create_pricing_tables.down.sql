@@ -0,0 +1,4 @@
+drop table if exists stay_discounts;
+drop table if exists seasonal_rates;
+alter table reservations drop column total_price;
+alter table rooms drop column weekend_percent;
@@ -0,0 +1,28 @@
+alter table rooms add column weekend_percent integer not null default 0;
+alter table reservations add column total_price integer not null default 0;
+
+update rooms set weekend_percent = 15 where id in (1, 2);
+
+create table seasonal_rates (
+    id serial primary key,
+    room_id integer not null references rooms (id) on delete cascade on update cascade,
+    name varchar(255) not null default '',
+    start_date date not null,
+    end_date date not null,
+    nightly_price integer not null,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+create index seasonal_rates_room_id_start_date_end_date_idx on seasonal_rates (room_id, start_date, end_date);
+
+create table stay_discounts (
+    id serial primary key,
+    room_id integer references rooms (id) on delete cascade on update cascade,
+    min_nights integer not null,
+    percent integer not null,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+insert into stay_discounts (room_id, min_nights, percent, created_at, updated_at)
+    values (null, 7, 10, now(), now()), (null, 14, 15, now(), now());
@@ -0,0 +1,3 @@
+drop table if exists promo_redemptions;
+drop table if exists promo_code_rooms;
+drop table if exists promo_codes;
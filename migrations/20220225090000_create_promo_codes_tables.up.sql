@@ -0,0 +1,33 @@
+create table promo_codes (
+    id serial primary key,
+    code varchar(255) not null,
+    percent_off integer not null default 0,
+    amount_off integer not null default 0,
+    valid_from date not null,
+    valid_to date not null,
+    min_nights integer not null default 0,
+    max_uses integer not null default 0,
+    times_used integer not null default 0,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+create unique index promo_codes_code_idx on promo_codes (code);
+
+create table promo_code_rooms (
+    promo_code_id integer not null references promo_codes (id) on delete cascade on update cascade,
+    room_id integer not null references rooms (id) on delete cascade on update cascade,
+    primary key (promo_code_id, room_id)
+);
+
+create table promo_redemptions (
+    id serial primary key,
+    promo_code_id integer not null references promo_codes (id) on delete cascade on update cascade,
+    reservation_id integer not null references reservations (id) on delete cascade on update cascade,
+    amount integer not null,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+create index promo_redemptions_reservation_id_idx on promo_redemptions (reservation_id);
+
+insert into promo_codes (code, percent_off, valid_from, valid_to, created_at, updated_at)
+    values ('WELCOME10', 10, '2022-01-01', '2099-12-31', now(), now());
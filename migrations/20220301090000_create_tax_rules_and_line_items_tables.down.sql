@@ -0,0 +1,3 @@
+drop table if exists reservation_line_items;
+drop table if exists tax_rules;
+alter table reservations drop column guests;
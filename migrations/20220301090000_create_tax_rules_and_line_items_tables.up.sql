@@ -0,0 +1,25 @@
+alter table reservations add column guests integer not null default 1;
+
+create table tax_rules (
+    id serial primary key,
+    kind varchar(20) not null,
+    name varchar(255) not null,
+    amount integer not null,
+    effective_from date not null,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+create index tax_rules_kind_effective_from_idx on tax_rules (kind, effective_from);
+
+create table reservation_line_items (
+    id serial primary key,
+    reservation_id integer not null references reservations (id) on delete cascade on update cascade,
+    position integer not null,
+    kind varchar(20) not null,
+    description varchar(255) not null,
+    night date,
+    amount integer not null,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+create index reservation_line_items_reservation_id_idx on reservation_line_items (reservation_id);
@@ -0,0 +1,13 @@
+create table payments (
+    id serial primary key,
+    reservation_id integer not null references reservations (id) on delete cascade on update cascade,
+    provider varchar(50) not null,
+    provider_id varchar(255) not null,
+    status varchar(50) not null,
+    amount integer not null,
+    card_last4 varchar(4) not null default '',
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+create unique index payments_provider_provider_id_idx on payments (provider, provider_id);
+create index payments_reservation_id_idx on payments (reservation_id);
@@ -0,0 +1,9 @@
+alter table reservations
+    drop column refund_amount,
+    drop column cancellation_fee,
+    drop column cancelled_at,
+    drop column cancellation_policy_id,
+    drop column code;
+alter table seasonal_rates drop column cancellation_policy_id;
+alter table rooms drop column cancellation_policy_id;
+drop table if exists cancellation_policies;
@@ -0,0 +1,32 @@
+create table cancellation_policies (
+    id serial primary key,
+    name varchar(255) not null,
+    free_days integer not null default 0,
+    penalty_type varchar(20) not null,
+    penalty_percent integer not null default 0,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+insert into cancellation_policies (name, free_days, penalty_type, penalty_percent, created_at, updated_at)
+    values ('Flexible', 1, 'first_night', 0, now(), now()),
+           ('Moderate', 7, 'percent', 50, now(), now());
+
+alter table rooms add column cancellation_policy_id integer
+    references cancellation_policies (id) on delete set null on update cascade;
+alter table seasonal_rates add column cancellation_policy_id integer
+    references cancellation_policies (id) on delete set null on update cascade;
+
+update rooms set cancellation_policy_id = 1 where id in (1, 2);
+
+alter table reservations
+    add column code varchar(20),
+    add column cancellation_policy_id integer
+        references cancellation_policies (id) on delete set null on update cascade,
+    add column cancelled_at timestamp,
+    add column cancellation_fee integer not null default 0,
+    add column refund_amount integer not null default 0;
+
+update reservations set code = upper(substr(md5(random()::text || id::text), 1, 10));
+alter table reservations alter column code set not null;
+create unique index reservations_code_idx on reservations (code);
@@ -0,0 +1,15 @@
+create table waitlist_entries (
+    id serial primary key,
+    email varchar(255) not null,
+    room_id integer references rooms (id) on delete cascade on update cascade,
+    start_date date not null,
+    end_date date not null,
+    status varchar(20) not null default 'waiting',
+    claim_room_id integer references rooms (id) on delete set null on update cascade,
+    claim_token_hash varchar(64),
+    claim_expires_at timestamp,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+create index waitlist_entries_status_start_date_end_date_idx on waitlist_entries (status, start_date, end_date);
+create unique index waitlist_entries_claim_token_hash_idx on waitlist_entries (claim_token_hash);
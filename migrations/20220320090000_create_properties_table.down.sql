@@ -0,0 +1,13 @@
+alter table waitlist_entries drop column if exists property_id;
+
+drop index if exists promo_codes_property_id_code_idx;
+alter table promo_codes drop column if exists property_id;
+create unique index promo_codes_code_idx on promo_codes (code);
+
+alter table tax_rules drop column if exists property_id;
+
+drop index if exists rooms_property_id_slug_idx;
+alter table rooms drop column if exists property_id;
+create unique index rooms_slug_idx on rooms (slug);
+
+drop table if exists properties;
@@ -0,0 +1,37 @@
+create table properties (
+    id serial primary key,
+    name varchar(255) not null,
+    slug varchar(255) not null,
+    hostname varchar(255) not null default '',
+    email varchar(255) not null default '',
+    phone varchar(255) not null default '',
+    address text not null default '',
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+create unique index properties_slug_idx on properties (slug);
+create unique index properties_hostname_idx on properties (hostname) where hostname <> '';
+
+insert into properties (name, slug, email, created_at, updated_at)
+    values ('Fort Smythe Bed and Breakfast', 'fort-smythe', 'bookings@localhost', now(), now());
+
+alter table rooms add column property_id integer references properties (id) on delete cascade on update cascade;
+update rooms set property_id = (select min(id) from properties);
+alter table rooms alter column property_id set not null;
+drop index rooms_slug_idx;
+create unique index rooms_property_id_slug_idx on rooms (property_id, slug);
+
+alter table tax_rules add column property_id integer references properties (id) on delete cascade on update cascade;
+update tax_rules set property_id = (select min(id) from properties);
+alter table tax_rules alter column property_id set not null;
+create index tax_rules_property_id_idx on tax_rules (property_id);
+
+alter table promo_codes add column property_id integer references properties (id) on delete cascade on update cascade;
+update promo_codes set property_id = (select min(id) from properties);
+alter table promo_codes alter column property_id set not null;
+drop index promo_codes_code_idx;
+create unique index promo_codes_property_id_code_idx on promo_codes (property_id, code);
+
+alter table waitlist_entries add column property_id integer references properties (id) on delete cascade on update cascade;
+update waitlist_entries set property_id = (select min(id) from properties);
+alter table waitlist_entries alter column property_id set not null;
@@ -0,0 +1,4 @@
+alter table properties
+    drop column if exists time_zone,
+    drop column if exists check_in_time,
+    drop column if exists check_out_time;
@@ -0,0 +1,4 @@
+alter table properties
+    add column time_zone varchar(255) not null default 'UTC',
+    add column check_in_time varchar(5) not null default '15:00',
+    add column check_out_time varchar(5) not null default '11:00';
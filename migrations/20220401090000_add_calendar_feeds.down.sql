@@ -0,0 +1,2 @@
+alter table properties drop column if exists calendar_names;
+alter table rooms drop column if exists calendar_token;
@@ -0,0 +1,2 @@
+alter table rooms add column calendar_token varchar(255) not null default '';
+alter table properties add column calendar_names varchar(20) not null default 'hidden';
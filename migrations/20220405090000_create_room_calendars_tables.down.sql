@@ -0,0 +1,8 @@
+delete from room_restrictions where restriction_id = 4;
+delete from restrictions where id = 4;
+drop table if exists calendar_conflicts;
+drop index if exists room_restrictions_room_calendar_id_external_uid_idx;
+alter table room_restrictions
+    drop column if exists room_calendar_id,
+    drop column if exists external_uid;
+drop table if exists room_calendars;
@@ -0,0 +1,33 @@
+create table room_calendars (
+    id serial primary key,
+    room_id integer not null references rooms (id) on delete cascade on update cascade,
+    name varchar(255) not null default '',
+    url text not null,
+    last_synced_at timestamp,
+    last_error text not null default '',
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+create index room_calendars_room_id_idx on room_calendars (room_id);
+
+alter table room_restrictions
+    add column room_calendar_id integer references room_calendars (id) on delete cascade on update cascade,
+    add column external_uid varchar(255) not null default '';
+create unique index room_restrictions_room_calendar_id_external_uid_idx
+    on room_restrictions (room_calendar_id, external_uid) where room_calendar_id is not null;
+
+create table calendar_conflicts (
+    id serial primary key,
+    room_calendar_id integer not null references room_calendars (id) on delete cascade on update cascade,
+    external_uid varchar(255) not null,
+    summary text not null default '',
+    start_date date not null,
+    end_date date not null,
+    reservation_id integer references reservations (id) on delete set null on update cascade,
+    created_at timestamp not null
+);
+create index calendar_conflicts_room_calendar_id_idx on calendar_conflicts (room_calendar_id);
+
+insert into restrictions (id, restriction_name, created_at, updated_at)
+    values (4, 'External', now(), now());
+select setval('restrictions_id_seq', (select max(id) from restrictions));
@@ -0,0 +1,3 @@
+drop index if exists room_restrictions_room_id_restriction_id_idx;
+delete from room_restrictions where restriction_id = 2;
+delete from restrictions where id = 2;
@@ -0,0 +1,4 @@
+insert into restrictions (id, restriction_name, created_at, updated_at)
+    values (2, 'Owner block', now(), now());
+select setval('restrictions_id_seq', (select max(id) from restrictions));
+create index room_restrictions_room_id_restriction_id_idx on room_restrictions (room_id, restriction_id);
@@ -0,0 +1,9 @@
+delete from payments where reservation_id is null;
+drop index if exists payments_booking_id_idx;
+alter table payments
+    drop constraint if exists payments_reservation_id_or_booking_id_check,
+    alter column reservation_id set not null,
+    drop column if exists booking_id;
+drop index if exists reservations_booking_id_idx;
+alter table reservations drop column if exists booking_id;
+drop table if exists bookings;
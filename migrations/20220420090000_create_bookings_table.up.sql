@@ -0,0 +1,22 @@
+create table bookings (
+    id serial primary key,
+    code varchar(20) not null,
+    first_name varchar(255) not null default '',
+    last_name varchar(255) not null default '',
+    email varchar(255) not null,
+    phone varchar(255) not null default '',
+    total_price integer not null default 0,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+create unique index bookings_code_idx on bookings (code);
+
+alter table reservations
+    add column booking_id integer references bookings (id) on delete set null on update cascade;
+create index reservations_booking_id_idx on reservations (booking_id);
+
+alter table payments
+    add column booking_id integer references bookings (id) on delete cascade on update cascade,
+    alter column reservation_id drop not null,
+    add constraint payments_reservation_id_or_booking_id_check check (reservation_id is not null or booking_id is not null);
+create index payments_booking_id_idx on payments (booking_id);
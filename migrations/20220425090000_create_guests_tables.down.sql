@@ -0,0 +1,4 @@
+drop index if exists reservations_guest_id_idx;
+alter table reservations drop column if exists guest_id;
+drop table if exists guest_emails;
+drop table if exists guests;
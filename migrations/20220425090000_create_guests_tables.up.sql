@@ -0,0 +1,34 @@
+create table guests (
+    id serial primary key,
+    email varchar(255) not null,
+    first_name varchar(255) not null default '',
+    last_name varchar(255) not null default '',
+    phone varchar(255) not null default '',
+    notes text not null default '',
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+
+create table guest_emails (
+    email varchar(255) primary key,
+    guest_id integer not null references guests (id) on delete cascade on update cascade,
+    created_at timestamp not null
+);
+create index guest_emails_guest_id_idx on guest_emails (guest_id);
+
+alter table reservations
+    add column guest_id integer references guests (id) on delete set null on update cascade;
+create index reservations_guest_id_idx on reservations (guest_id);
+
+insert into guests (email, first_name, last_name, phone, created_at, updated_at)
+    select distinct on (lower(trim(email))) lower(trim(email)), first_name, last_name, phone, now(), now()
+    from reservations
+    where trim(email) <> ''
+    order by lower(trim(email)), created_at desc;
+
+insert into guest_emails (email, guest_id, created_at)
+    select email, id, now() from guests;
+
+update reservations r set guest_id = e.guest_id
+    from guest_emails e
+    where e.email = lower(trim(r.email));
@@ -0,0 +1,2 @@
+alter table users drop column if exists session_version;
+drop table if exists password_resets;
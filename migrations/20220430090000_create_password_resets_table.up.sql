@@ -0,0 +1,13 @@
+create table password_resets (
+    id serial primary key,
+    user_id integer not null references users (id) on delete cascade on update cascade,
+    token_hash varchar(64) not null,
+    expires_at timestamp not null,
+    used_at timestamp,
+    created_at timestamp not null,
+    updated_at timestamp not null
+);
+create unique index password_resets_token_hash_idx on password_resets (token_hash);
+create index password_resets_user_id_idx on password_resets (user_id);
+
+alter table users add column session_version integer not null default 1;
@@ -0,0 +1,2 @@
+alter table reservations drop column if exists settlement_error;
+alter table payments drop column if exists settled_at;
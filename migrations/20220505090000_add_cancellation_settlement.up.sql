@@ -0,0 +1,6 @@
+alter table payments add column settled_at timestamp;
+alter table reservations add column settlement_error text not null default '';
+
+update payments set settled_at = r.cancelled_at
+    from reservations r
+    where r.id = payments.reservation_id and r.cancelled_at is not null;
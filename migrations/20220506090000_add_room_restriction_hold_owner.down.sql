@@ -0,0 +1,2 @@
+drop index if exists room_restrictions_hold_owner_idx;
+alter table room_restrictions drop column if exists hold_owner;
@@ -0,0 +1,2 @@
+alter table room_restrictions add column hold_owner varchar(64);
+create unique index room_restrictions_hold_owner_idx on room_restrictions (hold_owner) where restriction_id = 3;
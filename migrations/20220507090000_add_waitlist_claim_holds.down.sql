@@ -0,0 +1,2 @@
+alter table waitlist_entries drop column if exists claim_hold_id;
+alter table room_restrictions drop column if exists expires_at;
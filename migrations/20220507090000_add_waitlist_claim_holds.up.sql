@@ -0,0 +1,5 @@
+alter table room_restrictions add column expires_at timestamp;
+update room_restrictions set expires_at = created_at + interval '15 minutes' where restriction_id = 3;
+
+alter table waitlist_entries add column claim_hold_id integer
+    references room_restrictions (id) on delete set null on update cascade;
@@ -0,0 +1,2 @@
+drop index if exists users_lower_email_idx;
+create unique index users_email_idx on users (email);
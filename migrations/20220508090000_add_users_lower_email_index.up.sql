@@ -0,0 +1,10 @@
+do $$
+begin
+    if exists (select 1 from users group by lower(trim(email)) having count(*) > 1) then
+        raise exception 'some users share an email that only differs in case, merge them before migrating';
+    end if;
+end $$;
+
+drop index if exists users_email_idx;
+update users set email = lower(trim(email)) where email <> lower(trim(email));
+create unique index users_lower_email_idx on users (lower(email));
@@ -0,0 +1,9 @@
+//Package migrations embeds the sql migrations so the binary can bring up a fresh database
+package migrations
+
+import "embed"
+
+//FS holds every <version>_<name>.up.sql and .down.sql file in this folder
+//
+//go:embed *.sql
+var FS embed.FS